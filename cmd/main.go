@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"strconv"
@@ -20,9 +21,12 @@ import (
 	"github.com/mainflux/agent/pkg/bootstrap"
 	"github.com/mainflux/agent/pkg/conn"
 	"github.com/mainflux/agent/pkg/edgex"
+	"github.com/mainflux/agent/pkg/encoder"
+	"github.com/mainflux/agent/pkg/queue"
 	"github.com/mainflux/mainflux"
 	"github.com/mainflux/mainflux/logger"
 	"github.com/mainflux/mainflux/pkg/errors"
+	"github.com/mainflux/mainflux/pkg/messaging"
 	"github.com/mainflux/mainflux/pkg/messaging/brokers"
 	nats "github.com/nats-io/nats.go"
 	stdprometheus "github.com/prometheus/client_golang/prometheus"
@@ -42,6 +46,7 @@ const (
 	defMqttURL                    = "localhost:1883"
 	defCtrlChan                   = ""
 	defDataChan                   = ""
+	defContentFormat              = "senml+json"
 	defEncryption                 = "false"
 	defMqttUsername               = ""
 	defMqttPassword               = ""
@@ -52,6 +57,9 @@ const (
 	defMqttRetain                 = "false"
 	defMqttCert                   = "thing.cert"
 	defMqttPrivKey                = "thing.key"
+	defMqttClientID               = ""
+	defMqttPersistent             = "false"
+	defMqttProtocolVersion        = "4"
 	defConfigFile                 = "config.toml"
 	defNatsURL                    = nats.DefaultURL
 	defHeartbeatInterval          = "10s"
@@ -69,6 +77,7 @@ const (
 	envBootstrapRetryDelaySeconds = "MF_AGENT_BOOTSTRAP_RETRY_DELAY_SECONDS"
 	envCtrlChan                   = "MF_AGENT_CONTROL_CHANNEL"
 	envDataChan                   = "MF_AGENT_DATA_CHANNEL"
+	envContentFormat              = "MF_AGENT_CONTENT_FORMAT"
 	envEncryption                 = "MF_AGENT_ENCRYPTION"
 	envNatsURL                    = "MF_AGENT_NATS_URL"
 
@@ -81,6 +90,9 @@ const (
 	envMqttRetain         = "MF_AGENT_MQTT_RETAIN"
 	envMqttCert           = "MF_AGENT_MQTT_CLIENT_CERT"
 	envMqttPrivKey        = "MF_AGENT_MQTT_CLIENT_PK"
+	envMqttClientID       = "MF_AGENT_MQTT_CLIENT_ID"
+	envMqttPersistent     = "MF_AGENT_MQTT_PERSISTENT_SESSION"
+	envMqttProtocol       = "MF_AGENT_MQTT_PROTOCOL_VERSION"
 	envHeartbeatInterval  = "MF_AGENT_HEARTBEAT_INTERVAL"
 	envTermSessionTimeout = "MF_AGENT_TERMINAL_SESSION_TIMEOUT"
 )
@@ -90,6 +102,7 @@ var (
 	errFetchingBootstrapFailed = errors.New("Fetching bootstrap failed with error")
 	errFailedToReadConfig      = errors.New("Failed to read config")
 	errFailedToConfigHeartbeat = errors.New("Failed to configure heartbeat")
+	errMQTTProtocolVersion     = errors.New("Unsupported MQTT protocol version, 3 (3.1), 4 (3.1.1) and 5 are supported")
 )
 
 func main() {
@@ -115,13 +128,22 @@ func main() {
 	if err != nil {
 		logger.Fatal(fmt.Sprintf("Failed to connect to Broker: %s %s", err, cfg.Server.BrokerURL))
 	}
+	pubsub = withConnectionState(pubsub, cfg.Server.BrokerURL, logger)
 	defer pubsub.Close()
 
-	mqttClient, err := connectToMQTTBroker(cfg.MQTT, logger)
+	enc, err := encoder.New(cfg.Channels.Format)
 	if err != nil {
 		logger.Error(err.Error())
 		return
 	}
+	presence := conn.NewPresence(cfg.Channels.Control, enc, logger)
+	session := conn.NewSession(logger)
+	mqttClient, err := connectToMQTTBroker(cfg.MQTT, session, presence, logger)
+	if err != nil {
+		logger.Error(err.Error())
+		return
+	}
+	stdprometheus.MustRegister(conn.Collectors(mqttClient)...)
 	edgexClient := edgex.NewClient(cfg.Edgex.URL, logger)
 
 	svc, err := agent.New(ctx, mqttClient, &cfg, edgexClient, pubsub, logger)
@@ -130,6 +152,8 @@ func main() {
 		return
 	}
 
+	registerQueueMetrics(svc)
+
 	svc = api.LoggingMiddleware(svc, logger)
 	svc = api.MetricsMiddleware(
 		svc,
@@ -146,11 +170,11 @@ func main() {
 			Help:      "Total duration of requests in microseconds.",
 		}, []string{"method"}),
 	)
-	b := conn.NewBroker(svc, mqttClient, cfg.Channels.Control, pubsub, logger)
+	b := conn.NewBroker(svc, mqttClient, session, cfg.Channels.Control, pubsub, logger)
 
 	srv := &http.Server{
 		Addr:    fmt.Sprintf(":%s", cfg.Server.Port),
-		Handler: api.MakeHandler(svc),
+		Handler: api.MakeHandler(svc, mqttClient),
 	}
 
 	g.Go(func() error {
@@ -163,7 +187,9 @@ func main() {
 	})
 
 	g.Go(func() error {
-		return StopSignalHandler(ctx, cancel, logger, "agent", srv)
+		return StopSignalHandler(ctx, cancel, logger, "agent", srv, func() error {
+			return presence.Offline(mqttClient)
+		})
 	})
 
 	if err := g.Wait(); err != nil {
@@ -171,6 +197,63 @@ func main() {
 	}
 }
 
+// statePubSub reports connection state of the broker, which is reported
+// in the agent heartbeat. Connection is separate from the pubsub one,
+// since the pubsub doesn't expose it.
+type statePubSub struct {
+	messaging.PubSub
+	conn *nats.Conn
+}
+
+func (ps statePubSub) IsConnected() bool {
+	return ps.conn.IsConnected()
+}
+
+func (ps statePubSub) Close() error {
+	ps.conn.Close()
+	return ps.PubSub.Close()
+}
+
+// withConnectionState returns pubsub reporting connection state, or the
+// given one if the broker is not NATS.
+func withConnectionState(ps messaging.PubSub, url string, logger logger.Logger) messaging.PubSub {
+	nc, err := nats.Connect(url, nats.MaxReconnects(-1))
+	if err != nil {
+		logger.Warn(fmt.Sprintf("Broker connection state is not reported: %s", err))
+		return ps
+	}
+	return statePubSub{PubSub: ps, conn: nc}
+}
+
+// registerQueueMetrics exposes outbound queue counters in /metrics.
+func registerQueueMetrics(svc agent.Service) {
+	gauge := func(name, help string, value func(queue.Stats) float64) {
+		stdprometheus.MustRegister(stdprometheus.NewGaugeFunc(stdprometheus.GaugeOpts{
+			Namespace: "agent",
+			Subsystem: "queue",
+			Name:      name,
+			Help:      help,
+		}, func() float64 {
+			return value(svc.QueueStats())
+		}))
+	}
+	counter := func(name, help string, value func(queue.Stats) float64) {
+		stdprometheus.MustRegister(stdprometheus.NewCounterFunc(stdprometheus.CounterOpts{
+			Namespace: "agent",
+			Subsystem: "queue",
+			Name:      name,
+			Help:      help,
+		}, func() float64 {
+			return value(svc.QueueStats())
+		}))
+	}
+	gauge("depth", "Number of queued messages.", func(s queue.Stats) float64 { return float64(s.Depth) })
+	gauge("size_bytes", "Size of queued messages in bytes.", func(s queue.Stats) float64 { return float64(s.Size) })
+	counter("forwarded_total", "Number of queued messages sent after reconnect.", func(s queue.Stats) float64 { return float64(s.Forwarded) })
+	counter("dropped_full_total", "Number of messages dropped because the queue was full.", func(s queue.Stats) float64 { return float64(s.DroppedFull) })
+	counter("dropped_expired_total", "Number of messages dropped because they expired.", func(s queue.Stats) float64 { return float64(s.DroppedExpired) })
+}
+
 func loadEnvConfig() (agent.Config, error) {
 	sc := agent.ServerConfig{
 		BrokerURL: mainflux.Env(envNatsURL, defNatsURL),
@@ -179,6 +262,7 @@ func loadEnvConfig() (agent.Config, error) {
 	cc := agent.ChanConfig{
 		Control: mainflux.Env(envCtrlChan, defCtrlChan),
 		Data:    mainflux.Env(envDataChan, defDataChan),
+		Format:  mainflux.Env(envContentFormat, defContentFormat),
 	}
 	interval, err := time.ParseDuration(mainflux.Env(envHeartbeatInterval, defHeartbeatInterval))
 	if err != nil {
@@ -218,6 +302,16 @@ func loadEnvConfig() (agent.Config, error) {
 		retain = false
 	}
 
+	persistent, err := strconv.ParseBool(mainflux.Env(envMqttPersistent, defMqttPersistent))
+	if err != nil {
+		persistent = false
+	}
+
+	protocol, err := strconv.ParseUint(mainflux.Env(envMqttProtocol, defMqttProtocolVersion), 10, 8)
+	if err != nil {
+		return agent.Config{}, errors.Wrap(errMQTTProtocolVersion, err)
+	}
+
 	mc := agent.MQTTConfig{
 		URL:         mainflux.Env(envMqttURL, defMqttURL),
 		Username:    mainflux.Env(envMqttUsername, defMqttUsername),
@@ -229,10 +323,44 @@ func loadEnvConfig() (agent.Config, error) {
 		SkipTLSVer:  skipTLSVer,
 		QoS:         byte(qos),
 		Retain:      retain,
+		ClientID:    mainflux.Env(envMqttClientID, defMqttClientID),
+		Persistent:  persistent,
+		Protocol:    uint(protocol),
 	}
 
 	file := mainflux.Env(envConfigFile, defConfigFile)
-	c := agent.NewConfig(sc, cc, ec, lc, mc, ch, ct, file)
+
+	// Exec policy, trusted keys, audit log, outbound queue, per channel payload
+	// formats, MQTT brokers list, WebSocket settings, services file, eviction
+	// TTL and events debounce and flap detection can only be set in the config
+	// file, so keep them when the file is rewritten from environment.
+	xc := agent.ExecConfig{}
+	sec := agent.SecurityConfig{}
+	ac := agent.AuditConfig{}
+	qc := agent.QueueConfig{}
+	// Existing config file which can't be read, e.g. because of invalid
+	// policy patterns, fails the startup instead of being overwritten.
+	if _, err := os.Stat(file); err == nil {
+		fc, err := agent.ReadConfig(file)
+		if err != nil {
+			return agent.Config{}, errors.Wrap(errFailedToReadConfig, err)
+		}
+		xc = fc.Exec
+		sec = fc.Security
+		ac = fc.Audit
+		qc = fc.Queue
+		cc.Formats = fc.Channels.Formats
+		mc.Brokers = fc.MQTT.Brokers
+		mc.Failover = fc.MQTT.Failover
+		mc.WebSocket = fc.MQTT.WebSocket
+		ch.Debounce = fc.Heartbeat.Debounce
+		ch.FlapThreshold = fc.Heartbeat.FlapThreshold
+		ch.FlapWindow = fc.Heartbeat.FlapWindow
+		ch.TTL = fc.Heartbeat.TTL
+		ch.File = fc.Heartbeat.File
+	}
+
+	c := agent.NewConfig(sc, cc, ec, lc, mc, ch, ct, xc, sec, ac, qc, file)
 	mc, err = loadCertificate(c.MQTT)
 	if err != nil {
 		return c, errors.Wrap(errFailedToSetupMTLS, err)
@@ -270,6 +398,18 @@ func loadBootConfig(c agent.Config, logger logger.Logger) (agent.Config, error)
 		return c, errors.Wrap(errFailedToReadConfig, err)
 	}
 
+	if bsc.MQTT.ClientID == "" {
+		bsc.MQTT.ClientID = c.MQTT.ClientID
+	}
+
+	if !bsc.MQTT.Persistent {
+		bsc.MQTT.Persistent = c.MQTT.Persistent
+	}
+
+	if bsc.MQTT.Protocol == 0 {
+		bsc.MQTT.Protocol = c.MQTT.Protocol
+	}
+
 	mc, err := loadCertificate(bsc.MQTT)
 	if err != nil {
 		return bsc, errors.Wrap(errFailedToSetupMTLS, err)
@@ -279,37 +419,126 @@ func loadBootConfig(c agent.Config, logger logger.Logger) (agent.Config, error)
 		bsc.Heartbeat.Interval = c.Heartbeat.Interval
 	}
 
+	if bsc.Heartbeat.TTL <= 0 {
+		bsc.Heartbeat.TTL = c.Heartbeat.TTL
+	}
+
+	if bsc.Heartbeat.File == "" {
+		bsc.Heartbeat.File = c.Heartbeat.File
+	}
+
+	if bsc.Heartbeat.Debounce <= 0 && bsc.Heartbeat.FlapThreshold <= 0 {
+		bsc.Heartbeat.Debounce = c.Heartbeat.Debounce
+		bsc.Heartbeat.FlapThreshold = c.Heartbeat.FlapThreshold
+		bsc.Heartbeat.FlapWindow = c.Heartbeat.FlapWindow
+	}
+
 	if bsc.Terminal.SessionTimeout <= 0 {
 		bsc.Terminal.SessionTimeout = c.Terminal.SessionTimeout
 	}
 
+	if !bsc.Exec.Policy.Enabled {
+		bsc.Exec.Policy = c.Exec.Policy
+	}
+
+	if !bsc.Security.Enabled {
+		bsc.Security = c.Security
+	}
+
+	if !bsc.Audit.Enabled {
+		bsc.Audit = c.Audit
+	}
+
+	if !bsc.Queue.Enabled {
+		bsc.Queue = c.Queue
+	}
+
 	bsc.MQTT = mc
 	return bsc, nil
 }
 
-func connectToMQTTBroker(conf agent.MQTTConfig, logger logger.Logger) (mqtt.Client, error) {
-	name := fmt.Sprintf("agent-%s", conf.Username)
-	conn := func(client mqtt.Client) {
-		logger.Info(fmt.Sprintf("Client %s connected", name))
+// connectToMQTTBroker connects to the first available broker of the
+// brokers list, or to the broker URL if the list is empty, and fails
+// over to the other brokers when the connection is lost. Presence is
+// announced on every connect, with the offline status as the last will.
+func connectToMQTTBroker(conf agent.MQTTConfig, session conn.Session, presence conn.Presence, logger logger.Logger) (conn.Failover, error) {
+	name := conf.ClientID
+	if name == "" {
+		name = fmt.Sprintf("agent-%s", conf.Username)
+	}
+
+	switch conf.Protocol {
+	case 0:
+		conf.Protocol = 4
+	case 3, 4, conn.ProtocolV5:
+	default:
+		return nil, errors.Wrap(errMQTTProtocolVersion, fmt.Errorf("version %d", conf.Protocol))
+	}
+
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+
+	endpoints := []conn.Endpoint{}
+	for _, bc := range conf.BrokerList() {
+		if len(conf.Brokers) > 0 {
+			var err error
+			if bc, err = loadCertificate(bc); err != nil {
+				return nil, errors.Wrap(errFailedToSetupMTLS, err)
+			}
+		}
+		opts := clientOptions(bc, name).SetOnConnectHandler(presence.Online)
+		if err := presence.Will(opts); err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, conn.Endpoint{
+			URL:      bc.URL,
+			Protocol: conf.Protocol,
+			Options:  opts,
+		})
+	}
+
+	client, err := conn.NewFailover(endpoints, conf.Failover, session, logger)
+	if err != nil {
+		return nil, err
 	}
+	token := client.Connect()
+	token.Wait()
 
-	lost := func(client mqtt.Client, err error) {
-		logger.Info(fmt.Sprintf("Client %s disconnected", name))
+	if token.Error() != nil {
+		return nil, token.Error()
 	}
+	return client, nil
+}
 
+// clientOptions returns MQTT client options for the broker.
+func clientOptions(conf agent.MQTTConfig, name string) *mqtt.ClientOptions {
 	opts := mqtt.NewClientOptions().
 		AddBroker(conf.URL).
 		SetClientID(name).
-		SetCleanSession(true).
-		SetAutoReconnect(true).
-		SetOnConnectHandler(conn).
-		SetConnectionLostHandler(lost)
+		SetCleanSession(!conf.Persistent).
+		SetResumeSubs(conf.Persistent).
+		SetProtocolVersion(conf.Protocol)
 
 	if conf.Username != "" && conf.Password != "" {
 		opts.SetUsername(conf.Username)
 		opts.SetPassword(conf.Password)
 	}
 
+	// Used by ws:// and wss:// connections only, which honor
+	// HTTPS_PROXY and HTTP_PROXY unless proxy is set explicitly.
+	if len(conf.WebSocket.Headers) > 0 {
+		h := http.Header{}
+		for k, v := range conf.WebSocket.Headers {
+			h.Set(k, v)
+		}
+		opts.SetHTTPHeaders(h)
+	}
+	if conf.WebSocket.Proxy != "" {
+		proxy, _ := url.Parse(conf.WebSocket.Proxy)
+		opts.SetWebsocketOptions(&mqtt.WebsocketOptions{Proxy: http.ProxyURL(proxy)})
+	}
+
 	if conf.MTLS {
 		cfg := &tls.Config{
 			InsecureSkipVerify: conf.SkipTLSVer,
@@ -324,16 +553,8 @@ func connectToMQTTBroker(conf agent.MQTTConfig, logger logger.Logger) (mqtt.Clie
 		}
 
 		opts.SetTLSConfig(cfg)
-		opts.SetProtocolVersion(4)
 	}
-	client := mqtt.NewClient(opts)
-	token := client.Connect()
-	token.Wait()
-
-	if token.Error() != nil {
-		return nil, token.Error()
-	}
-	return client, nil
+	return opts
 }
 
 func loadCertificate(cnfg agent.MQTTConfig) (agent.MQTTConfig, error) {
@@ -393,12 +614,17 @@ func loadCertificate(cnfg agent.MQTTConfig) (agent.MQTTConfig, error) {
 	return c, nil
 }
 
-func StopSignalHandler(ctx context.Context, cancel context.CancelFunc, logger logger.Logger, svcName string, server *http.Server) error {
+// StopSignalHandler shuts down the server on signal, announcing
+// that the service goes offline first.
+func StopSignalHandler(ctx context.Context, cancel context.CancelFunc, logger logger.Logger, svcName string, server *http.Server, offline func() error) error {
 	c := make(chan os.Signal, 2)
 	signal.Notify(c, syscall.SIGINT, syscall.SIGABRT)
 	select {
 	case sig := <-c:
 		defer cancel()
+		if err := offline(); err != nil {
+			logger.Warn(fmt.Sprintf("Failed to publish %s offline status: %s", svcName, err))
+		}
 		shutdownCtx, shutdownCancel := context.WithTimeout(ctx, 5*time.Second)
 		defer shutdownCancel()
 		if err := server.Shutdown(shutdownCtx); err != nil {
@@ -4,10 +4,12 @@ go 1.20
 
 require (
 	github.com/creack/pty v1.1.18
+	github.com/eclipse/paho.golang v0.11.0
 	github.com/eclipse/paho.mqtt.golang v1.4.2
 	github.com/edgexfoundry/go-mod-core-contracts v0.1.70
 	github.com/go-kit/kit v0.12.0
 	github.com/go-zoo/bone v1.3.0
+	github.com/google/uuid v1.3.0
 	github.com/mainflux/export v0.1.1-0.20230724124847-67d0bc7f38cb
 	github.com/mainflux/mainflux v0.0.0-20230713105239-52131eba669c
 	github.com/mainflux/senml v1.5.0
@@ -16,6 +18,7 @@ require (
 	github.com/prometheus/client_golang v1.16.0
 	github.com/stretchr/testify v1.8.4
 	golang.org/x/sync v0.3.0
+	golang.org/x/sys v0.10.0
 	robpike.io/filter v0.0.0-20150108201509-2984852a2183
 )
 
@@ -70,7 +73,6 @@ require (
 	github.com/go-kit/log v0.2.1 // indirect
 	github.com/go-logfmt/logfmt v0.6.0 // indirect
 	github.com/golang/protobuf v1.5.3 // indirect
-	github.com/google/uuid v1.3.0 // indirect
 	github.com/gorilla/websocket v1.5.0 // indirect
 	github.com/matttproud/golang_protobuf_extensions v1.0.4 // indirect
 	github.com/nats-io/nkeys v0.4.4 // indirect
@@ -84,7 +86,6 @@ require (
 	github.com/x448/float16 v0.8.4 // indirect
 	golang.org/x/crypto v0.11.0 // indirect
 	golang.org/x/net v0.12.0 // indirect
-	golang.org/x/sys v0.10.0 // indirect
 	google.golang.org/protobuf v1.31.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -5,10 +5,12 @@ package api
 
 import (
 	"context"
-	"strings"
+	"encoding/json"
 
 	"github.com/go-kit/kit/endpoint"
 	"github.com/mainflux/agent/pkg/agent"
+	"github.com/mainflux/agent/pkg/encoder"
+	"github.com/mainflux/mainflux/pkg/errors"
 )
 
 func pubEndpoint(svc agent.Service) endpoint.Endpoint {
@@ -34,28 +36,169 @@ func pubEndpoint(svc agent.Service) endpoint.Endpoint {
 }
 
 func execEndpoint(svc agent.Service) endpoint.Endpoint {
-	return func(_ context.Context, request interface{}) (interface{}, error) {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(execReq)
 
 		if err := req.validate(); err != nil {
 			return nil, err
 		}
 
-		uuid := strings.TrimSuffix(req.BaseName, ":")
-		out, err := svc.Execute(uuid, req.Value)
-		if err != nil {
+		cmd := req.Value
+		if req.DataValue != "" {
+			var err error
+			if cmd, err = encoder.DecodeData(req.DataValue); err != nil {
+				return nil, errors.Wrap(agent.ErrMalformedEntity, err)
+			}
+		}
+
+		uuid, rid := agent.ParseBaseName(req.BaseName)
+		if rid == "" {
+			rid = req.requestID
+		}
+		// Exec is dispatched through the registry as any other command,
+		// and the collected response carries the started job ID.
+		c := &agent.Collector{}
+		ctx = agent.WithCollector(agent.WithRequestID(ctx, rid), c)
+		if err := svc.Handle(ctx, "exec", uuid, cmd); err != nil {
+			if errors.Contains(err, agent.ErrCommandRejected) || errors.Contains(err, agent.ErrUnauthorized) {
+				return nil, err
+			}
 			return execRes{}, nil
 		}
 
 		resp := execRes{
 			BaseName: req.BaseName,
 			Name:     "exec",
-			Value:    out,
+		}
+		for _, r := range c.Records() {
+			if r.StringValue != nil {
+				resp.Value = *r.StringValue
+				break
+			}
 		}
 		return resp, nil
 	}
 }
 
+func commandEndpoint(svc agent.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(cmdReq)
+
+		if err := req.validate(); err != nil {
+			return nil, err
+		}
+
+		cmd := req.Value
+		if req.DataValue != "" {
+			var err error
+			if cmd, err = encoder.DecodeData(req.DataValue); err != nil {
+				return nil, errors.Wrap(agent.ErrMalformedEntity, err)
+			}
+		}
+
+		uuid, rid := agent.ParseBaseName(req.BaseName)
+		if rid == "" {
+			rid = req.requestID
+		}
+		c := &agent.Collector{}
+		ctx = agent.WithCollector(agent.WithRequestID(ctx, rid), c)
+		if err := svc.Handle(ctx, req.Name, uuid, cmd); err != nil {
+			return nil, err
+		}
+
+		return c.Records(), nil
+	}
+}
+
+func capabilitiesEndpoint(svc agent.Service) endpoint.Endpoint {
+	return func(_ context.Context, request interface{}) (interface{}, error) {
+		return svc.Capabilities(), nil
+	}
+}
+
+func auditEndpoint(svc agent.Service) endpoint.Endpoint {
+	return func(_ context.Context, request interface{}) (interface{}, error) {
+		req := request.(auditReq)
+
+		if err := req.validate(); err != nil {
+			return nil, err
+		}
+
+		entries, err := svc.Audit(req.filter)
+		if err != nil {
+			return nil, err
+		}
+
+		if req.filter.Format == "senml" {
+			return agent.AuditRecords(entries), nil
+		}
+		return entries, nil
+	}
+}
+
+func viewJobEndpoint(svc agent.Service) endpoint.Endpoint {
+	return func(_ context.Context, request interface{}) (interface{}, error) {
+		req := request.(jobReq)
+
+		if err := req.validate(); err != nil {
+			return nil, err
+		}
+
+		return svc.Job(req.id)
+	}
+}
+
+func listJobsEndpoint(svc agent.Service) endpoint.Endpoint {
+	return func(_ context.Context, request interface{}) (interface{}, error) {
+		return svc.Jobs(), nil
+	}
+}
+
+// cancelJobEndpoint cancels the job through the registry, so
+// that the signature of the command is checked as for the others.
+func cancelJobEndpoint(svc agent.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(cancelJobReq)
+
+		if err := req.validate(); err != nil {
+			return nil, err
+		}
+
+		if err := handleSigned(ctx, svc, &agent.Collector{}, "job-cancel", req.id, req.signedReq); err != nil {
+			return nil, err
+		}
+
+		return svc.Job(req.id)
+	}
+}
+
+// handleSigned handles the command given by the URL, collecting responses
+// with c. Signed command from the request body is handled instead, if it
+// is the same command.
+func handleSigned(ctx context.Context, svc agent.Service, c *agent.Collector, name, cmd string, req signedReq) error {
+	value := req.Value
+	if req.DataValue != "" {
+		var err error
+		if value, err = encoder.DecodeData(req.DataValue); err != nil {
+			return errors.Wrap(agent.ErrMalformedEntity, err)
+		}
+	}
+	if value != "" && value != cmd {
+		var e agent.Envelope
+		if err := json.Unmarshal([]byte(value), &e); err != nil || e.Command != cmd {
+			return agent.ErrMalformedEntity
+		}
+		cmd = value
+	}
+
+	uuid, rid := agent.ParseBaseName(req.BaseName)
+	if rid == "" {
+		rid = req.requestID
+	}
+	ctx = agent.WithCollector(agent.WithRequestID(ctx, rid), c)
+	return svc.Handle(ctx, name, uuid, cmd)
+}
+
 func addConfigEndpoint(svc agent.Service) endpoint.Endpoint {
 	return func(_ context.Context, request interface{}) (interface{}, error) {
 		req := request.(addConfigReq)
@@ -107,3 +250,31 @@ func viewServicesEndpoint(svc agent.Service) endpoint.Endpoint {
 		return svc.Services(), nil
 	}
 }
+
+// removeServiceEndpoint removes the service through the registry, so
+// that the signature of the command is checked as for the others.
+func removeServiceEndpoint(svc agent.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(serviceReq)
+
+		if err := req.validate(); err != nil {
+			return nil, err
+		}
+
+		c := &agent.Collector{}
+		if err := handleSigned(ctx, svc, c, "service", "remove,"+req.name, req.signedReq); err != nil {
+			return nil, err
+		}
+
+		removed := []agent.Info{}
+		for _, r := range c.Records() {
+			if r.StringValue == nil {
+				continue
+			}
+			if err := json.Unmarshal([]byte(*r.StringValue), &removed); err != nil {
+				return nil, err
+			}
+		}
+		return removed, nil
+	}
+}
@@ -5,12 +5,18 @@ package api_test
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -19,17 +25,20 @@ import (
 	"github.com/mainflux/agent/pkg/agent"
 	"github.com/mainflux/agent/pkg/agent/api"
 	"github.com/mainflux/agent/pkg/agent/mocks"
+	"github.com/mainflux/agent/pkg/conn"
 
 	"github.com/mainflux/mainflux/logger"
+	"github.com/mainflux/mainflux/pkg/messaging"
 	"github.com/mainflux/mainflux/pkg/messaging/brokers"
 	"github.com/stretchr/testify/assert"
 )
 
 type testRequest struct {
-	client *http.Client
-	method string
-	url    string
-	body   io.Reader
+	client    *http.Client
+	method    string
+	url       string
+	body      io.Reader
+	requestID string
 }
 
 func (tr testRequest) make() (*http.Response, error) {
@@ -37,6 +46,9 @@ func (tr testRequest) make() (*http.Response, error) {
 	if err != nil {
 		return nil, err
 	}
+	if tr.requestID != "" {
+		req.Header.Set("X-Request-ID", tr.requestID)
+	}
 
 	return tr.client.Do(req)
 }
@@ -77,7 +89,7 @@ func newService(ctx context.Context) (agent.Service, error) {
 }
 
 func newServer(svc agent.Service) *httptest.Server {
-	mux := api.MakeHandler(svc)
+	mux := api.MakeHandler(svc, conn.NewSession(logger.NewMock()))
 	return httptest.NewServer(mux)
 }
 
@@ -124,3 +136,329 @@ func TestPublish(t *testing.T) {
 		assert.Equal(t, tc.status, res.StatusCode, fmt.Sprintf("%s: expected status code %d got %d", tc.desc, tc.status, res.StatusCode))
 	}
 }
+
+func newMockService(t *testing.T, config agent.Config) agent.Service {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	config.Heartbeat.Interval = time.Minute
+	svc, err := agent.New(ctx, mocks.NewMQTTClient(), &config, mocks.NewEdgexClient(), mocks.NewPubSub(), logger.NewMock())
+	if !assert.Nil(t, err, fmt.Sprintf("unexpected error creating service: %s", err)) {
+		t.FailNow()
+	}
+	return svc
+}
+
+func TestJobs(t *testing.T) {
+	svc := newMockService(t, agent.Config{})
+	ts := newServer(svc)
+	defer ts.Close()
+	client := ts.Client()
+
+	running, err := svc.Execute(context.Background(), "1", `{"argv":["sleep","10"]}`)
+	assert.Nil(t, err, fmt.Sprintf("unexpected error executing command: %s", err))
+	finished, err := svc.Execute(context.Background(), "1", `{"argv":["true"]}`)
+	assert.Nil(t, err, fmt.Sprintf("unexpected error executing command: %s", err))
+	for end := time.Now().Add(10 * time.Second); time.Now().Before(end); time.Sleep(10 * time.Millisecond) {
+		if j, err := svc.Job(finished); err == nil && j.Status == "done" {
+			break
+		}
+	}
+
+	cases := []struct {
+		desc   string
+		method string
+		url    string
+		status int
+		job    string
+		state  string
+	}{
+		{desc: "view running job", method: http.MethodGet, url: "/jobs/" + running, status: http.StatusOK, job: running, state: "running"},
+		{desc: "view finished job", method: http.MethodGet, url: "/jobs/" + finished, status: http.StatusOK, job: finished, state: "done"},
+		{desc: "view unknown job", method: http.MethodGet, url: "/jobs/unknown", status: http.StatusNotFound},
+		{desc: "cancel running job", method: http.MethodDelete, url: "/jobs/" + running, status: http.StatusOK, job: running, state: "canceled"},
+		{desc: "cancel finished job", method: http.MethodDelete, url: "/jobs/" + finished, status: http.StatusConflict},
+		{desc: "cancel unknown job", method: http.MethodDelete, url: "/jobs/unknown", status: http.StatusNotFound},
+	}
+
+	for _, tc := range cases {
+		req := testRequest{
+			client: client,
+			method: tc.method,
+			url:    ts.URL + tc.url,
+		}
+		res, err := req.make()
+		if !assert.Nil(t, err, fmt.Sprintf("%s: unexpected error %s", tc.desc, err)) {
+			continue
+		}
+		assert.Equal(t, tc.status, res.StatusCode, fmt.Sprintf("%s: expected status code %d got %d", tc.desc, tc.status, res.StatusCode))
+		if tc.job == "" {
+			continue
+		}
+		j := agent.Job{}
+		err = json.NewDecoder(res.Body).Decode(&j)
+		assert.Nil(t, err, fmt.Sprintf("%s: unexpected error decoding job: %s", tc.desc, err))
+		assert.Equal(t, tc.job, j.ID, fmt.Sprintf("%s: unexpected job", tc.desc))
+		assert.Equal(t, tc.state, j.Status, fmt.Sprintf("%s: unexpected job status", tc.desc))
+	}
+
+	req := testRequest{
+		client: client,
+		method: http.MethodGet,
+		url:    ts.URL + "/jobs",
+	}
+	res, err := req.make()
+	if !assert.Nil(t, err, fmt.Sprintf("list jobs: unexpected error %s", err)) {
+		return
+	}
+	assert.Equal(t, http.StatusOK, res.StatusCode, "list jobs: unexpected status code")
+	jobs := []agent.Job{}
+	err = json.NewDecoder(res.Body).Decode(&jobs)
+	assert.Nil(t, err, fmt.Sprintf("list jobs: unexpected error decoding jobs: %s", err))
+	ids := []string{}
+	for _, j := range jobs {
+		ids = append(ids, j.ID)
+	}
+	assert.ElementsMatch(t, []string{running, finished}, ids, "list jobs: unexpected jobs")
+}
+
+// signer returns security config trusting a new key, and the function
+// which returns command envelopes signed for the uuid 1 with it.
+func signer(t *testing.T) (agent.SecurityConfig, func(name, cmd string) string) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err, fmt.Sprintf("unexpected error generating key: %s", err))
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	assert.Nil(t, err, fmt.Sprintf("unexpected error encoding key: %s", err))
+	cfg := agent.SecurityConfig{
+		Enabled: true,
+		Keys:    []agent.SignatureKey{{ID: "ops", Key: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))}},
+	}
+	nonce := 0
+	sign := func(name, cmd string) string {
+		nonce++
+		e := agent.Envelope{Command: cmd, Timestamp: time.Now().Unix(), Nonce: fmt.Sprintf("n%d", nonce), KeyID: "ops"}
+		e.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(priv, agent.SignedMessage("1", "", name, e)))
+		return toJSON(e)
+	}
+	return cfg, sign
+}
+
+func TestCancelJobSigned(t *testing.T) {
+	config := agent.Config{}
+	var sign func(name, cmd string) string
+	config.Security, sign = signer(t)
+	svc := newMockService(t, config)
+	ts := newServer(svc)
+	defer ts.Close()
+	client := ts.Client()
+
+	running, err := svc.Execute(context.Background(), "1", sign("exec", `{"argv":["sleep","10"]}`))
+	assert.Nil(t, err, fmt.Sprintf("unexpected error executing command: %s", err))
+	body := func(vs string) string {
+		return toJSON(map[string]string{"bn": "1:", "vs": vs})
+	}
+
+	cases := []struct {
+		desc   string
+		body   string
+		status int
+		state  string
+	}{
+		{desc: "cancel job without signature", status: http.StatusUnauthorized, state: "running"},
+		{desc: "cancel job with unsigned command", body: body(running), status: http.StatusUnauthorized, state: "running"},
+		{desc: "cancel job with command signed for other job", body: body(sign("job-cancel", "other")), status: http.StatusBadRequest, state: "running"},
+		{desc: "cancel job with command signed for other command", body: body(sign("job-status", running)), status: http.StatusUnauthorized, state: "running"},
+		{desc: "cancel job with signed command", body: body(sign("job-cancel", running)), status: http.StatusOK, state: "canceled"},
+	}
+
+	for _, tc := range cases {
+		req := testRequest{
+			client: client,
+			method: http.MethodDelete,
+			url:    ts.URL + "/jobs/" + running,
+			body:   strings.NewReader(tc.body),
+		}
+		res, err := req.make()
+		if !assert.Nil(t, err, fmt.Sprintf("%s: unexpected error %s", tc.desc, err)) {
+			continue
+		}
+		assert.Equal(t, tc.status, res.StatusCode, fmt.Sprintf("%s: expected status code %d got %d", tc.desc, tc.status, res.StatusCode))
+		j, err := svc.Job(running)
+		assert.Nil(t, err, fmt.Sprintf("%s: unexpected error viewing job: %s", tc.desc, err))
+		assert.Equal(t, tc.state, j.Status, fmt.Sprintf("%s: unexpected job status", tc.desc))
+	}
+}
+
+func TestRemoveServiceSigned(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	config := agent.Config{}
+	config.Heartbeat.Interval = time.Minute
+	var sign func(name, cmd string) string
+	config.Security, sign = signer(t)
+	ps := mocks.NewPubSub()
+	svc, err := agent.New(ctx, mocks.NewMQTTClient(), &config, mocks.NewEdgexClient(), ps, logger.NewMock())
+	if !assert.Nil(t, err, fmt.Sprintf("unexpected error creating service: %s", err)) {
+		return
+	}
+	err = ps.Publish(ctx, agent.Hearbeat, &messaging.Message{Channel: "heartbeat.duster.test", Payload: []byte("{}")})
+	assert.Nil(t, err, fmt.Sprintf("unexpected error publishing heartbeat: %s", err))
+	ts := newServer(svc)
+	defer ts.Close()
+	client := ts.Client()
+	body := func(vs string) string {
+		return toJSON(map[string]string{"bn": "1:", "vs": vs})
+	}
+
+	cases := []struct {
+		desc     string
+		name     string
+		body     string
+		status   int
+		removed  []string
+		services int
+	}{
+		{desc: "remove service without signature", name: "duster", status: http.StatusUnauthorized, services: 1},
+		{desc: "remove service with unsigned command", name: "duster", body: body("remove,duster"), status: http.StatusUnauthorized, services: 1},
+		{desc: "remove service with command signed for other service", name: "duster", body: body(sign("service", "remove,other")), status: http.StatusBadRequest, services: 1},
+		{desc: "remove service with signed command", name: "duster", body: body(sign("service", "remove,duster")), status: http.StatusOK, removed: []string{"duster"}},
+		{desc: "remove unknown service with signed command", name: "other", body: body(sign("service", "remove,other")), status: http.StatusNotFound},
+	}
+
+	for _, tc := range cases {
+		req := testRequest{
+			client: client,
+			method: http.MethodDelete,
+			url:    ts.URL + "/services/" + tc.name,
+			body:   strings.NewReader(tc.body),
+		}
+		res, err := req.make()
+		if !assert.Nil(t, err, fmt.Sprintf("%s: unexpected error %s", tc.desc, err)) {
+			continue
+		}
+		assert.Equal(t, tc.status, res.StatusCode, fmt.Sprintf("%s: expected status code %d got %d", tc.desc, tc.status, res.StatusCode))
+		assert.Len(t, svc.Services(), tc.services, fmt.Sprintf("%s: unexpected number of services", tc.desc))
+		if tc.status != http.StatusOK {
+			continue
+		}
+		infos := []agent.Info{}
+		err = json.NewDecoder(res.Body).Decode(&infos)
+		assert.Nil(t, err, fmt.Sprintf("%s: unexpected error decoding removed services: %s", tc.desc, err))
+		names := []string{}
+		for _, i := range infos {
+			names = append(names, i.Name)
+		}
+		assert.Equal(t, tc.removed, names, fmt.Sprintf("%s: unexpected removed services", tc.desc))
+	}
+}
+
+func TestExec(t *testing.T) {
+	config := agent.Config{}
+	config.Audit.Enabled = true
+	config.Audit.File = filepath.Join(t.TempDir(), "audit.log")
+	svc := newMockService(t, config)
+	ts := newServer(svc)
+	defer ts.Close()
+	client := ts.Client()
+
+	cases := []struct {
+		desc   string
+		req    string
+		header string
+		status int
+		uuid   string
+		rid    string
+		job    bool
+	}{
+		{desc: "exec command", req: `{"bn":"1:","n":"exec","vs":"echo,ok"}`, status: http.StatusOK, uuid: "1", job: true},
+		{desc: "exec command with request ID", req: `{"bn":"2/3f9c2a:","n":"exec","vs":"echo,ok"}`, status: http.StatusOK, uuid: "2", rid: "3f9c2a", job: true},
+		{desc: "exec invalid command", req: `{"bn":"3:","n":"exec","vs":"{\"argv\":[]}"}`, status: http.StatusOK, uuid: "3"},
+		{desc: "exec with other command name", req: `{"bn":"4:","n":"config","vs":"true"}`, status: http.StatusBadRequest},
+		{desc: "exec command with request ID header", req: `{"bn":"5:","n":"exec","vs":"echo,ok"}`, header: "7d1e0b", status: http.StatusOK, uuid: "5", rid: "7d1e0b", job: true},
+		{desc: "exec command with request ID header containing separator", req: `{"bn":"6:","n":"exec","vs":"echo,ok"}`, header: "7d/1e0b", status: http.StatusBadRequest},
+	}
+
+	for _, tc := range cases {
+		req := testRequest{
+			client:    client,
+			method:    http.MethodPost,
+			url:       ts.URL + "/exec",
+			body:      strings.NewReader(tc.req),
+			requestID: tc.header,
+		}
+		res, err := req.make()
+		if !assert.Nil(t, err, fmt.Sprintf("%s: unexpected error %s", tc.desc, err)) {
+			continue
+		}
+		assert.Equal(t, tc.status, res.StatusCode, fmt.Sprintf("%s: expected status code %d got %d", tc.desc, tc.status, res.StatusCode))
+		if tc.status != http.StatusOK {
+			continue
+		}
+		var body struct {
+			Value string `json:"vs"`
+		}
+		err = json.NewDecoder(res.Body).Decode(&body)
+		assert.Nil(t, err, fmt.Sprintf("%s: unexpected error decoding response: %s", tc.desc, err))
+
+		// Exec dispatched through the registry is audited once the job
+		// finishes, or right away if it fails to start.
+		var entries []agent.AuditEntry
+		for end := time.Now().Add(10 * time.Second); time.Now().Before(end); time.Sleep(10 * time.Millisecond) {
+			if entries, err = svc.Audit(agent.AuditFilter{UUID: tc.uuid, Type: "exec"}); err == nil && len(entries) > 0 {
+				break
+			}
+		}
+		if assert.Len(t, entries, 1, fmt.Sprintf("%s: expected exec to be audited", tc.desc)) {
+			assert.Equal(t, tc.rid, entries[0].RequestID, fmt.Sprintf("%s: unexpected audited request ID", tc.desc))
+		}
+		if !tc.job {
+			assert.Empty(t, body.Value, fmt.Sprintf("%s: expected no job", tc.desc))
+			continue
+		}
+		j, err := svc.Job(body.Value)
+		assert.Nil(t, err, fmt.Sprintf("%s: expected job %s: %s", tc.desc, body.Value, err))
+		assert.Equal(t, tc.rid, j.RequestID, fmt.Sprintf("%s: unexpected job request ID", tc.desc))
+	}
+}
+
+type healthChecker conn.Health
+
+func (h healthChecker) Health() conn.Health {
+	return conn.Health(h)
+}
+
+func TestHealth(t *testing.T) {
+	svc := newMockService(t, agent.Config{})
+
+	cases := []struct {
+		desc   string
+		health conn.Health
+		url    string
+		status int
+		state  string
+	}{
+		{desc: "health of connected client", health: conn.Health{Connected: true}, url: "/health", status: http.StatusOK, state: "pass"},
+		{desc: "health of disconnected client", health: conn.Health{}, url: "/health", status: http.StatusOK, state: "fail"},
+		{desc: "readiness of connected client", health: conn.Health{Connected: true}, url: "/ready", status: http.StatusOK, state: "pass"},
+		{desc: "readiness of disconnected client", health: conn.Health{}, url: "/ready", status: http.StatusServiceUnavailable, state: "fail"},
+	}
+
+	for _, tc := range cases {
+		ts := httptest.NewServer(api.MakeHandler(svc, healthChecker(tc.health)))
+		req := testRequest{
+			client: ts.Client(),
+			method: http.MethodGet,
+			url:    ts.URL + tc.url,
+		}
+		res, err := req.make()
+		if assert.Nil(t, err, fmt.Sprintf("%s: unexpected error %s", tc.desc, err)) {
+			assert.Equal(t, tc.status, res.StatusCode, fmt.Sprintf("%s: expected status code %d got %d", tc.desc, tc.status, res.StatusCode))
+			var body struct {
+				Status string `json:"status"`
+			}
+			err = json.NewDecoder(res.Body).Decode(&body)
+			assert.Nil(t, err, fmt.Sprintf("%s: unexpected error decoding response: %s", tc.desc, err))
+			assert.Equal(t, tc.state, body.Status, fmt.Sprintf("%s: unexpected status", tc.desc))
+		}
+		ts.Close()
+	}
+}
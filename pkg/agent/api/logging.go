@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/mainflux/agent/pkg/agent"
+	"github.com/mainflux/agent/pkg/queue"
 	log "github.com/mainflux/mainflux/logger"
 )
 
@@ -37,9 +38,9 @@ func (lm loggingMiddleware) Publish(topic string, payload string) (err error) {
 	return lm.svc.Publish(topic, payload)
 }
 
-func (lm loggingMiddleware) Execute(uuid, cmd string) (str string, err error) {
+func (lm loggingMiddleware) Respond(ctx context.Context, payload string) (err error) {
 	defer func(begin time.Time) {
-		message := fmt.Sprintf("Method exec for uuid %s and cmd %s took %s to complete", uuid, cmd, time.Since(begin))
+		message := fmt.Sprintf("Method respond%s with payload %s took %s to complete", requestID(ctx), payload, time.Since(begin))
 		if err != nil {
 			lm.logger.Warn(fmt.Sprintf("%s with error: %s.", message, err))
 			return
@@ -47,12 +48,12 @@ func (lm loggingMiddleware) Execute(uuid, cmd string) (str string, err error) {
 		lm.logger.Info(fmt.Sprintf("%s without errors.", message))
 	}(time.Now())
 
-	return lm.svc.Execute(uuid, cmd)
+	return lm.svc.Respond(ctx, payload)
 }
 
-func (lm loggingMiddleware) Control(uuid, cmd string) (err error) {
+func (lm loggingMiddleware) Execute(ctx context.Context, uuid, cmd string) (str string, err error) {
 	defer func(begin time.Time) {
-		message := fmt.Sprintf("Method control for uuid %s and cmd %s took %s to complete", uuid, cmd, time.Since(begin))
+		message := fmt.Sprintf("Method exec for uuid %s%s and cmd %s took %s to complete", uuid, requestID(ctx), cmd, time.Since(begin))
 		if err != nil {
 			lm.logger.Warn(fmt.Sprintf("%s with error: %s.", message, err))
 			return
@@ -60,7 +61,68 @@ func (lm loggingMiddleware) Control(uuid, cmd string) (err error) {
 		lm.logger.Info(fmt.Sprintf("%s without errors.", message))
 	}(time.Now())
 
-	return lm.svc.Control(uuid, cmd)
+	return lm.svc.Execute(ctx, uuid, cmd)
+}
+
+func (lm loggingMiddleware) Job(id string) (j agent.Job, err error) {
+	defer func(begin time.Time) {
+		message := fmt.Sprintf("Method job for id %s took %s to complete", id, time.Since(begin))
+		if err != nil {
+			lm.logger.Warn(fmt.Sprintf("%s with error: %s.", message, err))
+			return
+		}
+		lm.logger.Info(fmt.Sprintf("%s without errors.", message))
+	}(time.Now())
+
+	return lm.svc.Job(id)
+}
+
+func (lm loggingMiddleware) Jobs() []agent.Job {
+	defer func(begin time.Time) {
+		message := fmt.Sprintf("Method jobs took %s to complete", time.Since(begin))
+		lm.logger.Info(fmt.Sprintf("%s without errors.", message))
+	}(time.Now())
+
+	return lm.svc.Jobs()
+}
+
+func (lm loggingMiddleware) CancelJob(id string) (err error) {
+	defer func(begin time.Time) {
+		message := fmt.Sprintf("Method cancel_job for id %s took %s to complete", id, time.Since(begin))
+		if err != nil {
+			lm.logger.Warn(fmt.Sprintf("%s with error: %s.", message, err))
+			return
+		}
+		lm.logger.Info(fmt.Sprintf("%s without errors.", message))
+	}(time.Now())
+
+	return lm.svc.CancelJob(id)
+}
+
+func (lm loggingMiddleware) JobControl(ctx context.Context, uuid, cmd, id string) (err error) {
+	defer func(begin time.Time) {
+		message := fmt.Sprintf("Method job_control for uuid %s%s, cmd %s and id %s took %s to complete", uuid, requestID(ctx), cmd, id, time.Since(begin))
+		if err != nil {
+			lm.logger.Warn(fmt.Sprintf("%s with error: %s.", message, err))
+			return
+		}
+		lm.logger.Info(fmt.Sprintf("%s without errors.", message))
+	}(time.Now())
+
+	return lm.svc.JobControl(ctx, uuid, cmd, id)
+}
+
+func (lm loggingMiddleware) Control(ctx context.Context, uuid, cmd string) (err error) {
+	defer func(begin time.Time) {
+		message := fmt.Sprintf("Method control for uuid %s%s and cmd %s took %s to complete", uuid, requestID(ctx), cmd, time.Since(begin))
+		if err != nil {
+			lm.logger.Warn(fmt.Sprintf("%s with error: %s.", message, err))
+			return
+		}
+		lm.logger.Info(fmt.Sprintf("%s without errors.", message))
+	}(time.Now())
+
+	return lm.svc.Control(ctx, uuid, cmd)
 }
 
 func (lm loggingMiddleware) AddConfig(c agent.Config) (err error) {
@@ -87,7 +149,7 @@ func (lm loggingMiddleware) Config() agent.Config {
 
 func (lm loggingMiddleware) ServiceConfig(ctx context.Context, uuid, cmdStr string) (err error) {
 	defer func(begin time.Time) {
-		message := fmt.Sprintf("Method service_config took %s to complete", time.Since(begin))
+		message := fmt.Sprintf("Method service_config for uuid %s%s took %s to complete", uuid, requestID(ctx), time.Since(begin))
 		if err != nil {
 			lm.logger.Warn(fmt.Sprintf("%s with error: %s.", message, err))
 			return
@@ -98,7 +160,7 @@ func (lm loggingMiddleware) ServiceConfig(ctx context.Context, uuid, cmdStr stri
 	return lm.svc.ServiceConfig(ctx, uuid, cmdStr)
 }
 
-func (lm loggingMiddleware) Services() []agent.Info {
+func (lm loggingMiddleware) Services() []agent.ServiceInfo {
 	defer func(begin time.Time) {
 		message := fmt.Sprintf("Method services took %s to complete", time.Since(begin))
 		lm.logger.Info(fmt.Sprintf("%s without errors.", message))
@@ -107,9 +169,56 @@ func (lm loggingMiddleware) Services() []agent.Info {
 	return lm.svc.Services()
 }
 
-func (lm loggingMiddleware) Terminal(uuid, cmdStr string) (err error) {
+func (lm loggingMiddleware) RemoveService(name string) (removed []agent.Info, err error) {
+	defer func(begin time.Time) {
+		message := fmt.Sprintf("Method remove_service for name %s took %s to complete", name, time.Since(begin))
+		if err != nil {
+			lm.logger.Warn(fmt.Sprintf("%s with error: %s.", message, err))
+			return
+		}
+		lm.logger.Info(fmt.Sprintf("%s without errors.", message))
+	}(time.Now())
+
+	return lm.svc.RemoveService(name)
+}
+
+func (lm loggingMiddleware) Terminal(ctx context.Context, uuid, cmdStr string) (err error) {
+	defer func(begin time.Time) {
+		message := fmt.Sprintf("Method terminal for uuid %s%s and payload %s took %s to complete", uuid, requestID(ctx), cmdStr, time.Since(begin))
+		if err != nil {
+			lm.logger.Warn(fmt.Sprintf("%s with error: %s.", message, err))
+			return
+		}
+		lm.logger.Info(fmt.Sprintf("%s without errors.", message))
+	}(time.Now())
+
+	return lm.svc.Terminal(ctx, uuid, cmdStr)
+}
+
+// requestID formats request ID carried by the context for log messages.
+func requestID(ctx context.Context) string {
+	if id := agent.RequestID(ctx); id != "" {
+		return fmt.Sprintf(" (request %s)", id)
+	}
+	return ""
+}
+
+func (lm loggingMiddleware) Register(info agent.HandlerInfo, h agent.Handler) (err error) {
+	defer func(begin time.Time) {
+		message := fmt.Sprintf("Method register for command %s took %s to complete", info.Name, time.Since(begin))
+		if err != nil {
+			lm.logger.Warn(fmt.Sprintf("%s with error: %s.", message, err))
+			return
+		}
+		lm.logger.Info(fmt.Sprintf("%s without errors.", message))
+	}(time.Now())
+
+	return lm.svc.Register(info, h)
+}
+
+func (lm loggingMiddleware) Handle(ctx context.Context, name, uuid, cmdStr string) (err error) {
 	defer func(begin time.Time) {
-		message := fmt.Sprintf("Method terminal for uuid %s and payload %s took %s to complete", uuid, cmdStr, time.Since(begin))
+		message := fmt.Sprintf("Method handle for command %s, uuid %s%s and cmd %s took %s to complete", name, uuid, requestID(ctx), cmdStr, time.Since(begin))
 		if err != nil {
 			lm.logger.Warn(fmt.Sprintf("%s with error: %s.", message, err))
 			return
@@ -117,5 +226,36 @@ func (lm loggingMiddleware) Terminal(uuid, cmdStr string) (err error) {
 		lm.logger.Info(fmt.Sprintf("%s without errors.", message))
 	}(time.Now())
 
-	return lm.svc.Terminal(uuid, cmdStr)
+	return lm.svc.Handle(ctx, name, uuid, cmdStr)
+}
+
+func (lm loggingMiddleware) Capabilities() []agent.HandlerInfo {
+	defer func(begin time.Time) {
+		message := fmt.Sprintf("Method capabilities took %s to complete", time.Since(begin))
+		lm.logger.Info(fmt.Sprintf("%s without errors.", message))
+	}(time.Now())
+
+	return lm.svc.Capabilities()
+}
+
+func (lm loggingMiddleware) Audit(f agent.AuditFilter) (entries []agent.AuditEntry, err error) {
+	defer func(begin time.Time) {
+		message := fmt.Sprintf("Method audit for uuid %s and type %s took %s to complete", f.UUID, f.Type, time.Since(begin))
+		if err != nil {
+			lm.logger.Warn(fmt.Sprintf("%s with error: %s.", message, err))
+			return
+		}
+		lm.logger.Info(fmt.Sprintf("%s without errors.", message))
+	}(time.Now())
+
+	return lm.svc.Audit(f)
+}
+
+func (lm loggingMiddleware) QueueStats() queue.Stats {
+	defer func(begin time.Time) {
+		message := fmt.Sprintf("Method queue_stats took %s to complete", time.Since(begin))
+		lm.logger.Info(fmt.Sprintf("%s without errors.", message))
+	}(time.Now())
+
+	return lm.svc.QueueStats()
 }
@@ -12,6 +12,7 @@ import (
 
 	"github.com/go-kit/kit/metrics"
 	"github.com/mainflux/agent/pkg/agent"
+	"github.com/mainflux/agent/pkg/queue"
 )
 
 var _ agent.Service = (*metricsMiddleware)(nil)
@@ -31,22 +32,58 @@ func MetricsMiddleware(svc agent.Service, counter metrics.Counter, latency metri
 	}
 }
 
-func (ms *metricsMiddleware) Execute(uuid, cmdStr string) (string, error) {
+func (ms *metricsMiddleware) Execute(ctx context.Context, uuid, cmdStr string) (string, error) {
 	defer func(begin time.Time) {
 		ms.counter.With("method", "execute").Add(1)
 		ms.latency.With("method", "execute").Observe(time.Since(begin).Seconds())
 	}(time.Now())
 
-	return ms.svc.Execute(uuid, cmdStr)
+	return ms.svc.Execute(ctx, uuid, cmdStr)
 }
 
-func (ms *metricsMiddleware) Control(uuid, cmdStr string) error {
+func (ms *metricsMiddleware) Job(id string) (agent.Job, error) {
+	defer func(begin time.Time) {
+		ms.counter.With("method", "job").Add(1)
+		ms.latency.With("method", "job").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return ms.svc.Job(id)
+}
+
+func (ms *metricsMiddleware) Jobs() []agent.Job {
+	defer func(begin time.Time) {
+		ms.counter.With("method", "jobs").Add(1)
+		ms.latency.With("method", "jobs").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return ms.svc.Jobs()
+}
+
+func (ms *metricsMiddleware) CancelJob(id string) error {
+	defer func(begin time.Time) {
+		ms.counter.With("method", "cancel_job").Add(1)
+		ms.latency.With("method", "cancel_job").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return ms.svc.CancelJob(id)
+}
+
+func (ms *metricsMiddleware) JobControl(ctx context.Context, uuid, cmd, id string) error {
+	defer func(begin time.Time) {
+		ms.counter.With("method", "job_control").Add(1)
+		ms.latency.With("method", "job_control").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return ms.svc.JobControl(ctx, uuid, cmd, id)
+}
+
+func (ms *metricsMiddleware) Control(ctx context.Context, uuid, cmdStr string) error {
 	defer func(begin time.Time) {
 		ms.counter.With("method", "control").Add(1)
 		ms.latency.With("method", "control").Observe(time.Since(begin).Seconds())
 	}(time.Now())
 
-	return ms.svc.Control(uuid, cmdStr)
+	return ms.svc.Control(ctx, uuid, cmdStr)
 }
 
 func (ms *metricsMiddleware) AddConfig(ec agent.Config) error {
@@ -76,7 +113,7 @@ func (ms *metricsMiddleware) Config() agent.Config {
 	return ms.svc.Config()
 }
 
-func (ms *metricsMiddleware) Services() []agent.Info {
+func (ms *metricsMiddleware) Services() []agent.ServiceInfo {
 	defer func(begin time.Time) {
 		ms.counter.With("method", "services").Add(1)
 		ms.latency.With("method", "services").Observe(time.Since(begin).Seconds())
@@ -85,6 +122,15 @@ func (ms *metricsMiddleware) Services() []agent.Info {
 	return ms.svc.Services()
 }
 
+func (ms *metricsMiddleware) RemoveService(name string) ([]agent.Info, error) {
+	defer func(begin time.Time) {
+		ms.counter.With("method", "remove_service").Add(1)
+		ms.latency.With("method", "remove_service").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return ms.svc.RemoveService(name)
+}
+
 func (ms *metricsMiddleware) Publish(topic, payload string) error {
 	defer func(begin time.Time) {
 		ms.counter.With("method", "publish").Add(1)
@@ -94,11 +140,65 @@ func (ms *metricsMiddleware) Publish(topic, payload string) error {
 	return ms.svc.Publish(topic, payload)
 }
 
-func (ms *metricsMiddleware) Terminal(topic, payload string) error {
+func (ms *metricsMiddleware) Respond(ctx context.Context, payload string) error {
+	defer func(begin time.Time) {
+		ms.counter.With("method", "respond").Add(1)
+		ms.latency.With("method", "respond").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return ms.svc.Respond(ctx, payload)
+}
+
+func (ms *metricsMiddleware) Terminal(ctx context.Context, topic, payload string) error {
 	defer func(begin time.Time) {
 		ms.counter.With("method", "publish").Add(1)
 		ms.latency.With("method", "publish").Observe(time.Since(begin).Seconds())
 	}(time.Now())
 
-	return ms.svc.Terminal(topic, payload)
+	return ms.svc.Terminal(ctx, topic, payload)
+}
+
+func (ms *metricsMiddleware) Register(info agent.HandlerInfo, h agent.Handler) error {
+	defer func(begin time.Time) {
+		ms.counter.With("method", "register").Add(1)
+		ms.latency.With("method", "register").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return ms.svc.Register(info, h)
+}
+
+func (ms *metricsMiddleware) Handle(ctx context.Context, name, uuid, cmdStr string) error {
+	defer func(begin time.Time) {
+		ms.counter.With("method", "handle").Add(1)
+		ms.latency.With("method", "handle").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return ms.svc.Handle(ctx, name, uuid, cmdStr)
+}
+
+func (ms *metricsMiddleware) Capabilities() []agent.HandlerInfo {
+	defer func(begin time.Time) {
+		ms.counter.With("method", "capabilities").Add(1)
+		ms.latency.With("method", "capabilities").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return ms.svc.Capabilities()
+}
+
+func (ms *metricsMiddleware) Audit(f agent.AuditFilter) ([]agent.AuditEntry, error) {
+	defer func(begin time.Time) {
+		ms.counter.With("method", "audit").Add(1)
+		ms.latency.With("method", "audit").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return ms.svc.Audit(f)
+}
+
+func (ms *metricsMiddleware) QueueStats() queue.Stats {
+	defer func(begin time.Time) {
+		ms.counter.With("method", "queue_stats").Add(1)
+		ms.latency.With("method", "queue_stats").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return ms.svc.QueueStats()
 }
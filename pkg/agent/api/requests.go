@@ -21,19 +21,112 @@ func (req pubReq) validate() error {
 }
 
 type execReq struct {
-	BaseName string `json:"bn"`
-	Name     string `json:"n"`
-	Value    string `json:"vs"`
+	BaseName  string `json:"bn"`
+	Name      string `json:"n"`
+	Value     string `json:"vs"`
+	DataValue string `json:"vd"`
+	requestID string
 }
 
 func (req execReq) validate() error {
-	if req.BaseName == "" || req.Name != "exec" || req.Value == "" {
+	if req.BaseName == "" || req.Name != "exec" {
+		return agent.ErrMalformedEntity
+	}
+
+	if (req.Value == "") == (req.DataValue == "") {
+		return agent.ErrMalformedEntity
+	}
+
+	return nil
+}
+
+type cmdReq struct {
+	BaseName  string `json:"bn"`
+	Name      string `json:"n"`
+	Value     string `json:"vs"`
+	DataValue string `json:"vd"`
+	requestID string
+}
+
+func (req cmdReq) validate() error {
+	if req.BaseName == "" || req.Name == "" {
+		return agent.ErrMalformedEntity
+	}
+
+	if req.Value != "" && req.DataValue != "" {
 		return agent.ErrMalformedEntity
 	}
 
 	return nil
 }
 
+type auditReq struct {
+	filter agent.AuditFilter
+}
+
+func (req auditReq) validate() error {
+	if req.filter.Limit < 0 {
+		return agent.ErrInvalidQueryParams
+	}
+
+	return nil
+}
+
+type jobReq struct {
+	id string
+}
+
+func (req jobReq) validate() error {
+	if req.id == "" {
+		return agent.ErrMalformedEntity
+	}
+
+	return nil
+}
+
+// signedReq holds optional base name and signed command of the requests
+// whose command is given by the URL.
+type signedReq struct {
+	BaseName  string `json:"bn"`
+	Value     string `json:"vs"`
+	DataValue string `json:"vd"`
+	requestID string
+}
+
+func (req signedReq) validate() error {
+	if req.Value != "" && req.DataValue != "" {
+		return agent.ErrMalformedEntity
+	}
+
+	return nil
+}
+
+type cancelJobReq struct {
+	signedReq
+	id string
+}
+
+func (req cancelJobReq) validate() error {
+	if req.id == "" {
+		return agent.ErrMalformedEntity
+	}
+
+	return req.signedReq.validate()
+}
+
+type serviceReq struct {
+	signedReq
+	name string
+}
+
+func (req serviceReq) validate() error {
+	if req.name == "" {
+		return agent.ErrMalformedEntity
+	}
+
+	return req.signedReq.validate()
+}
+
 type addConfigReq struct {
 	Agent agentConfig
 }
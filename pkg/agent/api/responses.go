@@ -3,6 +3,11 @@
 
 package api
 
+import (
+	"github.com/mainflux/agent/pkg/conn"
+	"github.com/mainflux/mainflux"
+)
+
 type genericRes struct {
 	Service  string `json:"service"`
 	Response string `json:"response"`
@@ -13,3 +18,12 @@ type execRes struct {
 	Name     string `json:"n"`
 	Value    string `json:"vs"`
 }
+
+type errorRes struct {
+	Err string `json:"error"`
+}
+
+type healthRes struct {
+	mainflux.HealthInfo
+	MQTT conn.Health `json:"mqtt"`
+}
@@ -6,10 +6,13 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"io"
 
 	"github.com/go-zoo/bone"
 	"github.com/mainflux/agent/pkg/agent"
+	"github.com/mainflux/agent/pkg/conn"
 	"github.com/mainflux/mainflux"
+	"github.com/mainflux/mainflux/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"net/http"
@@ -17,8 +20,20 @@ import (
 	kithttp "github.com/go-kit/kit/transport/http"
 )
 
+const (
+	requestIDHeader   = "X-Request-ID"
+	healthContentType = "application/health+json"
+	healthPass        = "pass"
+	healthFail        = "fail"
+)
+
+// HealthChecker reports state of MQTT connection and subscriptions.
+type HealthChecker interface {
+	Health() conn.Health
+}
+
 // MakeHandler returns a HTTP handler for API endpoints.
-func MakeHandler(svc agent.Service) http.Handler {
+func MakeHandler(svc agent.Service, hc HealthChecker) http.Handler {
 	r := bone.New()
 
 	r.Post("/pub", kithttp.NewServer(
@@ -31,6 +46,47 @@ func MakeHandler(svc agent.Service) http.Handler {
 		execEndpoint(svc),
 		decodeExecRequest,
 		encodeResponse,
+		kithttp.ServerErrorEncoder(encodeError),
+	))
+
+	r.Post("/commands", kithttp.NewServer(
+		commandEndpoint(svc),
+		decodeCommandRequest,
+		encodeResponse,
+		kithttp.ServerErrorEncoder(encodeError),
+	))
+
+	r.Get("/capabilities", kithttp.NewServer(
+		capabilitiesEndpoint(svc),
+		decodeRequest,
+		encodeResponse,
+	))
+
+	r.Get("/audit", kithttp.NewServer(
+		auditEndpoint(svc),
+		decodeAuditRequest,
+		encodeResponse,
+		kithttp.ServerErrorEncoder(encodeError),
+	))
+
+	r.Get("/jobs", kithttp.NewServer(
+		listJobsEndpoint(svc),
+		decodeRequest,
+		encodeResponse,
+	))
+
+	r.Get("/jobs/:id", kithttp.NewServer(
+		viewJobEndpoint(svc),
+		decodeJobRequest,
+		encodeResponse,
+		kithttp.ServerErrorEncoder(encodeError),
+	))
+
+	r.Delete("/jobs/:id", kithttp.NewServer(
+		cancelJobEndpoint(svc),
+		decodeCancelJobRequest,
+		encodeResponse,
+		kithttp.ServerErrorEncoder(encodeError),
 	))
 
 	r.Post("/config", kithttp.NewServer(
@@ -51,12 +107,51 @@ func MakeHandler(svc agent.Service) http.Handler {
 		encodeResponse,
 	))
 
+	r.Delete("/services/:name", kithttp.NewServer(
+		removeServiceEndpoint(svc),
+		decodeServiceRequest,
+		encodeResponse,
+		kithttp.ServerErrorEncoder(encodeError),
+	))
+
 	r.Handle("/metrics", promhttp.Handler())
-	r.GetFunc("/health", mainflux.Health("agent", ""))
+	r.GetFunc("/health", health(hc, http.StatusOK))
+	r.GetFunc("/ready", health(hc, http.StatusServiceUnavailable))
 
 	return r
 }
 
+// health responds with service info extended with MQTT connection and
+// subscriptions state. Status is fail if the client is disconnected or
+// any subscription is down, and the response then has the fail code.
+// Liveness check keeps 200 OK so that broker outages, which the queue
+// rides out, don't restart the agent, while readiness check fails.
+func health(hc HealthChecker, failCode int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h := hc.Health()
+		res := healthRes{
+			HealthInfo: mainflux.HealthInfo{
+				Status:      healthPass,
+				Version:     mainflux.Version,
+				Commit:      mainflux.Commit,
+				Description: "agent service",
+				BuildTime:   mainflux.BuildTime,
+			},
+			MQTT: h,
+		}
+		code := http.StatusOK
+		if !h.Healthy() {
+			res.Status = healthFail
+			code = failCode
+		}
+		w.Header().Set("Content-Type", healthContentType)
+		w.WriteHeader(code)
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}
+}
+
 func decodeRequest(_ context.Context, r *http.Request) (interface{}, error) {
 	return nil, nil
 }
@@ -71,8 +166,81 @@ func decodePublishRequest(_ context.Context, r *http.Request) (interface{}, erro
 }
 
 func decodeExecRequest(_ context.Context, r *http.Request) (interface{}, error) {
-	req := execReq{}
+	rid, err := headerRequestID(r)
+	if err != nil {
+		return nil, err
+	}
+	req := execReq{requestID: rid}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+func decodeCommandRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	rid, err := headerRequestID(r)
+	if err != nil {
+		return nil, err
+	}
+	req := cmdReq{requestID: rid}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, errors.Wrap(agent.ErrMalformedEntity, err)
+	}
+
+	return req, nil
+}
+
+func decodeAuditRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	f, err := agent.ParseAuditFilter(r.URL.RawQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	return auditReq{filter: f}, nil
+}
+
+func decodeJobRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return jobReq{id: bone.GetValue(r, "id")}, nil
+}
+
+func decodeCancelJobRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	req := cancelJobReq{id: bone.GetValue(r, "id")}
+	if err := decodeSignedRequest(r, &req.signedReq); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// decodeSignedRequest decodes optional body with the signed command.
+func decodeSignedRequest(r *http.Request, req *signedReq) error {
+	rid, err := headerRequestID(r)
+	if err != nil {
+		return err
+	}
+	req.requestID = rid
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil && err != io.EOF {
+		return errors.Wrap(agent.ErrMalformedEntity, err)
+	}
+
+	return nil
+}
+
+// headerRequestID returns request ID from the header, rejecting
+// IDs which can't be carried in the response base name.
+func headerRequestID(r *http.Request) (string, error) {
+	rid := r.Header.Get(requestIDHeader)
+	if err := agent.ValidateRequestID(rid); err != nil {
+		return "", errors.Wrap(agent.ErrMalformedEntity, err)
+	}
+
+	return rid, nil
+}
+
+func decodeServiceRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	req := serviceReq{name: bone.GetValue(r, "name")}
+	if err := decodeSignedRequest(r, &req.signedReq); err != nil {
 		return nil, err
 	}
 
@@ -91,3 +259,29 @@ func decodeAddConfigRequest(_ context.Context, r *http.Request) (interface{}, er
 func encodeResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
 	return json.NewEncoder(w).Encode(response)
 }
+
+func encodeError(_ context.Context, err error, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case errors.Contains(err, agent.ErrMalformedEntity),
+		errors.Contains(err, agent.ErrInvalidQueryParams):
+		w.WriteHeader(http.StatusBadRequest)
+	case errors.Contains(err, agent.ErrUnauthorized):
+		w.WriteHeader(http.StatusUnauthorized)
+	case errors.Contains(err, agent.ErrCommandRejected):
+		w.WriteHeader(http.StatusForbidden)
+	case errors.Contains(err, agent.ErrNotFound),
+		errors.Contains(err, agent.ErrServiceNotFound),
+		errors.Contains(err, agent.ErrUnknownCommand):
+		w.WriteHeader(http.StatusNotFound)
+	case errors.Contains(err, agent.ErrJobFinished):
+		w.WriteHeader(http.StatusConflict)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	if errorVal, ok := err.(errors.Error); ok {
+		if err := json.NewEncoder(w).Encode(errorRes{Err: errorVal.Msg()}); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}
+}
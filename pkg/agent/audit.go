@@ -0,0 +1,430 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mainflux/mainflux/pkg/errors"
+	"github.com/mainflux/senml"
+)
+
+const (
+	auditCmd  = "audit"
+	addConfig = "add-config"
+
+	statusOK    = "ok"
+	statusError = "error"
+
+	argsName = "args"
+	typeName = "type"
+
+	redacted = "***"
+
+	inputArg = "input"
+	// maxTermLine is max size of the terminal input line audited as a whole.
+	maxTermLine = 4096
+
+	defAuditMaxSize  = 10 * 1024 * 1024
+	defAuditMaxFiles = 5
+	defAuditLimit    = 100
+	// maxAuditLine is max size of a single audit log entry when reading the log.
+	maxAuditLine = 1024 * 1024
+
+	formatJSON  = "json"
+	formatSenML = "senml"
+)
+
+// errAuditLog indicates failure to read or write the audit log.
+var errAuditLog = errors.New("audit log failure")
+
+// AuditEntry is a record of a single remote operation.
+type AuditEntry struct {
+	Time      time.Time     `json:"time"`
+	UUID      string        `json:"uuid"`
+	RequestID string        `json:"request_id,omitempty"`
+	Type      string        `json:"type"`
+	Args      string        `json:"args"`
+	Status    string        `json:"status"`
+	Error     string        `json:"error,omitempty"`
+	Duration  time.Duration `json:"duration"`
+}
+
+// AuditFilter selects audit log entries. Zero values match every entry.
+// Limit is the max number of the most recent entries returned.
+type AuditFilter struct {
+	From   time.Time
+	To     time.Time
+	UUID   string
+	Type   string
+	Limit  int
+	Format string
+}
+
+// AuditLog is an append-only log of remote operations.
+type AuditLog interface {
+	// Save appends entry to the log, redacting its arguments.
+	Save(AuditEntry) error
+
+	// Retrieve returns entries matching the filter, oldest first.
+	Retrieve(AuditFilter) ([]AuditEntry, error)
+}
+
+var _ AuditLog = (*auditLog)(nil)
+
+// auditLog writes entries as JSON lines to a file, which is rotated
+// when it grows over max size. Rotated files are suffixed with .1, .2...
+// with .1 being the most recent one.
+type auditLog struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	redact   []*regexp.Regexp
+	file     *os.File
+	size     int64
+}
+
+// NewAuditLog returns audit log configured by cfg.
+// Disabled audit log discards entries.
+func NewAuditLog(cfg AuditConfig) (AuditLog, error) {
+	if !cfg.Enabled {
+		return noopAudit{}, nil
+	}
+	l := &auditLog{
+		path:     cfg.File,
+		maxSize:  cfg.MaxSize,
+		maxFiles: cfg.MaxFiles,
+	}
+	if l.path == "" {
+		return nil, errors.Wrap(errAuditLog, errors.New("missing audit log file"))
+	}
+	if l.maxSize <= 0 {
+		l.maxSize = defAuditMaxSize
+	}
+	if l.maxFiles <= 0 {
+		l.maxFiles = defAuditMaxFiles
+	}
+	for _, r := range cfg.Redact {
+		re, err := regexp.Compile(r)
+		if err != nil {
+			return nil, errors.Wrap(errAuditLog, fmt.Errorf("redact pattern %s: %s", r, err))
+		}
+		l.redact = append(l.redact, re)
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *auditLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return errors.Wrap(errAuditLog, err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return errors.Wrap(errAuditLog, err)
+	}
+	l.file = f
+	l.size = fi.Size()
+	return nil
+}
+
+func (l *auditLog) Save(e AuditEntry) error {
+	for _, re := range l.redact {
+		e.Args = redactMatches(re, e.Args)
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return errors.Wrap(errAuditLog, err)
+	}
+	b = append(b, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.size > 0 && l.size+int64(len(b)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := l.file.Write(b)
+	l.size += int64(n)
+	if err != nil {
+		return errors.Wrap(errAuditLog, err)
+	}
+	return nil
+}
+
+// termInput aggregates keystrokes of each terminal session into lines,
+// so that the typed commands are audited as a whole and their decoded
+// text is redacted, instead of a record with encoded argument per key.
+type termInput struct {
+	mu    sync.Mutex
+	lines map[string][]byte
+}
+
+// add appends keystrokes to the session input and returns the lines
+// completed by new line or by reaching the max line size.
+func (t *termInput) add(uuid, data string) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.lines == nil {
+		t.lines = map[string][]byte{}
+	}
+	ret := []string{}
+	line := t.lines[uuid]
+	for i := 0; i < len(data); i++ {
+		switch c := data[i]; {
+		case c == '\r' || c == '\n':
+			if len(line) > 0 {
+				ret = append(ret, string(line))
+			}
+			line = nil
+		default:
+			line = append(line, c)
+			if len(line) >= maxTermLine {
+				ret = append(ret, string(line))
+				line = nil
+			}
+		}
+	}
+	t.lines[uuid] = line
+	return ret
+}
+
+// flush removes and returns incomplete line of the session input.
+func (t *termInput) flush(uuid string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	line := t.lines[uuid]
+	delete(t.lines, uuid)
+	return string(line)
+}
+
+// recordTerm saves terminal operation to the audit log. Session open and
+// close are recorded as they are, while keystrokes are recorded as lines
+// once they are completed or the session is closed.
+func (a *agent) recordTerm(ctx context.Context, uuid, cmdStr string, begin time.Time, err error) {
+	b, derr := base64.StdEncoding.DecodeString(cmdStr)
+	if derr != nil {
+		a.record(ctx, termCmd, uuid, cmdStr, begin, err)
+		return
+	}
+	op, data, _ := strings.Cut(string(b), ",")
+	switch op {
+	case char:
+		if err != nil {
+			// Keystroke is left out, since it may be a part of a secret.
+			a.record(ctx, termCmd, uuid, op, begin, err)
+			return
+		}
+		for _, line := range a.termInput.add(uuid, data) {
+			a.record(ctx, termCmd, uuid, inputArg+","+line, begin, nil)
+		}
+	default:
+		if line := a.termInput.flush(uuid); line != "" {
+			a.record(ctx, termCmd, uuid, inputArg+","+line, begin, nil)
+		}
+		a.record(ctx, termCmd, uuid, op, begin, err)
+	}
+}
+
+// redactMatches replaces matches of the pattern with the redaction mark.
+// If the pattern has groups, only the groups are replaced.
+func redactMatches(re *regexp.Regexp, s string) string {
+	if re.NumSubexp() == 0 {
+		return re.ReplaceAllString(s, redacted)
+	}
+	return re.ReplaceAllStringFunc(s, func(m string) string {
+		idx := re.FindStringSubmatchIndex(m)
+		ret, prev := "", 0
+		for i := 2; i < len(idx); i += 2 {
+			if idx[i] < prev {
+				continue
+			}
+			ret += m[prev:idx[i]] + redacted
+			prev = idx[i+1]
+		}
+		return ret + m[prev:]
+	})
+}
+
+func (l *auditLog) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return errors.Wrap(errAuditLog, err)
+	}
+	os.Remove(l.rotated(l.maxFiles))
+	for i := l.maxFiles - 1; i > 0; i-- {
+		os.Rename(l.rotated(i), l.rotated(i+1))
+	}
+	if err := os.Rename(l.path, l.rotated(1)); err != nil {
+		return errors.Wrap(errAuditLog, err)
+	}
+	return l.open()
+}
+
+func (l *auditLog) rotated(i int) string {
+	return fmt.Sprintf("%s.%d", l.path, i)
+}
+
+func (l *auditLog) Retrieve(f AuditFilter) ([]AuditEntry, error) {
+	limit := f.Limit
+	if limit <= 0 {
+		limit = defAuditLimit
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ret := []AuditEntry{}
+	for i := l.maxFiles; i >= 0; i-- {
+		path := l.path
+		if i > 0 {
+			path = l.rotated(i)
+		}
+		entries, err := readAudit(path, f)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, entries...)
+		if len(ret) > limit {
+			ret = ret[len(ret)-limit:]
+		}
+	}
+	return ret, nil
+}
+
+func readAudit(path string, f AuditFilter) ([]AuditEntry, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(errAuditLog, err)
+	}
+	defer file.Close()
+
+	ret := []AuditEntry{}
+	s := bufio.NewScanner(file)
+	s.Buffer(make([]byte, 64*1024), maxAuditLine)
+	for s.Scan() {
+		e := AuditEntry{}
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			continue
+		}
+		if f.match(e) {
+			ret = append(ret, e)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, errors.Wrap(errAuditLog, err)
+	}
+	return ret, nil
+}
+
+func (f AuditFilter) match(e AuditEntry) bool {
+	switch {
+	case !f.From.IsZero() && e.Time.Before(f.From):
+		return false
+	case !f.To.IsZero() && e.Time.After(f.To):
+		return false
+	case f.UUID != "" && e.UUID != f.UUID:
+		return false
+	case f.Type != "" && e.Type != f.Type:
+		return false
+	default:
+		return true
+	}
+}
+
+// ParseAuditFilter parses filter from URL query, i.e.
+// from=2023-07-01T00:00:00Z&to=1688169600&uuid=1&type=exec&limit=10&format=senml.
+// Times are RFC3339 or Unix time in seconds.
+func ParseAuditFilter(query string) (AuditFilter, error) {
+	q, err := url.ParseQuery(query)
+	if err != nil {
+		return AuditFilter{}, errors.Wrap(ErrInvalidQueryParams, err)
+	}
+	f := AuditFilter{
+		UUID:   q.Get("uuid"),
+		Type:   q.Get("type"),
+		Format: q.Get("format"),
+	}
+	if f.From, err = parseTime(q.Get("from")); err != nil {
+		return AuditFilter{}, errors.Wrap(ErrInvalidQueryParams, err)
+	}
+	if f.To, err = parseTime(q.Get("to")); err != nil {
+		return AuditFilter{}, errors.Wrap(ErrInvalidQueryParams, err)
+	}
+	if l := q.Get("limit"); l != "" {
+		if f.Limit, err = strconv.Atoi(l); err != nil {
+			return AuditFilter{}, errors.Wrap(ErrInvalidQueryParams, err)
+		}
+	}
+	switch f.Format {
+	case "", formatJSON, formatSenML:
+	default:
+		return AuditFilter{}, errors.Wrap(ErrInvalidQueryParams, fmt.Errorf("unknown format %s", f.Format))
+	}
+	return f, nil
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if sec, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Unix(0, int64(sec*float64(time.Second))), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// AuditRecords returns audit entries as SenML records. Each entry is
+// represented by type, args, status, optional error and duration
+// records, with base name set to the entry uuid and request ID.
+func AuditRecords(entries []AuditEntry) []senml.Record {
+	records := []senml.Record{}
+	for _, e := range entries {
+		e := e
+		bn := BaseName(e.UUID, e.RequestID)
+		if bn == "" {
+			bn = pubSubID
+		}
+		t := float64(e.Time.UnixNano()) / float64(time.Second)
+		duration := e.Duration.Seconds()
+		records = append(records,
+			senml.Record{BaseName: bn, Name: typeName, Time: t, StringValue: &e.Type},
+			senml.Record{BaseName: bn, Name: argsName, Time: t, StringValue: &e.Args},
+			senml.Record{BaseName: bn, Name: status, Time: t, StringValue: &e.Status},
+		)
+		if e.Error != "" {
+			records = append(records, senml.Record{BaseName: bn, Name: errorName, Time: t, StringValue: &e.Error})
+		}
+		records = append(records, senml.Record{BaseName: bn, Name: durationName, Unit: "s", Time: t, Value: &duration})
+	}
+	return records
+}
+
+type noopAudit struct{}
+
+func (noopAudit) Save(AuditEntry) error {
+	return nil
+}
+
+func (noopAudit) Retrieve(AuditFilter) ([]AuditEntry, error) {
+	return []AuditEntry{}, nil
+}
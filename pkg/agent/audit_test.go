@@ -0,0 +1,98 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package agent_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mainflux/agent/pkg/agent"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditLog(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "audit.log")
+	l, err := agent.NewAuditLog(agent.AuditConfig{
+		Enabled:  true,
+		File:     file,
+		MaxSize:  512,
+		MaxFiles: 2,
+		Redact:   []string{`password=(\S+)`},
+	})
+	assert.Nil(t, err, fmt.Sprintf("unexpected error creating audit log: %s", err))
+
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i < 10; i++ {
+		e := agent.AuditEntry{
+			Time:   start.Add(time.Duration(i) * time.Minute),
+			UUID:   fmt.Sprintf("%d", i%2),
+			Type:   "exec",
+			Args:   fmt.Sprintf("login,user=admin,password=secret%d", i),
+			Status: "ok",
+		}
+		if i%3 == 0 {
+			e.Type = "control"
+		}
+		err := l.Save(e)
+		assert.Nil(t, err, fmt.Sprintf("unexpected error saving entry: %s", err))
+	}
+
+	_, err = os.Stat(file + ".1")
+	assert.Nil(t, err, "expected rotated audit log")
+	_, err = os.Stat(file + ".3")
+	assert.True(t, os.IsNotExist(err), "expected only max files rotated logs")
+
+	all, err := l.Retrieve(agent.AuditFilter{})
+	assert.Nil(t, err, fmt.Sprintf("unexpected error retrieving entries: %s", err))
+	assert.NotEmpty(t, all, "expected audit entries")
+	last := all[len(all)-1]
+	assert.Equal(t, "login,user=admin,password=***", last.Args, "expected redacted arguments")
+	for i := 1; i < len(all); i++ {
+		assert.True(t, all[i-1].Time.Before(all[i].Time), "expected entries oldest first")
+	}
+
+	cases := []struct {
+		desc   string
+		filter agent.AuditFilter
+		match  func(agent.AuditEntry) bool
+	}{
+		{"filter by uuid", agent.AuditFilter{UUID: "1"}, func(e agent.AuditEntry) bool { return e.UUID == "1" }},
+		{"filter by type", agent.AuditFilter{Type: "control"}, func(e agent.AuditEntry) bool { return e.Type == "control" }},
+		{"filter by time", agent.AuditFilter{From: start.Add(8 * time.Minute)}, func(e agent.AuditEntry) bool { return !e.Time.Before(start.Add(8 * time.Minute)) }},
+	}
+	for _, tc := range cases {
+		entries, err := l.Retrieve(tc.filter)
+		assert.Nil(t, err, fmt.Sprintf("%s: unexpected error: %s", tc.desc, err))
+		assert.NotEmpty(t, entries, fmt.Sprintf("%s: expected entries", tc.desc))
+		for _, e := range entries {
+			assert.True(t, tc.match(e), fmt.Sprintf("%s: unexpected entry %v", tc.desc, e))
+		}
+	}
+
+	entries, err := l.Retrieve(agent.AuditFilter{Limit: 2})
+	assert.Nil(t, err, fmt.Sprintf("unexpected error retrieving entries: %s", err))
+	assert.Equal(t, all[len(all)-2:], entries, "expected most recent entries")
+}
+
+func TestParseAuditFilter(t *testing.T) {
+	cases := []struct {
+		desc  string
+		query string
+		err   bool
+	}{
+		{"empty query", "", false},
+		{"full query", "uuid=1&type=exec&from=2023-07-01T00:00:00Z&to=1688169600&limit=10&format=senml", false},
+		{"invalid time", "from=yesterday", true},
+		{"invalid limit", "limit=ten", true},
+		{"unknown format", "format=xml", true},
+	}
+
+	for _, tc := range cases {
+		_, err := agent.ParseAuditFilter(tc.query)
+		assert.Equal(t, tc.err, err != nil, fmt.Sprintf("%s: unexpected error %v", tc.desc, err))
+	}
+}
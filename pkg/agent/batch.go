@@ -0,0 +1,63 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/mainflux/mainflux/pkg/errors"
+	"github.com/mainflux/senml"
+)
+
+type collectorKey struct{}
+
+// Collector gathers responses of commands processed as a part of a batch,
+// so that they are published together instead of one by one.
+type Collector struct {
+	mu      sync.Mutex
+	records []senml.Record
+}
+
+// WithCollector returns context whose command responses are added
+// to the collector instead of being published.
+func WithCollector(ctx context.Context, c *Collector) context.Context {
+	return context.WithValue(ctx, collectorKey{}, c)
+}
+
+func collector(ctx context.Context) *Collector {
+	if ctx == nil {
+		return nil
+	}
+	c, _ := ctx.Value(collectorKey{}).(*Collector)
+	return c
+}
+
+// Add adds response record.
+func (c *Collector) Add(r senml.Record) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.records = append(c.records, r)
+}
+
+// Records returns collected response records.
+func (c *Collector) Records() []senml.Record {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]senml.Record{}, c.records...)
+}
+
+// ErrorResponse returns JSON error response published for failed command.
+func ErrorResponse(err error) string {
+	res := errorRes{Error: err.Error()}
+	if e, ok := err.(errors.Error); ok && e.Err() != nil {
+		res = errorRes{Error: e.Msg(), Reason: e.Err().Error()}
+	}
+	b, err := json.Marshal(res)
+	if err != nil {
+		return err.Error()
+	}
+	return string(b)
+}
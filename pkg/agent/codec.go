@@ -0,0 +1,41 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"fmt"
+
+	"github.com/mainflux/agent/pkg/encoder"
+	"github.com/mainflux/mainflux/pkg/errors"
+)
+
+const (
+	jobChannel  = "job"
+	termChannel = "term"
+)
+
+var errUnknownFormatChannel = errors.New("unknown payload format channel")
+
+// newCodecs returns the default codec and codecs of the channels
+// with the format set in the channels config.
+func newCodecs(cc ChanConfig) (encoder.Codec, map[string]encoder.Codec, error) {
+	def, err := encoder.New(cc.Format)
+	if err != nil {
+		return nil, nil, err
+	}
+	codecs := make(map[string]encoder.Codec)
+	for ch, f := range cc.Formats {
+		switch ch {
+		case control, jobChannel, termChannel:
+		default:
+			return nil, nil, errors.Wrap(errUnknownFormatChannel, fmt.Errorf("%s", ch))
+		}
+		c, err := encoder.New(f)
+		if err != nil {
+			return nil, nil, err
+		}
+		codecs[ch] = c
+	}
+	return def, codecs, nil
+}
@@ -0,0 +1,84 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mainflux/mainflux/pkg/errors"
+)
+
+// Command is a structured command request. It is sent as a JSON object
+// in place of the legacy comma separated command string:
+//
+//	{"argv":["ls","-l","/var/log/my app"],"env":{"LANG":"C"},"cwd":"/tmp","stdin":"","timeout":"30s"}
+//
+// Timeout is either a duration string or a number of seconds.
+type Command struct {
+	Argv    []string          `json:"argv"`
+	Env     map[string]string `json:"env,omitempty"`
+	Cwd     string            `json:"cwd,omitempty"`
+	Stdin   string            `json:"stdin,omitempty"`
+	Timeout time.Duration     `json:"-"`
+}
+
+// ParseCommand parses structured JSON command or legacy comma separated
+// command string, depending on the format of the given string.
+func ParseCommand(cmdStr string) (Command, error) {
+	s := strings.TrimSpace(cmdStr)
+	if strings.HasPrefix(s, "{") {
+		c := Command{}
+		if err := json.Unmarshal([]byte(s), &c); err != nil {
+			return Command{}, errors.Wrap(errInvalidCommand, err)
+		}
+		if len(c.Argv) == 0 || c.Argv[0] == "" {
+			return Command{}, errInvalidCommand
+		}
+		return c, nil
+	}
+
+	argv := strings.Split(strings.ReplaceAll(cmdStr, " ", ""), ",")
+	if len(argv) < 2 {
+		return Command{}, errInvalidCommand
+	}
+	return Command{Argv: argv}, nil
+}
+
+// environ returns command environment in KEY=value form.
+func (c Command) environ() []string {
+	env := []string{}
+	for k, v := range c.Env {
+		env = append(env, fmt.Sprintf("%s=%s", k, v))
+	}
+	return env
+}
+
+// UnmarshalJSON parses the timeout from JSON.
+func (c *Command) UnmarshalJSON(b []byte) error {
+	type command Command
+	v := struct {
+		command
+		Timeout interface{} `json:"timeout"`
+	}{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*c = Command(v.command)
+	switch value := v.Timeout.(type) {
+	case nil:
+		return nil
+	case float64:
+		c.Timeout = time.Duration(value * float64(time.Second))
+		return nil
+	case string:
+		var err error
+		c.Timeout, err = time.ParseDuration(value)
+		return err
+	default:
+		return errors.New("invalid duration")
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package agent_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mainflux/agent/pkg/agent"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCommand(t *testing.T) {
+	cases := []struct {
+		desc   string
+		cmdStr string
+		cmd    agent.Command
+		err    bool
+	}{
+		{
+			desc:   "legacy command",
+			cmdStr: "ls, -l, /tmp",
+			cmd:    agent.Command{Argv: []string{"ls", "-l", "/tmp"}},
+		},
+		{
+			desc:   "legacy command without arguments",
+			cmdStr: "ls",
+			err:    true,
+		},
+		{
+			desc:   "structured command",
+			cmdStr: `{"argv":["sh","-c","echo 'a, b' > \"/tmp/my file\""],"env":{"LANG":"C"},"cwd":"/tmp","stdin":"x","timeout":"1m"}`,
+			cmd: agent.Command{
+				Argv:    []string{"sh", "-c", `echo 'a, b' > "/tmp/my file"`},
+				Env:     map[string]string{"LANG": "C"},
+				Cwd:     "/tmp",
+				Stdin:   "x",
+				Timeout: time.Minute,
+			},
+		},
+		{
+			desc:   "structured command with timeout in seconds",
+			cmdStr: ` {"argv":["uptime"],"timeout":1.5}`,
+			cmd:    agent.Command{Argv: []string{"uptime"}, Timeout: 1500 * time.Millisecond},
+		},
+		{
+			desc:   "structured command without argv",
+			cmdStr: `{"cwd":"/tmp"}`,
+			err:    true,
+		},
+		{
+			desc:   "structured command with invalid timeout",
+			cmdStr: `{"argv":["uptime"],"timeout":"soon"}`,
+			err:    true,
+		},
+		{
+			desc:   "malformed structured command",
+			cmdStr: `{"argv":["uptime"]`,
+			err:    true,
+		},
+	}
+
+	for _, tc := range cases {
+		cmd, err := agent.ParseCommand(tc.cmdStr)
+		assert.Equal(t, tc.err, err != nil, fmt.Sprintf("%s: unexpected error %s", tc.desc, err))
+		assert.Equal(t, tc.cmd, cmd, fmt.Sprintf("%s: expected %v got %v", tc.desc, tc.cmd, cmd))
+	}
+}
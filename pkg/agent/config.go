@@ -7,13 +7,17 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"net/url"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/mainflux/mainflux/pkg/errors"
 	"github.com/pelletier/go-toml"
 )
 
+var errBrokerURL = errors.New("invalid MQTT broker configuration")
+
 type ServerConfig struct {
 	Port      string `toml:"port" json:"port"`
 	BrokerURL string `toml:"broker_url" json:"broker_url"`
@@ -22,6 +26,12 @@ type ServerConfig struct {
 type ChanConfig struct {
 	Control string `toml:"control"`
 	Data    string `toml:"data"`
+	// Format is default payload format, senml+json, senml+cbor, plain+json
+	// or raw, of the messages that are not replies to a request over MQTT.
+	// json and cbor are aliases for SenML JSON and SenML CBOR.
+	Format string `toml:"format"`
+	// Formats overrides the default format for control, term and job messages.
+	Formats map[string]string `toml:"formats"`
 }
 
 type EdgexConfig struct {
@@ -48,16 +58,219 @@ type MQTTConfig struct {
 	ClientCert  string          `json:"client_cert" toml:"client_cert"`
 	ClientKey   string          `json:"client_key" toml:"client_key"`
 	CaCert      string          `json:"ca_cert" toml:"ca_cert"`
+	ClientID    string          `json:"client_id" toml:"client_id" mapstructure:"client_id"`
+	Persistent  bool            `json:"persistent_session" toml:"persistent_session" mapstructure:"persistent_session"`
+	Protocol    uint            `json:"protocol_version" toml:"protocol_version" mapstructure:"protocol_version"`
+	Brokers     []BrokerConfig  `json:"brokers" toml:"brokers" mapstructure:"brokers"`
+	Failover    FailoverConfig  `json:"failover" toml:"failover" mapstructure:"failover"`
+	WebSocket   WebSocketConfig `json:"websocket" toml:"websocket" mapstructure:"websocket"`
+}
+
+// WebSocketConfig holds settings of the connections to ws:// and wss:// brokers.
+// Empty Proxy means that the proxy is taken from HTTPS_PROXY and HTTP_PROXY
+// environment variables.
+type WebSocketConfig struct {
+	Proxy   string            `json:"proxy" toml:"proxy"`
+	Headers map[string]string `json:"headers" toml:"headers"`
+}
+
+// BrokerConfig is a broker of the failover list. Empty credentials and
+// TLS settings are taken from the MQTT config.
+type BrokerConfig struct {
+	URL         string `json:"url" toml:"url"`
+	Username    string `json:"username" toml:"username"`
+	Password    string `json:"password" toml:"password"`
+	MTLS        *bool  `json:"mtls" toml:"mtls"`
+	SkipTLSVer  *bool  `json:"skip_tls_ver" toml:"skip_tls_ver"`
+	CAPath      string `json:"ca_path" toml:"ca_path"`
+	CertPath    string `json:"cert_path" toml:"cert_path"`
+	PrivKeyPath string `json:"priv_key_path" toml:"priv_key_path"`
+}
+
+// FailoverConfig holds broker failover timing. Connection to the active
+// broker is checked every CheckInterval, and while a broker of higher
+// priority than the active one is configured, it is tried every FailbackInterval.
+type FailoverConfig struct {
+	CheckInterval    time.Duration `json:"check_interval" toml:"check_interval"`
+	FailbackInterval time.Duration `json:"failback_interval" toml:"failback_interval"`
+}
+
+// Validate checks that URL scheme of every broker is supported and that
+// mTLS and WebSocket settings match the schemes.
+func (c MQTTConfig) Validate() error {
+	ws := false
+	for _, bc := range c.BrokerList() {
+		u := bc.URL
+		if !strings.Contains(u, "://") {
+			u = "tcp://" + u
+		}
+		pu, err := url.Parse(u)
+		if err != nil {
+			return errors.Wrap(errBrokerURL, err)
+		}
+		switch pu.Scheme {
+		case "tcp", "mqtt", "ssl", "tls", "mqtts", "mqtt+ssl", "tcps":
+		case "ws", "wss":
+			ws = true
+		default:
+			return errors.Wrap(errBrokerURL, fmt.Errorf("unsupported scheme %s of %s, tcp, mqtt, ssl, tls, mqtts, tcps, ws and wss are supported", pu.Scheme, bc.URL))
+		}
+		if !bc.MTLS {
+			continue
+		}
+		switch pu.Scheme {
+		case "ssl", "tls", "mqtts", "mqtt+ssl", "tcps", "wss":
+		default:
+			return errors.Wrap(errBrokerURL, fmt.Errorf("mTLS requires TLS broker URL such as ssl:// or wss://, got %s", bc.URL))
+		}
+	}
+
+	if c.WebSocket.Proxy == "" && len(c.WebSocket.Headers) == 0 {
+		return nil
+	}
+	if !ws {
+		return errors.Wrap(errBrokerURL, fmt.Errorf("websocket proxy and headers require ws:// or wss:// broker URL"))
+	}
+	if c.WebSocket.Proxy != "" {
+		pu, err := url.Parse(c.WebSocket.Proxy)
+		if err != nil {
+			return errors.Wrap(errBrokerURL, err)
+		}
+		switch pu.Scheme {
+		case "http", "socks5":
+		default:
+			return errors.Wrap(errBrokerURL, fmt.Errorf("unsupported websocket proxy scheme %s, http and socks5 are supported", pu.Scheme))
+		}
+	}
+	return nil
 }
 
+// BrokerList returns brokers in the order of priority, with empty
+// settings taken from the MQTT config. URL is the only broker
+// if the brokers list is empty.
+func (c MQTTConfig) BrokerList() []MQTTConfig {
+	if len(c.Brokers) == 0 {
+		return []MQTTConfig{c}
+	}
+	ret := []MQTTConfig{}
+	for _, b := range c.Brokers {
+		bc := c
+		bc.URL = b.URL
+		bc.Brokers = nil
+		if b.Username != "" {
+			bc.Username = b.Username
+			bc.Password = b.Password
+		}
+		if b.MTLS != nil {
+			bc.MTLS = *b.MTLS
+		}
+		if b.SkipTLSVer != nil {
+			bc.SkipTLSVer = *b.SkipTLSVer
+		}
+		if b.CAPath != "" {
+			bc.CAPath = b.CAPath
+		}
+		if b.CertPath != "" {
+			bc.CertPath = b.CertPath
+			bc.PrivKeyPath = b.PrivKeyPath
+		}
+		ret = append(ret, bc)
+	}
+	return ret
+}
+
+// HeartbeatConfig holds service heartbeat settings. State change of a
+// service instance is reported once it lasts for Debounce. Instance
+// changing state FlapThreshold times within FlapWindow is flapping,
+// and its changes are not reported until it settles for FlapWindow.
+// Instance that stays offline for TTL is evicted, 0 disables eviction.
+// Tracked services are saved to File, if set, and restored on start.
 type HeartbeatConfig struct {
-	Interval time.Duration `toml:"interval"`
+	Interval      time.Duration `toml:"interval"`
+	TTL           time.Duration `toml:"ttl"`
+	File          string        `toml:"file"`
+	Debounce      time.Duration `toml:"debounce"`
+	FlapThreshold int           `toml:"flap_threshold"`
+	FlapWindow    time.Duration `toml:"flap_window"`
 }
 
 type TerminalConfig struct {
 	SessionTimeout time.Duration `toml:"session_timeout" json:"session_timeout"`
 }
 
+// PolicyRule matches binary by path or glob pattern and,
+// optionally, its arguments by regular expressions matching
+// the whole argument.
+type PolicyRule struct {
+	Binary string   `toml:"binary" json:"binary"`
+	Args   []string `toml:"args" json:"args"`
+}
+
+// PolicyConfig holds exec policy rules. Env lists glob patterns of
+// environment variables commands may set, any but the dangerous ones
+// if empty. Cwd lists directories commands may run in, any if empty.
+type PolicyConfig struct {
+	Enabled bool         `toml:"enabled" json:"enabled"`
+	Allow   []PolicyRule `toml:"allow" json:"allow"`
+	Deny    []PolicyRule `toml:"deny" json:"deny"`
+	Env     []string     `toml:"env" json:"env"`
+	Cwd     []string     `toml:"cwd" json:"cwd"`
+}
+
+// LimitsConfig holds resource limits applied to executed commands.
+// Zero value means no limit.
+type LimitsConfig struct {
+	CPU          uint64 `toml:"cpu" json:"cpu"`
+	AddressSpace uint64 `toml:"address_space" json:"address_space"`
+	OpenFiles    uint64 `toml:"open_files" json:"open_files"`
+	Output       int64  `toml:"output" json:"output"`
+}
+
+type ExecConfig struct {
+	Timeout time.Duration `toml:"timeout" json:"timeout"`
+	Limits  LimitsConfig  `toml:"limits" json:"limits"`
+	Policy  PolicyConfig  `toml:"policy" json:"policy"`
+}
+
+// SignatureKey is a trusted PEM encoded Ed25519 or ECDSA public key,
+// given inline or as a path to the key file.
+type SignatureKey struct {
+	ID   string `toml:"id" json:"id"`
+	Key  string `toml:"key" json:"key"`
+	File string `toml:"file" json:"file"`
+}
+
+// SecurityConfig holds signed commands configuration.
+type SecurityConfig struct {
+	Enabled     bool           `toml:"enabled" json:"enabled"`
+	AuthOnly    bool           `toml:"auth_only" json:"auth_only"`
+	MaxAge      time.Duration  `toml:"max_age" json:"max_age"`
+	ReplayCache int            `toml:"replay_cache" json:"replay_cache"`
+	Keys        []SignatureKey `toml:"keys" json:"keys"`
+}
+
+// AuditConfig holds audit log configuration. Audit log is rotated
+// when it exceeds MaxSize bytes, keeping MaxFiles rotated files.
+// Matches of Redact patterns in command arguments are masked,
+// or only matches of their groups if patterns have groups.
+type AuditConfig struct {
+	Enabled  bool     `toml:"enabled" json:"enabled"`
+	File     string   `toml:"file" json:"file"`
+	MaxSize  int64    `toml:"max_size" json:"max_size"`
+	MaxFiles int      `toml:"max_files" json:"max_files"`
+	Redact   []string `toml:"redact" json:"redact"`
+}
+
+// QueueConfig holds outbound queue configuration. Messages published
+// while MQTT connection is down are kept in Dir and sent in order once
+// it is up again. Zero MaxSize or MaxAge means no limit.
+type QueueConfig struct {
+	Enabled bool          `toml:"enabled" json:"enabled"`
+	Dir     string        `toml:"dir" json:"dir"`
+	MaxSize int64         `toml:"max_size" json:"max_size"`
+	MaxAge  time.Duration `toml:"max_age" json:"max_age"`
+}
+
 type Config struct {
 	Server    ServerConfig    `toml:"server" json:"server"`
 	Terminal  TerminalConfig  `toml:"terminal" json:"terminal"`
@@ -66,10 +279,14 @@ type Config struct {
 	Edgex     EdgexConfig     `toml:"edgex" json:"edgex"`
 	Log       LogConfig       `toml:"log" json:"log"`
 	MQTT      MQTTConfig      `toml:"mqtt" json:"mqtt"`
+	Exec      ExecConfig      `toml:"exec" json:"exec"`
+	Security  SecurityConfig  `toml:"security" json:"security"`
+	Audit     AuditConfig     `toml:"audit" json:"audit"`
+	Queue     QueueConfig     `toml:"queue" json:"queue"`
 	File      string
 }
 
-func NewConfig(sc ServerConfig, cc ChanConfig, ec EdgexConfig, lc LogConfig, mc MQTTConfig, hc HeartbeatConfig, tc TerminalConfig, file string) Config {
+func NewConfig(sc ServerConfig, cc ChanConfig, ec EdgexConfig, lc LogConfig, mc MQTTConfig, hc HeartbeatConfig, tc TerminalConfig, xc ExecConfig, sec SecurityConfig, ac AuditConfig, qc QueueConfig, file string) Config {
 	return Config{
 		Server:    sc,
 		Channels:  cc,
@@ -78,6 +295,10 @@ func NewConfig(sc ServerConfig, cc ChanConfig, ec EdgexConfig, lc LogConfig, mc
 		MQTT:      mc,
 		Heartbeat: hc,
 		Terminal:  tc,
+		Exec:      xc,
+		Security:  sec,
+		Audit:     ac,
+		Queue:     qc,
 		File:      file,
 	}
 }
@@ -105,10 +326,46 @@ func ReadConfig(file string) (Config, error) {
 	if err := toml.Unmarshal(data, &c); err != nil {
 		return Config{}, errors.New(fmt.Sprintf("Error unmarshaling toml: %s", err))
 	}
+	// Policy is checked on load, so that invalid
+	// patterns are not saved back or ignored.
+	if _, err := NewPolicy(c.Exec.Policy); err != nil {
+		return Config{}, err
+	}
 	return c, nil
 }
 
-// UnmarshalJSON parses the duration from JSON.
+// UnmarshalJSON parses the durations from JSON.
+func (d *FailoverConfig) UnmarshalJSON(b []byte) error {
+	v := struct {
+		CheckInterval    interface{} `json:"check_interval"`
+		FailbackInterval interface{} `json:"failback_interval"`
+	}{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	var err error
+	if d.CheckInterval, err = jsonDuration(v.CheckInterval); err != nil {
+		return err
+	}
+	d.FailbackInterval, err = jsonDuration(v.FailbackInterval)
+	return err
+}
+
+// jsonDuration returns duration given as string or number of nanoseconds.
+func jsonDuration(v interface{}) (time.Duration, error) {
+	switch value := v.(type) {
+	case nil:
+		return 0, nil
+	case float64:
+		return time.Duration(value), nil
+	case string:
+		return time.ParseDuration(value)
+	default:
+		return 0, errors.New("invalid duration")
+	}
+}
+
+// UnmarshalJSON parses the durations from JSON.
 func (d *HeartbeatConfig) UnmarshalJSON(b []byte) error {
 	var v map[string]interface{}
 	if err := json.Unmarshal(b, &v); err != nil {
@@ -118,20 +375,26 @@ func (d *HeartbeatConfig) UnmarshalJSON(b []byte) error {
 	if !ok {
 		return errors.New("missing value")
 	}
-	switch value := interval.(type) {
-	case float64:
-		d.Interval = time.Duration(value)
-		return nil
-	case string:
-		var err error
-		d.Interval, err = time.ParseDuration(value)
-		if err != nil {
-			return err
-		}
-		return nil
-	default:
-		return errors.New("invalid duration")
+	var err error
+	if d.Interval, err = jsonDuration(interval); err != nil {
+		return err
+	}
+	if d.Debounce, err = jsonDuration(v["debounce"]); err != nil {
+		return err
+	}
+	if d.FlapWindow, err = jsonDuration(v["flap_window"]); err != nil {
+		return err
+	}
+	if d.TTL, err = jsonDuration(v["ttl"]); err != nil {
+		return err
 	}
+	if file, ok := v["file"].(string); ok {
+		d.File = file
+	}
+	if threshold, ok := v["flap_threshold"].(float64); ok {
+		d.FlapThreshold = int(threshold)
+	}
+	return nil
 }
 
 // UnmarshalJSON parses the duration from JSON.
@@ -159,3 +422,51 @@ func (d *TerminalConfig) UnmarshalJSON(b []byte) error {
 		return errors.New("invalid duration")
 	}
 }
+
+// UnmarshalJSON parses the timeout from JSON.
+func (d *ExecConfig) UnmarshalJSON(b []byte) error {
+	type execConfig ExecConfig
+	v := struct {
+		execConfig
+		Timeout interface{} `json:"timeout"`
+	}{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*d = ExecConfig(v.execConfig)
+	var err error
+	d.Timeout, err = jsonDuration(v.Timeout)
+	return err
+}
+
+// UnmarshalJSON parses the max age from JSON.
+func (d *SecurityConfig) UnmarshalJSON(b []byte) error {
+	type securityConfig SecurityConfig
+	v := struct {
+		securityConfig
+		MaxAge interface{} `json:"max_age"`
+	}{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*d = SecurityConfig(v.securityConfig)
+	var err error
+	d.MaxAge, err = jsonDuration(v.MaxAge)
+	return err
+}
+
+// UnmarshalJSON parses the max age from JSON.
+func (d *QueueConfig) UnmarshalJSON(b []byte) error {
+	type queueConfig QueueConfig
+	v := struct {
+		queueConfig
+		MaxAge interface{} `json:"max_age"`
+	}{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*d = QueueConfig(v.queueConfig)
+	var err error
+	d.MaxAge, err = jsonDuration(v.MaxAge)
+	return err
+}
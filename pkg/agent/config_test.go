@@ -0,0 +1,83 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package agent_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mainflux/agent/pkg/agent"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMQTTConfigValidate(t *testing.T) {
+	tls := true
+	cases := []struct {
+		desc string
+		cfg  agent.MQTTConfig
+		err  bool
+	}{
+		{desc: "default URL without scheme", cfg: agent.MQTTConfig{URL: "localhost:1883"}},
+		{desc: "mTLS over ssl", cfg: agent.MQTTConfig{URL: "ssl://localhost:8883", MTLS: true}},
+		{desc: "mTLS over wss", cfg: agent.MQTTConfig{URL: "wss://localhost/mqtt", MTLS: true}},
+		{desc: "mTLS over tcp", cfg: agent.MQTTConfig{URL: "tcp://localhost:1883", MTLS: true}, err: true},
+		{desc: "mTLS over ws", cfg: agent.MQTTConfig{URL: "ws://localhost/mqtt", MTLS: true}, err: true},
+		{desc: "unsupported scheme", cfg: agent.MQTTConfig{URL: "http://localhost"}, err: true},
+		{
+			desc: "websocket proxy and headers",
+			cfg: agent.MQTTConfig{
+				URL:       "wss://localhost/mqtt",
+				WebSocket: agent.WebSocketConfig{Proxy: "http://proxy:3128", Headers: map[string]string{"X-Site": "1"}},
+			},
+		},
+		{
+			desc: "websocket proxy without websocket broker",
+			cfg:  agent.MQTTConfig{URL: "tcp://localhost:1883", WebSocket: agent.WebSocketConfig{Proxy: "http://proxy:3128"}},
+			err:  true,
+		},
+		{
+			desc: "unsupported websocket proxy scheme",
+			cfg:  agent.MQTTConfig{URL: "ws://localhost/mqtt", WebSocket: agent.WebSocketConfig{Proxy: "ftp://proxy"}},
+			err:  true,
+		},
+		{
+			desc: "mTLS override of the fallback broker",
+			cfg: agent.MQTTConfig{Brokers: []agent.BrokerConfig{
+				{URL: "wss://cloud/mqtt"},
+				{URL: "tcp://local:1883", MTLS: &tls},
+			}},
+			err: true,
+		},
+	}
+
+	for _, tc := range cases {
+		err := tc.cfg.Validate()
+		assert.Equal(t, tc.err, err != nil, fmt.Sprintf("%s: unexpected error %v", tc.desc, err))
+	}
+}
+
+func TestReadConfigPolicy(t *testing.T) {
+	cases := []struct {
+		desc   string
+		config string
+		err    bool
+	}{
+		{desc: "valid argument patterns", config: "[exec.policy]\n  enabled = true\n\n  [[exec.policy.allow]]\n    binary = \"ls\"\n    args = [\"-[al]+\"]\n"},
+		{desc: "invalid argument pattern", config: "[exec.policy]\n  enabled = true\n\n  [[exec.policy.allow]]\n    binary = \"ls\"\n    args = [\"(\"]\n", err: true},
+		{desc: "invalid argument pattern of disabled policy", config: "[[exec.policy.deny]]\n  binary = \"ls\"\n  args = [\"[a\"]\n", err: true},
+	}
+
+	for _, tc := range cases {
+		file := filepath.Join(t.TempDir(), "config.toml")
+		err := os.WriteFile(file, []byte(tc.config), 0644)
+		assert.Nil(t, err, fmt.Sprintf("%s: unexpected error writing config: %s", tc.desc, err))
+		_, err = agent.ReadConfig(file)
+		assert.Equal(t, tc.err, err != nil, fmt.Sprintf("%s: unexpected error %v", tc.desc, err))
+	}
+
+	_, err := agent.ReadConfig("../../configs/config.toml")
+	assert.Nil(t, err, fmt.Sprintf("sample config: unexpected error %v", err))
+}
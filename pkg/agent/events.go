@@ -0,0 +1,208 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mainflux/agent/pkg/encoder"
+	"github.com/mainflux/mainflux/pkg/messaging"
+	"github.com/mainflux/senml"
+)
+
+const (
+	// Events is prefix of the NATS subjects of service state events,
+	// which are published to events.<service-name>.
+	Events = "events"
+	// eventsTopic is MQTT subtopic of service state events.
+	eventsTopic = "services/events"
+)
+
+// Event is published when service instance goes online or offline.
+// Flapping event is published instead of transitions once the instance
+// changes state too often, and the event with Flapping set to false
+// when it settles.
+type Event struct {
+	Name     string
+	Instance string
+	Host     string
+	Type     string
+	Status   string
+	Previous string
+	Flapping bool
+}
+
+// records returns SenML records of the event.
+func (e Event) records() []senml.Record {
+	return []senml.Record{
+		encoder.String("name", e.Name),
+		encoder.String("instance", e.Instance),
+		encoder.String("host", e.Host),
+		encoder.String("type", e.Type),
+		encoder.String("status", e.Status),
+		encoder.String("previous", e.Previous),
+		encoder.Bool("flapping", e.Flapping),
+	}
+}
+
+// publishEvent returns function publishing the event to MQTT
+// services/events subtopic of the control channel and to NATS.
+func (a *agent) publishEvent(ctx context.Context) func(Event) {
+	return func(e Event) {
+		a.logger.Info(fmt.Sprintf("Service '%s' instance '%s' on '%s' is %s, flapping: %t", e.Name, e.Instance, e.Host, e.Status, e.Flapping))
+		payload, err := a.channelCodec(control).Encode("", e.records())
+		if err != nil {
+			a.logger.Warn(fmt.Sprintf("Failed to encode service event: %s", err))
+			return
+		}
+		if err := a.Publish(eventsTopic, string(payload)); err != nil {
+			a.logger.Warn(fmt.Sprintf("Failed to publish service event: %s", err))
+		}
+		msg := &messaging.Message{
+			Channel: a.config.Channels.Control,
+			Payload: payload,
+			Created: time.Now().UnixNano(),
+		}
+		if err := a.broker.Publish(ctx, fmt.Sprintf("%s.%s", Events, e.Name), msg); err != nil {
+			a.logger.Warn(fmt.Sprintf("Failed to publish service event to NATS: %s", err))
+		}
+	}
+}
+
+// transitions tracks state changes of a service instance.
+type transitions struct {
+	info     Info
+	reported string
+	changes  []time.Time
+	flapping bool
+	timer    *time.Timer
+}
+
+// events debounces state changes of service instances and detects flapping.
+type events struct {
+	mu      sync.Mutex
+	cfg     HeartbeatConfig
+	states  map[svcKey]*transitions
+	publish func(Event)
+}
+
+func newEvents(cfg HeartbeatConfig, publish func(Event)) *events {
+	return &events{
+		cfg:     cfg,
+		states:  make(map[svcKey]*transitions),
+		publish: publish,
+	}
+}
+
+var _ Listener = (*events)(nil)
+
+// Changed records new state of the service instance. The first state
+// of the instance is its baseline and is not reported.
+func (e *events) Changed(info Info) {
+	key := svcKey{name: info.Name, instance: info.Instance, host: info.Host}
+	now := time.Now()
+
+	e.mu.Lock()
+	t, ok := e.states[key]
+	if !ok {
+		e.states[key] = &transitions{info: info, reported: info.Status}
+		e.mu.Unlock()
+		return
+	}
+	t.info = info
+	t.changes = append(prune(t.changes, now, e.cfg.FlapWindow), now)
+
+	var ev *Event
+	switch {
+	case t.flapping:
+	case e.cfg.FlapThreshold > 0 && e.cfg.FlapWindow > 0 && len(t.changes) >= e.cfg.FlapThreshold:
+		t.flapping = true
+		ev = &Event{Previous: t.reported, Flapping: true}
+		t.reported = info.Status
+	case e.cfg.Debounce > 0:
+		e.schedule(key, t, e.cfg.Debounce)
+	case info.Status != t.reported:
+		ev = &Event{Previous: t.reported}
+		t.reported = info.Status
+	}
+	if t.flapping {
+		e.schedule(key, t, e.cfg.FlapWindow)
+	}
+	e.mu.Unlock()
+
+	if ev != nil {
+		e.emit(*ev, info)
+	}
+}
+
+// Removed drops state of the removed service instance.
+func (e *events) Removed(info Info) {
+	key := svcKey{name: info.Name, instance: info.Instance, host: info.Host}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if t, ok := e.states[key]; ok && t.timer != nil {
+		t.timer.Stop()
+	}
+	delete(e.states, key)
+}
+
+// schedule settles the instance state after the delay.
+func (e *events) schedule(key svcKey, t *transitions, delay time.Duration) {
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	t.timer = time.AfterFunc(delay, func() { e.settle(key) })
+}
+
+// settle reports the state which lasted for the debounce interval,
+// or the end of flapping once there were no changes for the flap window.
+func (e *events) settle(key svcKey) {
+	now := time.Now()
+
+	e.mu.Lock()
+	t, ok := e.states[key]
+	if !ok {
+		e.mu.Unlock()
+		return
+	}
+	if t.flapping {
+		t.changes = prune(t.changes, now, e.cfg.FlapWindow)
+		if len(t.changes) > 0 {
+			e.schedule(key, t, t.changes[0].Add(e.cfg.FlapWindow).Sub(now))
+			e.mu.Unlock()
+			return
+		}
+		t.flapping = false
+	} else if t.info.Status == t.reported {
+		e.mu.Unlock()
+		return
+	}
+	ev := Event{Previous: t.reported}
+	t.reported = t.info.Status
+	info := t.info
+	e.mu.Unlock()
+
+	e.emit(ev, info)
+}
+
+func (e *events) emit(ev Event, info Info) {
+	ev.Name = info.Name
+	ev.Instance = info.Instance
+	ev.Host = info.Host
+	ev.Type = info.Type
+	ev.Status = info.Status
+	e.publish(ev)
+}
+
+// prune drops changes older than the window.
+func prune(changes []time.Time, now time.Time, window time.Duration) []time.Time {
+	i := 0
+	for i < len(changes) && now.Sub(changes[i]) > window {
+		i++
+	}
+	return changes[i:]
+}
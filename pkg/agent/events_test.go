@@ -0,0 +1,148 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package agent_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mainflux/agent/pkg/agent"
+	"github.com/mainflux/agent/pkg/agent/mocks"
+	"github.com/mainflux/mainflux/logger"
+	"github.com/mainflux/mainflux/pkg/messaging"
+	"github.com/mainflux/senml"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	healthy  = `{}`
+	failing  = `{"checks":[{"name":"db","status":"fail"}]}`
+	online   = "online"
+	degraded = "degraded"
+)
+
+type event struct {
+	status   string
+	previous string
+	flapping bool
+}
+
+// eventRecorder records service events published to NATS.
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []event
+}
+
+func (r *eventRecorder) Handle(msg *messaging.Message) error {
+	p, err := senml.Decode(msg.Payload, senml.JSON)
+	if err != nil {
+		return err
+	}
+	e := event{}
+	for _, rec := range p.Records {
+		switch {
+		case rec.Name == "status" && rec.StringValue != nil:
+			e.status = *rec.StringValue
+		case rec.Name == "previous" && rec.StringValue != nil:
+			e.previous = *rec.StringValue
+		case rec.Name == "flapping" && rec.BoolValue != nil:
+			e.flapping = *rec.BoolValue
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+	return nil
+}
+
+func (r *eventRecorder) Cancel() error {
+	return nil
+}
+
+func (r *eventRecorder) recorded() []event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]event{}, r.events...)
+}
+
+func TestEvents(t *testing.T) {
+	cases := []struct {
+		desc    string
+		cfg     agent.HeartbeatConfig
+		reports []string
+		wait    time.Duration
+		events  []event
+	}{
+		{
+			desc:    "change without debounce",
+			cfg:     agent.HeartbeatConfig{},
+			reports: []string{healthy, failing},
+			wait:    50 * time.Millisecond,
+			events:  []event{{status: degraded, previous: online}},
+		},
+		{
+			desc:    "debounced bounce",
+			cfg:     agent.HeartbeatConfig{Debounce: 100 * time.Millisecond},
+			reports: []string{healthy, failing, healthy},
+			wait:    300 * time.Millisecond,
+			events:  []event{},
+		},
+		{
+			desc:    "debounced change",
+			cfg:     agent.HeartbeatConfig{Debounce: 100 * time.Millisecond},
+			reports: []string{healthy, failing, healthy, failing},
+			wait:    300 * time.Millisecond,
+			events:  []event{{status: degraded, previous: online}},
+		},
+		{
+			desc:    "flapping at threshold",
+			cfg:     agent.HeartbeatConfig{FlapThreshold: 3, FlapWindow: time.Second},
+			reports: []string{healthy, failing, healthy, failing, healthy, failing},
+			wait:    100 * time.Millisecond,
+			events: []event{
+				{status: degraded, previous: online},
+				{status: online, previous: degraded},
+				{status: degraded, previous: online, flapping: true},
+			},
+		},
+		{
+			desc:    "settle after flap window",
+			cfg:     agent.HeartbeatConfig{FlapThreshold: 3, FlapWindow: 100 * time.Millisecond},
+			reports: []string{healthy, failing, healthy, failing, healthy},
+			wait:    400 * time.Millisecond,
+			events: []event{
+				{status: degraded, previous: online},
+				{status: online, previous: degraded},
+				{status: degraded, previous: online, flapping: true},
+				{status: online, previous: degraded},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		ctx, cancel := context.WithCancel(context.Background())
+		cfg := agent.Config{Heartbeat: tc.cfg}
+		cfg.Heartbeat.Interval = time.Minute
+		ps := mocks.NewPubSub()
+		_, err := agent.New(ctx, mocks.NewMQTTClient(), &cfg, mocks.NewEdgexClient(), ps, logger.NewMock())
+		if !assert.Nil(t, err, fmt.Sprintf("%s: unexpected error creating agent: %s", tc.desc, err)) {
+			cancel()
+			continue
+		}
+		r := &eventRecorder{}
+		err = ps.Subscribe(ctx, "test", fmt.Sprintf("%s.adc", agent.Events), r)
+		assert.Nil(t, err, fmt.Sprintf("%s: unexpected error subscribing: %s", tc.desc, err))
+
+		for _, report := range tc.reports {
+			err := ps.Publish(ctx, agent.Hearbeat, &messaging.Message{Channel: "heartbeat.adc.test", Payload: []byte(report)})
+			assert.Nil(t, err, fmt.Sprintf("%s: unexpected error publishing heartbeat: %s", tc.desc, err))
+		}
+		time.Sleep(tc.wait)
+		assert.Equal(t, tc.events, r.recorded(), fmt.Sprintf("%s: unexpected events", tc.desc))
+		cancel()
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/mainflux/agent/pkg/queue"
+	"github.com/mainflux/mainflux/pkg/errors"
+)
+
+const (
+	// forwardInterval is interval of checking whether queued messages can be sent.
+	forwardInterval = time.Second
+	// publishTimeout is max time to wait for the broker to accept published message.
+	publishTimeout = 30 * time.Second
+)
+
+var errPublishTimeout = errors.New("publish timed out")
+
+// publish sends message to the MQTT broker.
+func (a *agent) publish(m queue.Message) error {
+	return wait(a.publishToken(m))
+}
+
+// publishToken passes message to the MQTT client and returns
+// the token which completes once the broker accepts the message.
+func (a *agent) publishToken(m queue.Message) paho.Token {
+	var payload interface{} = m.Payload
+	if len(m.CorrelationData) > 0 || len(m.UserProperties) > 0 {
+		payload = ReplyMessage{
+			Payload:         []byte(m.Payload),
+			CorrelationData: m.CorrelationData,
+			UserProperties:  m.UserProperties,
+		}
+	}
+	cfg := a.config.MQTT
+	return a.mqttClient.Publish(m.Topic, cfg.QoS, cfg.Retain, payload)
+}
+
+func wait(token paho.Token) error {
+	if !token.WaitTimeout(publishTimeout) {
+		return errPublishTimeout
+	}
+	if err := token.Error(); err != nil {
+		return errors.New(err.Error())
+	}
+	return nil
+}
+
+// enqueue publishes message directly if connection is up and nothing is
+// waiting in the queue, otherwise message is queued to keep the order.
+// Lock is held only while the message is passed to the client, which
+// keeps the order of messages, and not while waiting for the broker.
+func (a *agent) enqueue(m queue.Message) error {
+	a.pubMu.Lock()
+	if a.queue.Len() == 0 && a.mqttClient.IsConnectionOpen() {
+		token := a.publishToken(m)
+		a.pubMu.Unlock()
+		err := wait(token)
+		if err == nil {
+			return nil
+		}
+		a.logger.Warn(fmt.Sprintf("Failed to publish to %s, queueing message: %s", m.Topic, err))
+		a.pubMu.Lock()
+	}
+	defer a.pubMu.Unlock()
+	m.Time = time.Now()
+	if err := a.queue.Push(m); err != nil {
+		return err
+	}
+	select {
+	case a.queued <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// forward sends queued messages in order whenever connection is up.
+func (a *agent) forward(ctx context.Context) {
+	ticker := time.NewTicker(forwardInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-a.queued:
+		}
+		for a.mqttClient.IsConnectionOpen() && a.forwardNext() {
+		}
+	}
+}
+
+// forwardNext sends the oldest queued message and returns true if there
+// may be more messages to send. Queued message is removed by its sequence
+// number only once the broker accepts it, so it's safe to wait without
+// the lock even if the message is dropped from the full queue meanwhile.
+func (a *agent) forwardNext() bool {
+	a.pubMu.Lock()
+	seq, m, err := a.queue.Peek()
+	switch {
+	case err == queue.ErrEmpty:
+		a.pubMu.Unlock()
+		return false
+	case err != nil:
+		a.pubMu.Unlock()
+		a.logger.Warn(fmt.Sprintf("Failed to read queued message: %s", err))
+		return true
+	}
+	token := a.publishToken(m)
+	a.pubMu.Unlock()
+	if err := wait(token); err != nil {
+		a.logger.Warn(fmt.Sprintf("Failed to forward queued message to %s: %s", m.Topic, err))
+		return false
+	}
+
+	a.pubMu.Lock()
+	defer a.pubMu.Unlock()
+	if err := a.queue.Pop(seq); err != nil {
+		a.logger.Warn(fmt.Sprintf("Failed to remove forwarded message: %s", err))
+	}
+	return true
+}
+
+func (a *agent) QueueStats() queue.Stats {
+	if a.queue == nil {
+		return queue.Stats{}
+	}
+	return a.queue.Stats()
+}
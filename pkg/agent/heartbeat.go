@@ -1,79 +1,404 @@
 package agent
 
 import (
+	"container/heap"
+	"context"
+	"os"
+	"sort"
 	"sync"
 	"time"
+
+	"github.com/mainflux/mainflux/pkg/errors"
 )
 
 const (
-	online  = "online"
-	offline = "offline"
+	online   = "online"
+	offline  = "offline"
+	degraded = "degraded"
 )
 
-// svc keeps info on service live status.
-// Services send heartbeat to nats thus updating last seen.
-// When service doesnt send heartbeat for some time gets marked offline.
-type svc struct {
-	info     Info
-	interval time.Duration
-	ticker   *time.Ticker
-	mu       sync.Mutex
-}
+// ErrServiceNotFound indicates that service is not tracked.
+var ErrServiceNotFound = errors.New("service not found")
 
+// Info is the state of a single service instance, with version,
+// health checks and metadata from the last heartbeat.
 type Info struct {
-	Name     string    `json:"name"`
-	LastSeen time.Time `json:"last_seen"`
-	Status   string    `json:"status"`
-	Type     string    `json:"type"`
-	Terminal int       `json:"terminal"`
+	Name     string            `json:"name"`
+	Instance string            `json:"instance"`
+	Host     string            `json:"host"`
+	LastSeen time.Time         `json:"last_seen"`
+	Status   string            `json:"status"`
+	Type     string            `json:"type"`
+	Terminal int               `json:"terminal"`
+	Version  string            `json:"version,omitempty"`
+	Build    string            `json:"build,omitempty"`
+	Health   []Check           `json:"health,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// ServiceInfo rolls up the instances of a service. Service is online
+// while at least one of its instances is online, otherwise it is
+// degraded while at least one of its instances is degraded.
+type ServiceInfo struct {
+	Name      string    `json:"name"`
+	Type      string    `json:"type"`
+	Status    string    `json:"status"`
+	LastSeen  time.Time `json:"last_seen"`
+	Online    int       `json:"online"`
+	Degraded  int       `json:"degraded"`
+	Offline   int       `json:"offline"`
+	Instances []Info    `json:"instances"`
+}
+
+// Listener is notified of changes of the tracked service instances.
+// Notifications are delivered by a single goroutine in the order of
+// the changes, after the change is made.
+type Listener interface {
+	// Changed is called with the initial state of the instance and
+	// on every status change.
+	Changed(Info)
+
+	// Removed is called when the instance is removed or evicted.
+	Removed(Info)
 }
 
 // Heartbeat specifies api for updating status and keeping track on services
-// that are sending heartbeat to NATS.
+// that are sending heartbeat to NATS. Instance that doesn't send heartbeat
+// during the interval is marked offline, and it is evicted once it stays
+// offline for the TTL.
 type Heartbeat interface {
-	Update()
-	Info() Info
+	// Update marks service instance as seen, online if the report is
+	// healthy and degraded otherwise. Instance seen for the first time
+	// is registered.
+	Update(name, svcType, instance, host string, r Report)
+
+	// Restore registers instances with their saved state. Online and
+	// degraded instances which don't send heartbeat during the interval
+	// are marked offline.
+	Restore([]Info)
+
+	// Remove stops tracking all instances of the service and returns them.
+	Remove(name string) ([]Info, error)
+
+	// Services returns tracked instances sorted by name, host and instance.
+	Services() []Info
+
+	// Close stops the scheduler.
+	Close()
+}
+
+var _ Heartbeat = (*heartbeat)(nil)
+
+// svcKey identifies service instance in the heartbeat registry.
+type svcKey struct {
+	name     string
+	instance string
+	host     string
 }
 
+// svc keeps info on service live status with the deadline of the next
+// status check. Index is the position in the deadlines heap, -1 when
+// no check is scheduled.
+type svc struct {
+	info     Info
+	deadline time.Time
+	index    int
+}
+
+// deadlines is min-heap of services ordered by deadline.
+type deadlines []*svc
+
+func (d deadlines) Len() int           { return len(d) }
+func (d deadlines) Less(i, j int) bool { return d[i].deadline.Before(d[j].deadline) }
+
+func (d deadlines) Swap(i, j int) {
+	d[i], d[j] = d[j], d[i]
+	d[i].index = i
+	d[j].index = j
+}
+
+func (d *deadlines) Push(x interface{}) {
+	s := x.(*svc)
+	s.index = len(*d)
+	*d = append(*d, s)
+}
+
+func (d *deadlines) Pop() interface{} {
+	old := *d
+	n := len(old)
+	s := old[n-1]
+	old[n-1] = nil
+	s.index = -1
+	*d = old[:n-1]
+	return s
+}
+
+// notification is a pending call of the listener.
+type notification struct {
+	info    Info
+	removed bool
+}
+
+// heartbeat tracks all services with a single scheduler goroutine,
+// which waits for the earliest deadline. Listener is called from a
+// single notifier goroutine, in the order in which changes are made.
+type heartbeat struct {
+	mu        sync.Mutex
+	interval  time.Duration
+	ttl       time.Duration
+	listener  Listener
+	svcs      map[svcKey]*svc
+	deadlines deadlines
+	wake      chan struct{}
+	pending   []notification
+	notify    chan struct{}
+	ctx       context.Context
+	cancel    context.CancelFunc
+}
+
+// NewHeartbeat returns heartbeat registry and starts its scheduler.
 // interval - duration of interval
 // if service doesnt send heartbeat during  interval it is marked offline.
-func NewHeartbeat(name, svcType string, interval time.Duration) Heartbeat {
-	ticker := time.NewTicker(interval)
-	s := svc{
-		info: Info{
-			Name:     name,
-			Status:   online,
-			Type:     svcType,
-			LastSeen: time.Now(),
-		},
-		ticker:   ticker,
+// ttl - time after which offline service is evicted, 0 disables eviction.
+// listener, if not nil, is notified of the instance changes.
+func NewHeartbeat(interval, ttl time.Duration, listener Listener) Heartbeat {
+	ctx, cancel := context.WithCancel(context.Background())
+	h := &heartbeat{
 		interval: interval,
+		ttl:      ttl,
+		listener: listener,
+		svcs:     make(map[svcKey]*svc),
+		wake:     make(chan struct{}, 1),
+		notify:   make(chan struct{}, 1),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	go h.run()
+	if listener != nil {
+		go h.notifier()
+	}
+	return h
+}
+
+func (h *heartbeat) Update(name, svcType, instance, host string, r Report) {
+	status := online
+	if !r.Healthy() {
+		status = degraded
+	}
+	now := time.Now()
+
+	h.mu.Lock()
+	key := svcKey{name: name, instance: instance, host: host}
+	s, ok := h.svcs[key]
+	if !ok {
+		s = &svc{info: Info{Name: name, Instance: instance, Host: host}, index: -1}
+		h.svcs[key] = s
+	}
+	changed := !ok || s.info.Status != status
+	s.info.Type = svcType
+	s.info.LastSeen = now
+	s.info.Status = status
+	s.info.Version = r.Version
+	s.info.Build = r.Build
+	s.info.Health = r.Checks
+	s.info.Metadata = r.Metadata
+	h.schedule(s, now.Add(h.interval))
+	if changed {
+		h.enqueue(s.info, false)
+	}
+	h.mu.Unlock()
+
+	signal(h.wake)
+}
+
+func (h *heartbeat) Restore(infos []Info) {
+	now := time.Now()
+	h.mu.Lock()
+	for _, info := range infos {
+		key := svcKey{name: info.Name, instance: info.Instance, host: info.Host}
+		if _, ok := h.svcs[key]; ok {
+			continue
+		}
+		s := &svc{info: info, index: -1}
+		h.svcs[key] = s
+		switch {
+		case info.Status != offline:
+			h.schedule(s, now.Add(h.interval))
+		case h.ttl > 0:
+			h.schedule(s, info.LastSeen.Add(h.interval+h.ttl))
+		}
+		h.enqueue(info, false)
 	}
-	s.listen()
-	return &s
+	h.mu.Unlock()
+
+	signal(h.wake)
+}
+
+func (h *heartbeat) Remove(name string) ([]Info, error) {
+	h.mu.Lock()
+	removed := []Info{}
+	for key, s := range h.svcs {
+		if key.name != name {
+			continue
+		}
+		h.remove(key, s)
+		removed = append(removed, s.info)
+	}
+	sortInfos(removed)
+	for _, info := range removed {
+		h.enqueue(info, true)
+	}
+	h.mu.Unlock()
+
+	if len(removed) == 0 {
+		return nil, errors.Wrap(ErrServiceNotFound, errors.New(name))
+	}
+	return removed, nil
+}
+
+func (h *heartbeat) Services() []Info {
+	h.mu.Lock()
+	infos := []Info{}
+	for _, s := range h.svcs {
+		infos = append(infos, s.info)
+	}
+	h.mu.Unlock()
+	sortInfos(infos)
+	return infos
 }
 
-func (s *svc) listen() {
-	go func() {
-		for range s.ticker.C {
-			// TODO - we can disable ticker when the status gets OFFLINE
-			// and on the next heartbeat enable it again.
-			s.mu.Lock()
-			if time.Now().After(s.info.LastSeen.Add(s.interval)) {
-				s.info.Status = offline
+func (h *heartbeat) Close() {
+	h.cancel()
+}
+
+func (h *heartbeat) run() {
+	for {
+		var timeout <-chan time.Time
+		var timer *time.Timer
+		h.mu.Lock()
+		if len(h.deadlines) > 0 {
+			timer = time.NewTimer(time.Until(h.deadlines[0].deadline))
+			timeout = timer.C
+		}
+		h.mu.Unlock()
+
+		select {
+		case <-h.ctx.Done():
+			if timer != nil {
+				timer.Stop()
 			}
-			s.mu.Unlock()
+			return
+		case <-h.wake:
+			if timer != nil {
+				timer.Stop()
+			}
+		case <-timeout:
+			h.expire(time.Now())
 		}
-	}()
+	}
 }
 
-func (s *svc) Update() {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.info.LastSeen = time.Now()
-	s.info.Status = online
+// expire marks services offline once their deadline passes, and
+// evicts the ones which stayed offline for the TTL.
+func (h *heartbeat) expire(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for len(h.deadlines) > 0 && !h.deadlines[0].deadline.After(now) {
+		s := heap.Pop(&h.deadlines).(*svc)
+		if s.info.Status == offline {
+			h.remove(svcKey{name: s.info.Name, instance: s.info.Instance, host: s.info.Host}, s)
+			h.enqueue(s.info, true)
+			continue
+		}
+		s.info.Status = offline
+		h.enqueue(s.info, false)
+		if h.ttl > 0 {
+			h.schedule(s, s.deadline.Add(h.ttl))
+		}
+	}
+}
+
+// schedule sets the next status check of the service.
+func (h *heartbeat) schedule(s *svc, deadline time.Time) {
+	s.deadline = deadline
+	if s.index < 0 {
+		heap.Push(&h.deadlines, s)
+		return
+	}
+	heap.Fix(&h.deadlines, s.index)
 }
 
-func (s *svc) Info() Info {
-	return s.info
+func (h *heartbeat) remove(key svcKey, s *svc) {
+	if s.index >= 0 {
+		heap.Remove(&h.deadlines, s.index)
+	}
+	delete(h.svcs, key)
+}
+
+// enqueue adds notification of the listener. It's called under the
+// lock, so that notifications are queued in the order of changes.
+func (h *heartbeat) enqueue(info Info, removed bool) {
+	if h.listener == nil {
+		return
+	}
+	h.pending = append(h.pending, notification{info: info, removed: removed})
+	signal(h.notify)
+}
+
+// notifier calls the listener with the queued notifications, and
+// delivers the remaining ones once the heartbeat is closed.
+func (h *heartbeat) notifier() {
+	for {
+		select {
+		case <-h.ctx.Done():
+			h.deliver()
+			return
+		case <-h.notify:
+			h.deliver()
+		}
+	}
+}
+
+func (h *heartbeat) deliver() {
+	h.mu.Lock()
+	pending := h.pending
+	h.pending = nil
+	h.mu.Unlock()
+	for _, n := range pending {
+		if n.removed {
+			h.listener.Removed(n.info)
+			continue
+		}
+		h.listener.Changed(n.info)
+	}
+}
+
+// signal wakes up the goroutine waiting on the channel, unless it's already signaled.
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+func sortInfos(infos []Info) {
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Name != infos[j].Name {
+			return infos[i].Name < infos[j].Name
+		}
+		if infos[i].Host != infos[j].Host {
+			return infos[i].Host < infos[j].Host
+		}
+		return infos[i].Instance < infos[j].Instance
+	})
+}
+
+// hostname is the default host of services that don't report it,
+// since they run on the same host as the agent.
+func hostname() string {
+	h, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	return h
 }
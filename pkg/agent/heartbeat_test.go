@@ -0,0 +1,176 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package agent_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mainflux/agent/pkg/agent"
+	"github.com/stretchr/testify/assert"
+)
+
+type listener struct {
+	changed chan agent.Info
+	removed chan agent.Info
+}
+
+func newListener() listener {
+	return listener{changed: make(chan agent.Info, 10), removed: make(chan agent.Info, 10)}
+}
+
+func (l listener) Changed(i agent.Info) { l.changed <- i }
+func (l listener) Removed(i agent.Info) { l.removed <- i }
+
+func next(ch chan agent.Info) string {
+	select {
+	case i := <-ch:
+		return i.Status
+	case <-time.After(time.Second):
+		return ""
+	}
+}
+
+func TestHeartbeatChanges(t *testing.T) {
+	l := newListener()
+	hb := agent.NewHeartbeat(50*time.Millisecond, 0, l)
+	defer hb.Close()
+
+	hb.Update("adc", "test", "1", "gateway", agent.Report{})
+	assert.Equal(t, "online", next(l.changed), "expected initial online state")
+	assert.Equal(t, "offline", next(l.changed), "expected offline state without heartbeat")
+	hb.Update("adc", "test", "1", "gateway", agent.Report{Checks: []agent.Check{{Name: "db", Status: "fail"}}})
+	assert.Equal(t, "degraded", next(l.changed), "expected degraded state on failing check")
+
+	infos := hb.Services()
+	if assert.Len(t, infos, 1, "expected single instance") {
+		assert.Equal(t, "1", infos[0].Instance, "unexpected instance")
+		assert.Equal(t, "gateway", infos[0].Host, "unexpected host")
+	}
+}
+
+func TestHeartbeatEviction(t *testing.T) {
+	l := newListener()
+	hb := agent.NewHeartbeat(20*time.Millisecond, 50*time.Millisecond, l)
+	defer hb.Close()
+
+	hb.Update("adc", "test", "1", "gateway", agent.Report{})
+	hb.Update("adc", "test", "2", "gateway", agent.Report{})
+	removed := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case info := <-l.removed:
+			removed[info.Instance] = true
+		case <-time.After(time.Second):
+		}
+	}
+	assert.Equal(t, map[string]bool{"1": true, "2": true}, removed, "expected evicted instances")
+	assert.Empty(t, hb.Services(), "expected no services after eviction")
+}
+
+func TestHeartbeatRemove(t *testing.T) {
+	hb := agent.NewHeartbeat(time.Minute, 0, nil)
+	defer hb.Close()
+
+	hb.Update("adc", "test", "1", "gateway", agent.Report{})
+	hb.Update("adc", "test", "2", "gateway", agent.Report{})
+	hb.Update("modbus", "test", "", "gateway", agent.Report{})
+
+	_, err := hb.Remove("unknown")
+	assert.NotNil(t, err, "expected error removing unknown service")
+
+	removed, err := hb.Remove("adc")
+	assert.Nil(t, err, fmt.Sprintf("unexpected error: %v", err))
+	assert.Len(t, removed, 2, "expected removed instances")
+	infos := hb.Services()
+	if assert.Len(t, infos, 1, "expected remaining service") {
+		assert.Equal(t, "modbus", infos[0].Name, "unexpected remaining service")
+	}
+}
+
+func TestHeartbeatRestore(t *testing.T) {
+	l := newListener()
+	hb := agent.NewHeartbeat(50*time.Millisecond, 0, l)
+	defer hb.Close()
+
+	hb.Restore([]agent.Info{
+		{Name: "adc", Instance: "1", Host: "gateway", Status: "online", LastSeen: time.Now().Add(-time.Hour)},
+		{Name: "modbus", Host: "gateway", Status: "offline", LastSeen: time.Now().Add(-time.Hour)},
+	})
+	assert.Equal(t, "online", next(l.changed), "expected restored online state")
+	assert.Equal(t, "offline", next(l.changed), "expected restored offline state")
+	assert.Len(t, hb.Services(), 2, "expected restored services")
+	assert.Equal(t, "offline", next(l.changed), "expected offline state without heartbeat after restore")
+}
+
+// recorder records statuses the listener is notified of.
+type recorder struct {
+	mu       sync.Mutex
+	statuses []string
+}
+
+func (r *recorder) Changed(i agent.Info) {
+	// Slow listener lets concurrent notifications overtake each other.
+	time.Sleep(10 * time.Microsecond)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.statuses = append(r.statuses, i.Status)
+}
+
+func (r *recorder) Removed(i agent.Info) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.statuses = append(r.statuses, "removed")
+}
+
+func (r *recorder) recorded() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string{}, r.statuses...)
+}
+
+func TestHeartbeatOrder(t *testing.T) {
+	r := &recorder{}
+	hb := agent.NewHeartbeat(time.Minute, 0, r)
+	defer hb.Close()
+
+	healthy := agent.Report{}
+	failing := agent.Report{Checks: []agent.Check{{Name: "db", Status: "fail"}}}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				rep := healthy
+				if (i+j)%2 == 0 {
+					rep = failing
+				}
+				hb.Update("adc", "test", "1", "gateway", rep)
+			}
+		}(i)
+	}
+	wg.Wait()
+	_, err := hb.Remove("adc")
+	assert.Nil(t, err, fmt.Sprintf("unexpected error: %v", err))
+
+	var statuses []string
+	for end := time.Now().Add(time.Second); time.Now().Before(end); time.Sleep(10 * time.Millisecond) {
+		statuses = r.recorded()
+		if len(statuses) > 0 && statuses[len(statuses)-1] == "removed" {
+			break
+		}
+	}
+	if !assert.NotEmpty(t, statuses, "expected notifications") {
+		return
+	}
+	assert.Equal(t, "removed", statuses[len(statuses)-1], "expected removal to be notified last")
+	for i := 1; i < len(statuses); i++ {
+		if !assert.NotEqual(t, statuses[i-1], statuses[i], fmt.Sprintf("expected status change at notification %d", i)) {
+			return
+		}
+	}
+}
@@ -0,0 +1,470 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mainflux/agent/pkg/encoder"
+	"github.com/mainflux/mainflux/pkg/errors"
+	"github.com/mainflux/senml"
+)
+
+const (
+	running  = "running"
+	done     = "done"
+	failed   = "failed"
+	canceled = "canceled"
+
+	jobStatus = "job-status"
+	jobList   = "job-list"
+	jobCancel = "job-cancel"
+
+	idName        = "id"
+	status        = "status"
+	stdoutName    = "stdout"
+	stderrName    = "stderr"
+	exitCodeName  = "exit"
+	durationName  = "duration"
+	truncatedName = "truncated"
+	limitName     = "limit"
+	errorName     = "error"
+
+	// chunkSize is max size of a single output record.
+	chunkSize = 4096
+	// maxPending is max size of output waiting to be published,
+	// output written over it is not streamed.
+	maxPending = 1024 * 1024
+	// maxOutput is max size of stdout and stderr kept for the result.
+	maxOutput = 64 * 1024
+	// waitDelay is time to wait for output after the job is killed.
+	waitDelay = 5 * time.Second
+	// maxFinishedJobs is number of finished jobs kept for status queries.
+	maxFinishedJobs = 100
+)
+
+var (
+	// ErrNotFound indicates that job doesn't exist.
+	ErrNotFound = errors.New("job not found")
+
+	// ErrJobFinished indicates that job can't be canceled since it is not running.
+	ErrJobFinished = errors.New("job already finished")
+)
+
+// Job represents command executed asynchronously by the agent.
+type Job struct {
+	ID        string        `json:"id"`
+	UUID      string        `json:"uuid"`
+	RequestID string        `json:"request_id,omitempty"`
+	Command   []string      `json:"command"`
+	Status    string        `json:"status"`
+	Started   time.Time     `json:"started"`
+	Finished  *time.Time    `json:"finished,omitempty"`
+	Duration  time.Duration `json:"duration,omitempty"`
+	ExitCode  *int          `json:"exit_code,omitempty"`
+	Limit     string        `json:"limit,omitempty"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// Result holds outcome of finished job.
+type Result struct {
+	Job
+	Stdout    string `json:"stdout"`
+	Stderr    string `json:"stderr"`
+	Truncated bool   `json:"truncated"`
+}
+
+type job struct {
+	Job
+	cancel context.CancelFunc
+}
+
+// jobs keeps track of running and recently finished jobs.
+type jobs struct {
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+func newJobs() *jobs {
+	return &jobs{jobs: make(map[string]*job)}
+}
+
+func (js *jobs) add(j *job) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	js.jobs[j.ID] = j
+}
+
+func (js *jobs) get(id string) (Job, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	j, ok := js.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	return j.Job, nil
+}
+
+func (js *jobs) list() []Job {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	ret := []Job{}
+	for _, j := range js.jobs {
+		ret = append(ret, j.Job)
+	}
+	sort.Slice(ret, func(i, k int) bool {
+		return ret[i].Started.Before(ret[k].Started)
+	})
+	return ret
+}
+
+func (js *jobs) cancel(id string) error {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	j, ok := js.jobs[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if j.Status != running {
+		return ErrJobFinished
+	}
+	j.Status = canceled
+	j.cancel()
+	return nil
+}
+
+// finish sets final job status and drops the oldest finished jobs
+// over the retention limit.
+func (js *jobs) finish(id string, exitCode int, limit string, err error) Job {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	j := js.jobs[id]
+	now := time.Now()
+	j.Finished = &now
+	j.Duration = now.Sub(j.Started)
+	j.ExitCode = &exitCode
+	j.Limit = limit
+	switch {
+	case j.Status == canceled:
+	case err != nil:
+		j.Status = failed
+		j.Error = err.Error()
+	default:
+		j.Status = done
+	}
+
+	finished := []*job{}
+	for _, j := range js.jobs {
+		if j.Finished != nil {
+			finished = append(finished, j)
+		}
+	}
+	if len(finished) > maxFinishedJobs {
+		sort.Slice(finished, func(i, k int) bool {
+			return finished[i].Finished.Before(*finished[k].Finished)
+		})
+		for _, j := range finished[:len(finished)-maxFinishedJobs] {
+			delete(js.jobs, j.ID)
+		}
+	}
+	return j.Job
+}
+
+// startJob starts the command in background and returns its ID.
+// Output is published on job topic as it arrives, followed by the result.
+// Output and result are encoded with the given encoder. Finished job is
+// saved to the audit log with the command string, its status and duration.
+func (a *agent) startJob(uuid, requestID, cmdStr string, enc encoder.Encoder, c Command) (string, error) {
+	limits := a.config.Exec.Limits
+	to := timeout(c.Timeout, a.config.Exec.Timeout)
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+	if to > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), to)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	wd := newWatchdog(limits, cancel)
+	id := newJobID()
+	bn := BaseName(uuid, requestID)
+	st := a.newStream(id, bn, enc)
+	stdout := newOutput(stdoutName, st, wd)
+	stderr := newOutput(stderrName, st, wd)
+
+	cmd := exec.CommandContext(ctx, c.Argv[0], c.Argv[1:]...)
+	cmd.Dir = c.Cwd
+	if len(c.Env) > 0 {
+		cmd.Env = append(os.Environ(), c.environ()...)
+	}
+	if c.Stdin != "" {
+		cmd.Stdin = strings.NewReader(c.Stdin)
+	}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.SysProcAttr = sysProcAttr()
+	cmd.Cancel = func() error {
+		return killGroup(cmd.Process)
+	}
+	cmd.WaitDelay = waitDelay
+	if err := startCommand(cmd, limits); err != nil {
+		cancel()
+		return "", errors.Wrap(errFailedExecute, err)
+	}
+	st.wg.Add(1)
+	go st.run()
+
+	j := &job{
+		Job: Job{
+			ID:        id,
+			UUID:      uuid,
+			RequestID: requestID,
+			Command:   c.Argv,
+			Status:    running,
+			Started:   time.Now(),
+		},
+		cancel: cancel,
+	}
+	a.jobs.add(j)
+
+	go func() {
+		err := cmd.Wait()
+		switch {
+		case wd.limit() != "":
+		case ctx.Err() == context.DeadlineExceeded:
+			wd.exceeded(timeoutLimit)
+		case cpuLimitHit(cmd.ProcessState, limits):
+			wd.exceeded(cpuLimit)
+		default:
+			if l := resourceLimitHit(cmd.ProcessState, stderr.buf.Bytes(), limits); l != "" {
+				wd.exceeded(l)
+			}
+		}
+		limit := wd.limit()
+		if limit != "" {
+			err = errors.Wrap(errLimitExceeded, errors.New(limit))
+		}
+		cancel()
+		// Result is published once the streamed output is.
+		dropped := st.close()
+		res := Result{
+			Job:       a.jobs.finish(id, cmd.ProcessState.ExitCode(), limit, err),
+			Stdout:    stdout.buf.String(),
+			Stderr:    stderr.buf.String(),
+			Truncated: stdout.truncated || stderr.truncated || dropped,
+		}
+		a.saveAudit(AuditEntry{
+			Time:      res.Started,
+			UUID:      uuid,
+			RequestID: requestID,
+			Type:      execCmd,
+			Args:      cmdStr,
+			Status:    res.Status,
+			Error:     res.Error,
+			Duration:  res.Duration,
+		})
+		a.publishResult(enc, res)
+	}()
+
+	return id, nil
+}
+
+// output streams command output as it is written
+// and keeps up to maxOutput bytes for the result.
+type output struct {
+	name      string
+	buf       bytes.Buffer
+	truncated bool
+	stream    *stream
+	watchdog  *watchdog
+}
+
+func newOutput(name string, st *stream, wd *watchdog) *output {
+	return &output{
+		name:     name,
+		stream:   st,
+		watchdog: wd,
+	}
+}
+
+func (o *output) Write(p []byte) (int, error) {
+	if !o.watchdog.write(len(p)) {
+		o.truncated = true
+		return len(p), nil
+	}
+	o.stream.write(o.name, p)
+	rem := maxOutput - o.buf.Len()
+	if rem < len(p) {
+		o.truncated = true
+		if rem < 0 {
+			rem = 0
+		}
+		o.buf.Write(p[:rem])
+		return len(p), nil
+	}
+	o.buf.Write(p)
+	return len(p), nil
+}
+
+// chunk is output record waiting to be published.
+type chunk struct {
+	name  string
+	value []byte
+}
+
+// stream publishes output of the job in the order it is written, from
+// its own goroutine, so that the command isn't blocked by the broker.
+// Consecutive writes of the same output are merged into chunks of up
+// to chunkSize bytes.
+type stream struct {
+	mu      sync.Mutex
+	id      string
+	bn      string
+	encoder encoder.Encoder
+	chunks  []chunk
+	pending int
+	dropped bool
+	closed  bool
+	wake    chan struct{}
+	wg      sync.WaitGroup
+	publish func(enc encoder.Encoder, id, bn, name, value string)
+}
+
+func (a *agent) newStream(id, bn string, enc encoder.Encoder) *stream {
+	return &stream{
+		id:      id,
+		bn:      bn,
+		encoder: enc,
+		wake:    make(chan struct{}, 1),
+		publish: a.publishJob,
+	}
+}
+
+// write queues output, which is dropped once more than maxPending
+// bytes are waiting to be published.
+func (s *stream) write(name string, p []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending+len(p) > maxPending {
+		s.dropped = true
+		return
+	}
+	s.pending += len(p)
+	for len(p) > 0 {
+		n := len(s.chunks)
+		if n == 0 || s.chunks[n-1].name != name || len(s.chunks[n-1].value) == chunkSize {
+			s.chunks = append(s.chunks, chunk{name: name, value: make([]byte, 0, chunkSize)})
+			n++
+		}
+		c := &s.chunks[n-1]
+		l := chunkSize - len(c.value)
+		if l > len(p) {
+			l = len(p)
+		}
+		c.value = append(c.value, p[:l]...)
+		p = p[l:]
+	}
+	signal(s.wake)
+}
+
+// run publishes queued chunks until the stream is closed and flushed.
+func (s *stream) run() {
+	defer s.wg.Done()
+	for {
+		<-s.wake
+		s.mu.Lock()
+		chunks, closed := s.chunks, s.closed
+		s.chunks = nil
+		s.pending = 0
+		s.mu.Unlock()
+		for _, c := range chunks {
+			s.publish(s.encoder, s.id, s.bn, c.name, string(c.value))
+		}
+		if closed {
+			return
+		}
+	}
+}
+
+// close waits for the queued output to be published and
+// returns true if any output was dropped.
+func (s *stream) close() bool {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	signal(s.wake)
+	s.wg.Wait()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+func (a *agent) publishJob(enc encoder.Encoder, id, bn, name, value string) {
+	payload, err := enc.Encode(bn, []senml.Record{encoder.String(name, value)})
+	if err != nil {
+		a.logger.Warn(fmt.Sprintf("Failed to encode output of job %s: %s", id, err))
+		return
+	}
+	if err := a.Publish(jobTopic(id), string(payload)); err != nil {
+		a.logger.Warn(fmt.Sprintf("Failed to publish output of job %s: %s", id, err))
+	}
+}
+
+// publishResult publishes the result pack on job topic.
+func (a *agent) publishResult(enc encoder.Encoder, res Result) {
+	records := jobRecords(res.Job)
+	records = append(records,
+		encoder.String(stdoutName, res.Stdout),
+		encoder.String(stderrName, res.Stderr),
+		encoder.Bool(truncatedName, res.Truncated),
+	)
+	payload, err := enc.Encode(BaseName(res.UUID, res.RequestID), records)
+	if err != nil {
+		a.logger.Warn(fmt.Sprintf("Failed to encode result of job %s: %s", res.ID, err))
+		return
+	}
+	if err := a.Publish(jobTopic(res.ID), string(payload)); err != nil {
+		a.logger.Warn(fmt.Sprintf("Failed to publish result of job %s: %s", res.ID, err))
+	}
+}
+
+// jobRecords returns records with job status, exit code and duration
+// of the finished job, and limit or error that stopped it, if any.
+func jobRecords(j Job) []senml.Record {
+	records := []senml.Record{
+		encoder.String(idName, j.ID),
+		encoder.String(status, j.Status),
+	}
+	if j.ExitCode != nil {
+		records = append(records,
+			encoder.Number(exitCodeName, float64(*j.ExitCode), ""),
+			encoder.Number(durationName, j.Duration.Seconds(), "s"),
+		)
+	}
+	if j.Limit != "" {
+		records = append(records, encoder.String(limitName, j.Limit))
+	}
+	if j.Error != "" {
+		records = append(records, encoder.String(errorName, j.Error))
+	}
+	return records
+}
+
+func jobTopic(id string) string {
+	return fmt.Sprintf("job/%s", id)
+}
+
+func newJobID() string {
+	return uuid.NewString()
+}
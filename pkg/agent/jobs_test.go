@@ -0,0 +1,219 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package agent_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mainflux/agent/pkg/agent"
+	"github.com/mainflux/agent/pkg/agent/mocks"
+	"github.com/mainflux/mainflux/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// waitJob waits for the job to leave running status.
+func waitJob(t *testing.T, svc agent.Service, id string) agent.Job {
+	for end := time.Now().Add(10 * time.Second); time.Now().Before(end); time.Sleep(10 * time.Millisecond) {
+		j, err := svc.Job(id)
+		if err == nil && j.Status != "running" {
+			return j
+		}
+	}
+	assert.Fail(t, fmt.Sprintf("job %s is still running", id))
+	return agent.Job{}
+}
+
+func TestJobLifecycle(t *testing.T) {
+	svc, mc := newAgent(t, agent.Config{})
+
+	cases := []struct {
+		desc   string
+		cmd    string
+		status string
+		exit   int
+		err    string
+	}{
+		{desc: "successful job", cmd: `{"argv":["/bin/sh","-c","echo out; echo err >&2"]}`, status: "done", exit: 0},
+		{desc: "failed job", cmd: `{"argv":["/bin/sh","-c","exit 2"]}`, status: "failed", exit: 2, err: "exit status 2"},
+	}
+
+	for _, tc := range cases {
+		id, err := svc.Execute(context.Background(), "1", tc.cmd)
+		assert.Nil(t, err, fmt.Sprintf("%s: unexpected error: %s", tc.desc, err))
+		j := waitJob(t, svc, id)
+		assert.Equal(t, tc.status, j.Status, fmt.Sprintf("%s: unexpected status", tc.desc))
+		if assert.NotNil(t, j.ExitCode, fmt.Sprintf("%s: expected exit code", tc.desc)) {
+			assert.Equal(t, tc.exit, *j.ExitCode, fmt.Sprintf("%s: unexpected exit code", tc.desc))
+		}
+		assert.Equal(t, tc.err, j.Error, fmt.Sprintf("%s: unexpected error", tc.desc))
+		assert.NotNil(t, j.Finished, fmt.Sprintf("%s: expected finish time", tc.desc))
+
+		res := jobResult(t, mc, id)
+		assert.Equal(t, tc.status, res["status"], fmt.Sprintf("%s: unexpected result status", tc.desc))
+		assert.Equal(t, fmt.Sprintf("%d", tc.exit), res["exit"], fmt.Sprintf("%s: unexpected result exit code", tc.desc))
+	}
+}
+
+func TestJobOutput(t *testing.T) {
+	svc, mc := newAgent(t, agent.Config{})
+
+	cases := []struct {
+		desc      string
+		cmd       string
+		stdout    int
+		stderr    string
+		kept      int
+		truncated string
+	}{
+		{
+			desc:      "output in single chunk",
+			cmd:       `{"argv":["/bin/sh","-c","printf out; printf err >&2"]}`,
+			stdout:    3,
+			stderr:    "err",
+			kept:      3,
+			truncated: "false",
+		},
+		{
+			desc:      "output in multiple chunks",
+			cmd:       `{"argv":["/bin/sh","-c","head -c 10000 /dev/zero | tr '\\0' a"]}`,
+			stdout:    10000,
+			kept:      10000,
+			truncated: "false",
+		},
+		{
+			desc:      "output over max result output",
+			cmd:       `{"argv":["/bin/sh","-c","head -c 70000 /dev/zero | tr '\\0' a"]}`,
+			stdout:    70000,
+			kept:      64 * 1024,
+			truncated: "true",
+		},
+	}
+
+	for _, tc := range cases {
+		id, err := svc.Execute(context.Background(), "1", tc.cmd)
+		if !assert.Nil(t, err, fmt.Sprintf("%s: unexpected error: %s", tc.desc, err)) {
+			continue
+		}
+		res := jobResult(t, mc, id)
+		assert.Equal(t, "done", res["status"], fmt.Sprintf("%s: unexpected status", tc.desc))
+		assert.Len(t, res["stdout"], tc.kept, fmt.Sprintf("%s: unexpected result stdout size", tc.desc))
+		assert.Equal(t, tc.stderr, res["stderr"], fmt.Sprintf("%s: unexpected result stderr", tc.desc))
+		assert.Equal(t, tc.truncated, res["truncated"], fmt.Sprintf("%s: unexpected truncated flag", tc.desc))
+
+		packs := records(mc, "job/"+id)
+		if !assert.NotEmpty(t, packs, fmt.Sprintf("%s: expected published output", tc.desc)) {
+			continue
+		}
+		assert.Equal(t, res, packs[len(packs)-1], fmt.Sprintf("%s: expected result published last", tc.desc))
+		stdout, stderr := "", ""
+		for _, p := range packs[:len(packs)-1] {
+			assert.LessOrEqual(t, len(p["stdout"])+len(p["stderr"]), 4096, fmt.Sprintf("%s: expected output chunk of at most 4096 bytes", tc.desc))
+			stdout += p["stdout"]
+			stderr += p["stderr"]
+		}
+		assert.Len(t, stdout, tc.stdout, fmt.Sprintf("%s: unexpected streamed stdout size", tc.desc))
+		assert.Equal(t, tc.stderr, stderr, fmt.Sprintf("%s: unexpected streamed stderr", tc.desc))
+	}
+}
+
+func TestJobCancel(t *testing.T) {
+	svc, mc := newAgent(t, agent.Config{})
+
+	id, err := svc.Execute(context.Background(), "1", `{"argv":["sleep","10"]}`)
+	assert.Nil(t, err, fmt.Sprintf("unexpected error: %s", err))
+	j, err := svc.Job(id)
+	assert.Nil(t, err, fmt.Sprintf("unexpected error: %s", err))
+	assert.Equal(t, "running", j.Status, "expected running job")
+	assert.Nil(t, j.Finished, "expected no finish time of running job")
+
+	cases := []struct {
+		desc string
+		id   string
+		err  error
+	}{
+		{desc: "cancel running job", id: id, err: nil},
+		{desc: "cancel canceled job", id: id, err: agent.ErrJobFinished},
+		{desc: "cancel unknown job", id: "unknown", err: agent.ErrNotFound},
+	}
+	for _, tc := range cases {
+		err := svc.CancelJob(tc.id)
+		assert.True(t, errors.Contains(err, tc.err), fmt.Sprintf("%s: expected %s got %s", tc.desc, tc.err, err))
+	}
+
+	assert.Equal(t, "canceled", jobResult(t, mc, id)["status"], "expected canceled result")
+	assert.Equal(t, "canceled", waitJob(t, svc, id).Status, "expected canceled job")
+	_, err = svc.Job("unknown")
+	assert.True(t, errors.Contains(err, agent.ErrNotFound), fmt.Sprintf("expected %s got %s", agent.ErrNotFound, err))
+}
+
+func TestJobControl(t *testing.T) {
+	svc, mc := newAgent(t, agent.Config{})
+	ctx := context.Background()
+
+	id, err := svc.Execute(ctx, "1", `{"argv":["sleep","10"]}`)
+	assert.Nil(t, err, fmt.Sprintf("unexpected error: %s", err))
+
+	err = svc.Handle(ctx, "job-status", "2", id)
+	assert.Nil(t, err, fmt.Sprintf("unexpected job-status error: %s", err))
+	assert.Contains(t, responses(mc, "2"), map[string]string{"id": id, "status": "running"}, "expected running job status")
+
+	err = svc.Handle(ctx, "job-list", "3", "")
+	assert.Nil(t, err, fmt.Sprintf("unexpected job-list error: %s", err))
+	list := responses(mc, "3")
+	if assert.Len(t, list, 1, "expected job list response") {
+		jobs := []agent.Job{}
+		err := json.Unmarshal([]byte(list[0]["job-list"]), &jobs)
+		assert.Nil(t, err, fmt.Sprintf("unexpected error decoding job list: %s", err))
+		if assert.Len(t, jobs, 1, "expected listed job") {
+			assert.Equal(t, id, jobs[0].ID, "unexpected listed job")
+		}
+	}
+
+	err = svc.Handle(ctx, "job-cancel", "4", id)
+	assert.Nil(t, err, fmt.Sprintf("unexpected job-cancel error: %s", err))
+	assert.Contains(t, responses(mc, "4"), map[string]string{"id": id, "status": "canceled"}, "expected canceled job status")
+
+	err = svc.Handle(ctx, "job-status", "5", "unknown")
+	assert.True(t, errors.Contains(err, agent.ErrNotFound), fmt.Sprintf("expected %s got %s", agent.ErrNotFound, err))
+}
+
+func TestJobRetention(t *testing.T) {
+	svc, mc := newAgent(t, agent.Config{})
+
+	ids := []string{}
+	for i := 0; i < 105; i++ {
+		id, err := svc.Execute(context.Background(), "1", `{"argv":["true"]}`)
+		if !assert.Nil(t, err, fmt.Sprintf("unexpected error: %s", err)) {
+			return
+		}
+		jobResult(t, mc, id)
+		ids = append(ids, id)
+	}
+
+	assert.Len(t, svc.Jobs(), 100, "expected finished jobs over retention limit to be dropped")
+	for i, id := range ids {
+		_, err := svc.Job(id)
+		if i < 5 {
+			assert.True(t, errors.Contains(err, agent.ErrNotFound), fmt.Sprintf("expected oldest job %d to be dropped", i))
+			continue
+		}
+		assert.Nil(t, err, fmt.Sprintf("expected job %d to be kept", i))
+	}
+}
+
+// responses returns values of the response packs with the uuid base name.
+func responses(mc *mocks.MQTTClient, uuid string) []map[string]string {
+	ret := []map[string]string{}
+	for _, r := range records(mc, "") {
+		if r["bn"] == uuid {
+			delete(r, "bn")
+			ret = append(ret, r)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,106 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/mainflux/mainflux/pkg/errors"
+)
+
+const (
+	timeoutLimit      = "timeout"
+	cpuLimit          = "cpu"
+	addressSpaceLimit = "address_space"
+	openFilesLimit    = "open_files"
+	outputLimit       = "output"
+)
+
+var (
+	// errLimitExceeded indicates that job was killed after hitting a limit.
+	errLimitExceeded = errors.New("limit exceeded")
+
+	errFailedLimits = errors.New("failed to set resource limits")
+)
+
+// allocationErrors are lower case messages of the common runtimes
+// and shells failing to allocate memory.
+var allocationErrors = [][]byte{
+	[]byte("cannot allocate"),
+	[]byte("out of memory"),
+	[]byte("memory exhausted"),
+	[]byte("memoryerror"),
+	[]byte("bad_alloc"),
+}
+
+// watchdog counts job output and remembers the first limit the job hit.
+type watchdog struct {
+	mu      sync.Mutex
+	limits  LimitsConfig
+	written int64
+	hit     string
+	cancel  context.CancelFunc
+}
+
+func newWatchdog(limits LimitsConfig, cancel context.CancelFunc) *watchdog {
+	return &watchdog{
+		limits: limits,
+		cancel: cancel,
+	}
+}
+
+// write accounts n bytes of output and returns false if the output
+// limit is exceeded, in which case the job is killed.
+func (w *watchdog) write(n int) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.written += int64(n)
+	if w.limits.Output <= 0 || w.written <= w.limits.Output {
+		return true
+	}
+	if w.hit == "" {
+		w.hit = outputLimit
+		w.cancel()
+	}
+	return false
+}
+
+func (w *watchdog) exceeded(limit string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.hit == "" {
+		w.hit = limit
+	}
+}
+
+func (w *watchdog) limit() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.hit
+}
+
+// timeout returns the request timeout bounded by the configured one.
+func timeout(req, cfg time.Duration) time.Duration {
+	if req <= 0 || (cfg > 0 && req > cfg) {
+		return cfg
+	}
+	return req
+}
+
+func allocationError(stderr []byte) bool {
+	s := bytes.ToLower(stderr)
+	for _, e := range allocationErrors {
+		if bytes.Contains(s, e) {
+			return true
+		}
+	}
+	return false
+}
+
+func openFilesError(stderr []byte) bool {
+	return bytes.Contains(bytes.ToLower(stderr), []byte("too many open files"))
+}
@@ -0,0 +1,137 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+// +build linux
+
+package agent
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"time"
+
+	"github.com/mainflux/mainflux/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// sysProcAttr starts child in its own process group,
+// so that it can be killed together with its children.
+func sysProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setpgid: true}
+}
+
+var errNotStopped = errors.New("process did not stop after exec")
+
+func killGroup(p *os.Process) error {
+	return syscall.Kill(-p.Pid, syscall.SIGKILL)
+}
+
+// startCommand starts the command with the resource limits. Command with
+// limits is traced, so that it stops right after exec, and the limits are
+// set before it runs. It is detached once they are set. CPU hard limit is
+// a second above the soft one, so that the process gets SIGXCPU before it
+// is killed.
+func startCommand(cmd *exec.Cmd, l LimitsConfig) error {
+	if l.CPU == 0 && l.AddressSpace == 0 && l.OpenFiles == 0 {
+		return cmd.Start()
+	}
+	// Tracer is the thread which started the command.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	cmd.SysProcAttr.Ptrace = true
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	pid := cmd.Process.Pid
+	err := stopped(pid)
+	if err == nil {
+		err = setLimits(pid, l)
+	}
+	if derr := unix.PtraceDetach(pid); err == nil && derr != nil {
+		err = derr
+	}
+	if err != nil {
+		killGroup(cmd.Process)
+		cmd.Wait()
+		return errors.Wrap(errFailedLimits, err)
+	}
+	return nil
+}
+
+// stopped waits for the traced process to stop after exec.
+func stopped(pid int) error {
+	var ws unix.WaitStatus
+	for {
+		_, err := unix.Wait4(pid, &ws, 0, nil)
+		switch {
+		case err == unix.EINTR:
+			continue
+		case err != nil:
+			return err
+		case !ws.Stopped():
+			return errNotStopped
+		default:
+			return nil
+		}
+	}
+}
+
+func setLimits(pid int, l LimitsConfig) error {
+	limits := []struct {
+		resource int
+		cur, max uint64
+	}{
+		{unix.RLIMIT_CPU, l.CPU, l.CPU + 1},
+		{unix.RLIMIT_AS, l.AddressSpace, l.AddressSpace},
+		{unix.RLIMIT_NOFILE, l.OpenFiles, l.OpenFiles},
+	}
+	for _, r := range limits {
+		if r.cur == 0 {
+			continue
+		}
+		if err := unix.Prlimit(pid, r.resource, &unix.Rlimit{Cur: r.cur, Max: r.max}, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cpuLimitHit returns true if the process was killed by SIGXCPU, or
+// by SIGKILL once it used up the CPU time.
+func cpuLimitHit(ps *os.ProcessState, l LimitsConfig) bool {
+	if l.CPU == 0 || ps == nil {
+		return false
+	}
+	ws, ok := ps.Sys().(syscall.WaitStatus)
+	if !ok || !ws.Signaled() {
+		return false
+	}
+	switch ws.Signal() {
+	case syscall.SIGXCPU:
+		return true
+	case syscall.SIGKILL:
+		return ps.UserTime()+ps.SystemTime() >= time.Duration(l.CPU)*time.Second
+	default:
+		return false
+	}
+}
+
+// resourceLimitHit returns address space or open files limit which
+// the failed process hit, if any. Processes don't get a signal for
+// these limits, so the configured limit is recognized only by the
+// error of the failed allocation or open in the output.
+func resourceLimitHit(ps *os.ProcessState, stderr []byte, l LimitsConfig) string {
+	if ps == nil || ps.Success() {
+		return ""
+	}
+	if l.OpenFiles > 0 && openFilesError(stderr) {
+		return openFilesLimit
+	}
+	if l.AddressSpace > 0 && allocationError(stderr) {
+		return addressSpaceLimit
+	}
+	return ""
+}
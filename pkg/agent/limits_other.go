@@ -0,0 +1,35 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux
+// +build !linux
+
+package agent
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+func sysProcAttr() *syscall.SysProcAttr {
+	return nil
+}
+
+func killGroup(p *os.Process) error {
+	return p.Kill()
+}
+
+// startCommand starts the command without the resource
+// limits, which are supported only on Linux.
+func startCommand(cmd *exec.Cmd, l LimitsConfig) error {
+	return cmd.Start()
+}
+
+func cpuLimitHit(ps *os.ProcessState, l LimitsConfig) bool {
+	return false
+}
+
+func resourceLimitHit(ps *os.ProcessState, stderr []byte, l LimitsConfig) string {
+	return ""
+}
@@ -0,0 +1,92 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+// +build linux
+
+package agent_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mainflux/agent/pkg/agent"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimitsApplied(t *testing.T) {
+	cfg := agent.Config{}
+	cfg.Exec.Limits = agent.LimitsConfig{CPU: 10, AddressSpace: 256 << 20, OpenFiles: 64}
+	svc, mc := newAgent(t, cfg)
+
+	id, err := svc.Execute(context.Background(), "1", `{"argv":["/bin/sh","-c","ulimit -t; ulimit -v; ulimit -n"]}`)
+	assert.Nil(t, err, fmt.Sprintf("unexpected error executing command: %s", err))
+	res := jobResult(t, mc, id)
+	assert.Equal(t, "done", res["status"], "unexpected job status")
+	assert.Equal(t, "10\n262144\n64\n", res["stdout"], "expected limits set before the command starts")
+}
+
+func TestLimitsHit(t *testing.T) {
+	cases := []struct {
+		desc    string
+		timeout time.Duration
+		limits  agent.LimitsConfig
+		cmd     string
+		limit   string
+	}{
+		{
+			desc:    "timeout",
+			timeout: 200 * time.Millisecond,
+			cmd:     `{"argv":["sleep","5"]}`,
+			limit:   "timeout",
+		},
+		{
+			desc:   "cpu",
+			limits: agent.LimitsConfig{CPU: 1},
+			cmd:    `{"argv":["/bin/sh","-c","while :; do :; done"]}`,
+			limit:  "cpu",
+		},
+		{
+			desc:   "address space",
+			limits: agent.LimitsConfig{AddressSpace: 16 << 20},
+			cmd:    `{"argv":["/bin/sh","-c","head -c 50000000 /dev/zero | sort > /dev/null"]}`,
+			limit:  "address_space",
+		},
+		{
+			desc:   "open files",
+			limits: agent.LimitsConfig{OpenFiles: 5},
+			cmd:    `{"argv":["/bin/sh","-c","exec 3</dev/null 4</dev/null 5</dev/null 6</dev/null 7</dev/null"]}`,
+			limit:  "open_files",
+		},
+		{
+			desc:   "output",
+			limits: agent.LimitsConfig{Output: 100},
+			cmd:    `{"argv":["/bin/sh","-c","head -c 10000 /dev/zero; sleep 5"]}`,
+			limit:  "output",
+		},
+		{
+			desc:   "within limits",
+			limits: agent.LimitsConfig{CPU: 10, AddressSpace: 256 << 20, OpenFiles: 64, Output: 100},
+			cmd:    `{"argv":["echo","ok"]}`,
+		},
+	}
+
+	for _, tc := range cases {
+		cfg := agent.Config{}
+		cfg.Exec.Timeout = tc.timeout
+		cfg.Exec.Limits = tc.limits
+		svc, mc := newAgent(t, cfg)
+
+		id, err := svc.Execute(context.Background(), "1", tc.cmd)
+		assert.Nil(t, err, fmt.Sprintf("%s: unexpected error executing command: %s", tc.desc, err))
+		res := jobResult(t, mc, id)
+		assert.Equal(t, tc.limit, res["limit"], fmt.Sprintf("%s: unexpected limit", tc.desc))
+		status := "done"
+		if tc.limit != "" {
+			status = "failed"
+		}
+		assert.Equal(t, status, res["status"], fmt.Sprintf("%s: unexpected job status", tc.desc))
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package mocks
+
+import (
+	"sync"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+var _ mqtt.Client = (*MQTTClient)(nil)
+
+// Message is a message published with the MQTT client mock.
+type Message struct {
+	Topic   string
+	Payload interface{}
+}
+
+// MQTTClient is connected MQTT client which records published messages.
+type MQTTClient struct {
+	mu        sync.Mutex
+	published []Message
+}
+
+// NewMQTTClient returns MQTT client mock.
+func NewMQTTClient() *MQTTClient {
+	return &MQTTClient{}
+}
+
+// Published returns messages published so far.
+func (c *MQTTClient) Published() []Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Message{}, c.published...)
+}
+
+func (c *MQTTClient) IsConnected() bool {
+	return true
+}
+
+func (c *MQTTClient) IsConnectionOpen() bool {
+	return true
+}
+
+func (c *MQTTClient) Connect() mqtt.Token {
+	return &mqtt.DummyToken{}
+}
+
+func (c *MQTTClient) Disconnect(uint) {}
+
+func (c *MQTTClient) Publish(topic string, _ byte, _ bool, payload interface{}) mqtt.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.published = append(c.published, Message{Topic: topic, Payload: payload})
+	return &mqtt.DummyToken{}
+}
+
+func (c *MQTTClient) Subscribe(string, byte, mqtt.MessageHandler) mqtt.Token {
+	return &mqtt.DummyToken{}
+}
+
+func (c *MQTTClient) SubscribeMultiple(map[string]byte, mqtt.MessageHandler) mqtt.Token {
+	return &mqtt.DummyToken{}
+}
+
+func (c *MQTTClient) Unsubscribe(...string) mqtt.Token {
+	return &mqtt.DummyToken{}
+}
+
+func (c *MQTTClient) AddRoute(string, mqtt.MessageHandler) {}
+
+func (c *MQTTClient) OptionsReader() mqtt.ClientOptionsReader {
+	return mqtt.NewClient(mqtt.NewClientOptions()).OptionsReader()
+}
@@ -0,0 +1,61 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package mocks
+
+import (
+	"context"
+	"sync"
+
+	"github.com/mainflux/mainflux/pkg/messaging"
+)
+
+var _ messaging.PubSub = (*PubSub)(nil)
+
+// PubSub is message broker mock which delivers published
+// messages to the handlers subscribed to the same topic.
+type PubSub struct {
+	mu       sync.Mutex
+	handlers map[string][]messaging.MessageHandler
+}
+
+// NewPubSub returns message broker mock.
+func NewPubSub() *PubSub {
+	return &PubSub{handlers: make(map[string][]messaging.MessageHandler)}
+}
+
+func (ps *PubSub) Publish(_ context.Context, topic string, msg *messaging.Message) error {
+	ps.mu.Lock()
+	handlers := append([]messaging.MessageHandler{}, ps.handlers[topic]...)
+	ps.mu.Unlock()
+	for _, h := range handlers {
+		if err := h.Handle(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ps *PubSub) Subscribe(_ context.Context, _, topic string, handler messaging.MessageHandler) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.handlers[topic] = append(ps.handlers[topic], handler)
+	return nil
+}
+
+func (ps *PubSub) Unsubscribe(_ context.Context, _, topic string) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	delete(ps.handlers, topic)
+	return nil
+}
+
+// IsConnected reports the broker connected, so that
+// the connection state is included in agent heartbeat.
+func (ps *PubSub) IsConnected() bool {
+	return true
+}
+
+func (ps *PubSub) Close() error {
+	return nil
+}
@@ -0,0 +1,227 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/mainflux/mainflux/pkg/errors"
+)
+
+var (
+	// ErrCommandRejected indicates that command is not permitted by exec policy.
+	ErrCommandRejected = errors.New("command rejected by policy")
+
+	// errInvalidPolicy indicates malformed exec policy configuration.
+	errInvalidPolicy = errors.New("invalid exec policy")
+)
+
+// dangerousEnv are patterns of environment variables which change
+// the binary that is run or the libraries it loads. They are denied
+// unless allowed by the exact name.
+var dangerousEnv = []string{"PATH", "LD_*", "DYLD_*", "IFS", "ENV", "BASH_ENV"}
+
+// Policy decides whether a command may be executed on the gateway.
+type Policy interface {
+	// Check returns nil if command may be executed with its arguments,
+	// environment and working directory.
+	Check(cmd Command) error
+}
+
+var _ Policy = (*policy)(nil)
+
+type rule struct {
+	binary string
+	args   []*regexp.Regexp
+}
+
+type policy struct {
+	enabled bool
+	allow   []rule
+	deny    []rule
+	env     []string
+	cwd     []string
+}
+
+// NewPolicy returns exec policy built from configuration.
+// Disabled policy permits every command.
+func NewPolicy(cfg PolicyConfig) (Policy, error) {
+	p := &policy{enabled: cfg.Enabled, env: cfg.Env}
+	for _, e := range cfg.Env {
+		if _, err := filepath.Match(e, ""); err != nil {
+			return nil, errors.Wrap(errInvalidPolicy, fmt.Errorf("env pattern %s: %s", e, err))
+		}
+	}
+	for _, d := range cfg.Cwd {
+		if !filepath.IsAbs(d) {
+			return nil, errors.Wrap(errInvalidPolicy, fmt.Errorf("cwd %s is not absolute", d))
+		}
+		p.cwd = append(p.cwd, filepath.Clean(d))
+	}
+	var err error
+	if p.allow, err = compileRules(cfg.Allow); err != nil {
+		return nil, err
+	}
+	if p.deny, err = compileRules(cfg.Deny); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func compileRules(rules []PolicyRule) ([]rule, error) {
+	ret := []rule{}
+	for _, r := range rules {
+		if r.Binary == "" {
+			return nil, errors.Wrap(errInvalidPolicy, errors.New("rule without binary"))
+		}
+		if _, err := filepath.Match(r.Binary, ""); err != nil {
+			return nil, errors.Wrap(errInvalidPolicy, fmt.Errorf("binary pattern %s: %s", r.Binary, err))
+		}
+		cr := rule{binary: r.Binary}
+		// Patterns match the whole argument, so that a pattern
+		// matching its part doesn't let the rest through.
+		for _, a := range r.Args {
+			re, err := regexp.Compile("^(?:" + a + ")$")
+			if err != nil {
+				return nil, errors.Wrap(errInvalidPolicy, fmt.Errorf("argument pattern %s: %s", a, err))
+			}
+			cr.args = append(cr.args, re)
+		}
+		ret = append(ret, cr)
+	}
+	return ret, nil
+}
+
+// Check rejects environment variables and working directory which are
+// not allowed, then applies deny rules first and requires a matching
+// allow rule. Deny rule without argument patterns rejects the binary
+// altogether, otherwise it rejects the command if any argument matches
+// any pattern. Allow rule without argument patterns permits any
+// arguments, otherwise every argument has to match at least one pattern.
+func (p *policy) Check(cmd Command) error {
+	if !p.enabled {
+		return nil
+	}
+	if err := p.checkEnv(cmd.Env); err != nil {
+		return err
+	}
+	if err := p.checkCwd(cmd.Cwd); err != nil {
+		return err
+	}
+	binary, args := cmd.Argv[0], cmd.Argv[1:]
+	if filepath.IsAbs(binary) {
+		binary = filepath.Clean(binary)
+	}
+	for _, r := range p.deny {
+		if !r.matchBinary(binary) {
+			continue
+		}
+		if len(r.args) == 0 {
+			return errors.Wrap(ErrCommandRejected, fmt.Errorf("binary %s is denied", binary))
+		}
+		for _, a := range args {
+			if r.matchArg(a) {
+				return errors.Wrap(ErrCommandRejected, fmt.Errorf("argument %s of %s is denied", a, binary))
+			}
+		}
+	}
+	for _, r := range p.allow {
+		if r.matchBinary(binary) && r.matchArgs(args) {
+			return nil
+		}
+	}
+	return errors.Wrap(ErrCommandRejected, fmt.Errorf("no allow rule for %s %s", binary, strings.Join(args, " ")))
+}
+
+func (p *policy) checkEnv(env map[string]string) error {
+	for k := range env {
+		if !p.allowEnv(k) {
+			return errors.Wrap(ErrCommandRejected, fmt.Errorf("environment variable %s is denied", k))
+		}
+	}
+	return nil
+}
+
+// allowEnv permits dangerous variables only if they are allowed by
+// the exact name, and other variables if they match any pattern.
+func (p *policy) allowEnv(key string) bool {
+	for _, e := range p.env {
+		if e == key {
+			return true
+		}
+	}
+	if matchAny(dangerousEnv, key) {
+		return false
+	}
+	return len(p.env) == 0 || matchAny(p.env, key)
+}
+
+// checkCwd requires working directory to be one of the allowed
+// directories or to be within one of them.
+func (p *policy) checkCwd(cwd string) error {
+	if cwd == "" || len(p.cwd) == 0 {
+		return nil
+	}
+	if filepath.IsAbs(cwd) {
+		cwd = filepath.Clean(cwd)
+		for _, d := range p.cwd {
+			if cwd == d || strings.HasPrefix(cwd, d+string(filepath.Separator)) || d == string(filepath.Separator) {
+				return nil
+			}
+		}
+	}
+	return errors.Wrap(ErrCommandRejected, fmt.Errorf("working directory %s is denied", cwd))
+}
+
+func matchAny(patterns []string, s string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matchBinary matches binary against the rule pattern. Rules given as
+// an absolute path also match bare names resolved through PATH, so that
+// allowing /usr/bin/ls permits ls but not ./ls.
+func (r rule) matchBinary(binary string) bool {
+	if ok, _ := filepath.Match(r.binary, binary); ok {
+		return true
+	}
+	if !filepath.IsAbs(r.binary) || strings.Contains(binary, "/") {
+		return false
+	}
+	path, err := exec.LookPath(binary)
+	if err != nil {
+		return false
+	}
+	ok, _ := filepath.Match(r.binary, filepath.Clean(path))
+	return ok
+}
+
+func (r rule) matchArgs(args []string) bool {
+	if len(r.args) == 0 {
+		return true
+	}
+	for _, a := range args {
+		if !r.matchArg(a) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r rule) matchArg(arg string) bool {
+	for _, re := range r.args {
+		if re.MatchString(arg) {
+			return true
+		}
+	}
+	return false
+}
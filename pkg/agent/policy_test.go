@@ -0,0 +1,118 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package agent_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/mainflux/agent/pkg/agent"
+	"github.com/mainflux/mainflux/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	p, err := agent.NewPolicy(agent.PolicyConfig{
+		Enabled: true,
+		Allow: []agent.PolicyRule{
+			{Binary: "ls", Args: []string{"-[al]+", "/tmp(/.*)?"}},
+			{Binary: "uptime"},
+			{Binary: "/opt/tools/*"},
+		},
+		Deny: []agent.PolicyRule{
+			{Binary: "/usr/bin/passwd"},
+			{Binary: "ls", Args: []string{"/tmp/secret(/.*)?", "a|/tmp/x"}},
+		},
+		Cwd: []string{"/tmp", "/var/log/"},
+	})
+	assert.Nil(t, err, fmt.Sprintf("unexpected error creating policy: %s", err))
+
+	cases := []struct {
+		desc string
+		cmd  agent.Command
+		err  error
+	}{
+		{"allowed binary with matching args", agent.Command{Argv: []string{"ls", "-la", "/tmp"}}, nil},
+		{"allowed binary with any args", agent.Command{Argv: []string{"uptime", "-p"}}, nil},
+		{"allowed binary matching glob", agent.Command{Argv: []string{"/opt/tools/check", "-v"}}, nil},
+		{"allowed binary with non matching arg", agent.Command{Argv: []string{"ls", "/etc"}}, agent.ErrCommandRejected},
+		{"denied binary", agent.Command{Argv: []string{"/usr/bin/passwd"}}, agent.ErrCommandRejected},
+		{"denied binary with unclean path", agent.Command{Argv: []string{"/usr/bin/../bin//passwd"}}, agent.ErrCommandRejected},
+		{"allowed binary with unclean path", agent.Command{Argv: []string{"/opt/tools/./check"}}, nil},
+		{"denied argument", agent.Command{Argv: []string{"ls", "/tmp/secret"}}, agent.ErrCommandRejected},
+		{"denied argument in subdirectory", agent.Command{Argv: []string{"ls", "/tmp/secret/key"}}, agent.ErrCommandRejected},
+		{"argument partially matching allow pattern", agent.Command{Argv: []string{"ls", "/tmpfs"}}, agent.ErrCommandRejected},
+		{"argument partially matching allow pattern with suffix", agent.Command{Argv: []string{"ls", "-l;reboot"}}, agent.ErrCommandRejected},
+		{"argument partially matching deny alternation", agent.Command{Argv: []string{"ls", "-la"}}, nil},
+		{"argument matching deny alternation", agent.Command{Argv: []string{"ls", "/tmp/x"}}, agent.ErrCommandRejected},
+		{"unknown binary", agent.Command{Argv: []string{"rm", "-rf", "/"}}, agent.ErrCommandRejected},
+		{"relative path not matching bare name", agent.Command{Argv: []string{"./ls"}}, agent.ErrCommandRejected},
+		{"allowed env", agent.Command{Argv: []string{"uptime"}, Env: map[string]string{"LANG": "C"}}, nil},
+		{"denied LD_PRELOAD", agent.Command{Argv: []string{"uptime"}, Env: map[string]string{"LD_PRELOAD": "/tmp/x.so"}}, agent.ErrCommandRejected},
+		{"denied LD_LIBRARY_PATH", agent.Command{Argv: []string{"uptime"}, Env: map[string]string{"LD_LIBRARY_PATH": "/tmp"}}, agent.ErrCommandRejected},
+		{"denied PATH", agent.Command{Argv: []string{"uptime"}, Env: map[string]string{"PATH": "/tmp"}}, agent.ErrCommandRejected},
+		{"allowed cwd", agent.Command{Argv: []string{"uptime"}, Cwd: "/var/log/nginx"}, nil},
+		{"denied cwd", agent.Command{Argv: []string{"uptime"}, Cwd: "/etc"}, agent.ErrCommandRejected},
+		{"denied cwd escaping allowed one", agent.Command{Argv: []string{"uptime"}, Cwd: "/tmp/../etc"}, agent.ErrCommandRejected},
+		{"denied cwd with allowed prefix", agent.Command{Argv: []string{"uptime"}, Cwd: "/tmpfs"}, agent.ErrCommandRejected},
+		{"denied relative cwd", agent.Command{Argv: []string{"uptime"}, Cwd: "tmp"}, agent.ErrCommandRejected},
+	}
+
+	for _, tc := range cases {
+		err := p.Check(tc.cmd)
+		assert.True(t, errors.Contains(err, tc.err), fmt.Sprintf("%s: expected %s got %s", tc.desc, tc.err, err))
+	}
+}
+
+func TestPolicyEnv(t *testing.T) {
+	p, err := agent.NewPolicy(agent.PolicyConfig{
+		Enabled: true,
+		Allow:   []agent.PolicyRule{{Binary: "uptime"}},
+		Env:     []string{"LC_*", "PATH"},
+	})
+	assert.Nil(t, err, fmt.Sprintf("unexpected error creating policy: %s", err))
+
+	cases := []struct {
+		desc string
+		env  map[string]string
+		err  error
+	}{
+		{"env matching pattern", map[string]string{"LC_ALL": "C"}, nil},
+		{"dangerous env allowed by name", map[string]string{"PATH": "/opt/bin"}, nil},
+		{"env not matching any pattern", map[string]string{"LANG": "C"}, agent.ErrCommandRejected},
+		{"dangerous env not allowed by name", map[string]string{"LD_PRELOAD": "/tmp/x.so"}, agent.ErrCommandRejected},
+	}
+
+	for _, tc := range cases {
+		err := p.Check(agent.Command{Argv: []string{"uptime"}, Env: tc.env})
+		assert.True(t, errors.Contains(err, tc.err), fmt.Sprintf("%s: expected %s got %s", tc.desc, tc.err, err))
+	}
+}
+
+func TestPolicyDisabled(t *testing.T) {
+	p, err := agent.NewPolicy(agent.PolicyConfig{
+		Deny: []agent.PolicyRule{{Binary: "rm"}},
+	})
+	assert.Nil(t, err, fmt.Sprintf("unexpected error creating policy: %s", err))
+	err = p.Check(agent.Command{Argv: []string{"rm", "-rf", "/tmp/x"}, Env: map[string]string{"LD_PRELOAD": "/tmp/x.so"}})
+	assert.Nil(t, err, fmt.Sprintf("disabled policy: expected no error got %s", err))
+}
+
+func TestNewPolicyInvalid(t *testing.T) {
+	cases := []struct {
+		desc string
+		cfg  agent.PolicyConfig
+	}{
+		{"rule without binary", agent.PolicyConfig{Allow: []agent.PolicyRule{{Args: []string{".*"}}}}},
+		{"invalid argument pattern", agent.PolicyConfig{Deny: []agent.PolicyRule{{Binary: "ls", Args: []string{"("}}}}},
+		{"invalid binary pattern", agent.PolicyConfig{Allow: []agent.PolicyRule{{Binary: "[ls"}}}},
+		{"invalid env pattern", agent.PolicyConfig{Env: []string{"[LC"}}},
+		{"relative cwd", agent.PolicyConfig{Cwd: []string{"tmp"}}},
+	}
+
+	for _, tc := range cases {
+		_, err := agent.NewPolicy(tc.cfg)
+		assert.NotNil(t, err, fmt.Sprintf("%s: expected error", tc.desc))
+	}
+}
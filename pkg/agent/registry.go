@@ -0,0 +1,167 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+
+	"github.com/mainflux/mainflux/pkg/errors"
+)
+
+const (
+	execCmd      = "exec"
+	serviceCmd   = "service"
+	termCmd      = "term"
+	capabilities = "capabilities"
+)
+
+var (
+	// ErrUnknownCommand indicates that there is no handler for the command.
+	ErrUnknownCommand = errors.New("unknown command")
+
+	// errHandlerExists indicates that handler with the same name is already registered.
+	errHandlerExists = errors.New("handler already registered")
+)
+
+// Handler handles command string received for given uuid.
+// Responses are published on the control channel by the handler.
+type Handler func(ctx context.Context, uuid, cmdStr string) error
+
+// HandlerInfo describes registered command handler.
+type HandlerInfo struct {
+	// Name is a command name, i.e. SenML record name of the request.
+	Name string `json:"name"`
+
+	// Description is a short description of the command.
+	Description string `json:"description"`
+
+	// Args describes format of the command string.
+	Args string `json:"args"`
+
+	// Auth is set for commands that are allowed only for authorized senders.
+	Auth bool `json:"auth"`
+}
+
+// Registry holds command handlers by command name.
+type Registry interface {
+	// Register adds handler for the command described by info.
+	Register(info HandlerInfo, h Handler) error
+
+	// Handle dispatches command to the registered handler.
+	Handle(ctx context.Context, name, uuid, cmdStr string) error
+
+	// Info returns info of the handler registered for the command.
+	Info(name string) (HandlerInfo, error)
+
+	// Handlers returns info of all registered handlers sorted by name.
+	Handlers() []HandlerInfo
+}
+
+var _ Registry = (*registry)(nil)
+
+type handler struct {
+	info   HandlerInfo
+	handle Handler
+}
+
+type registry struct {
+	mu       sync.RWMutex
+	handlers map[string]handler
+}
+
+// NewRegistry returns empty command handler registry.
+func NewRegistry() Registry {
+	return &registry{handlers: make(map[string]handler)}
+}
+
+func (r *registry) Register(info HandlerInfo, h Handler) error {
+	if info.Name == "" || h == nil {
+		return ErrMalformedEntity
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.handlers[info.Name]; ok {
+		return errors.Wrap(errHandlerExists, errors.New(info.Name))
+	}
+	r.handlers[info.Name] = handler{info: info, handle: h}
+	return nil
+}
+
+func (r *registry) Handle(ctx context.Context, name, uuid, cmdStr string) error {
+	r.mu.RLock()
+	h, ok := r.handlers[name]
+	r.mu.RUnlock()
+	if !ok {
+		return errors.Wrap(ErrUnknownCommand, errors.New(name))
+	}
+	return h.handle(ctx, uuid, cmdStr)
+}
+
+func (r *registry) Info(name string) (HandlerInfo, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	h, ok := r.handlers[name]
+	if !ok {
+		return HandlerInfo{}, errors.Wrap(ErrUnknownCommand, errors.New(name))
+	}
+	return h.info, nil
+}
+
+func (r *registry) Handlers() []HandlerInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ret := []HandlerInfo{}
+	for _, h := range r.handlers {
+		ret = append(ret, h.info)
+	}
+	sort.Slice(ret, func(i, k int) bool {
+		return ret[i].Name < ret[k].Name
+	})
+	return ret
+}
+
+// registerBuiltins registers handlers of the commands handled by the agent itself.
+func (a *agent) registerBuiltins() error {
+	jobCtl := func(cmd string) Handler {
+		return func(ctx context.Context, uuid, id string) error {
+			return a.JobControl(ctx, uuid, cmd, id)
+		}
+	}
+	execute := func(ctx context.Context, uuid, cmdStr string) error {
+		_, err := a.run(ctx, uuid, cmdStr)
+		return err
+	}
+
+	builtins := []handler{
+		{HandlerInfo{Name: execCmd, Description: "Execute command on the gateway as a job", Args: "<binary>,<arg>,... or JSON command", Auth: true}, execute},
+		{HandlerInfo{Name: control, Description: "EdgeX operations", Args: "edgex-operation|edgex-config|edgex-metrics|edgex-ping,<arg>,...", Auth: true}, a.Control},
+		{HandlerInfo{Name: config, Description: "View and save service config", Args: "view|save,<service>,<file>,<content>", Auth: true}, a.ServiceConfig},
+		{HandlerInfo{Name: serviceCmd, Description: "View and remove services", Args: "view|remove,<service>", Auth: true}, a.serviceControl},
+		{HandlerInfo{Name: termCmd, Description: "Terminal session", Args: "open|close|c,<data>", Auth: true}, a.Terminal},
+		{HandlerInfo{Name: jobStatus, Description: "View job status", Args: "<job_id>"}, jobCtl(jobStatus)},
+		{HandlerInfo{Name: jobList, Description: "List running and recently finished jobs", Args: ""}, jobCtl(jobList)},
+		{HandlerInfo{Name: jobCancel, Description: "Cancel running job", Args: "<job_id>", Auth: true}, jobCtl(jobCancel)},
+		{HandlerInfo{Name: auditCmd, Description: "View audit log", Args: "uuid=<uuid>&type=<command>&from=<time>&to=<time>&limit=<n>&format=json|senml", Auth: true}, a.auditQuery},
+		{HandlerInfo{Name: capabilities, Description: "List supported commands", Args: ""}, a.capabilities},
+	}
+	for _, h := range builtins {
+		if err := a.registry.Register(h.info, h.handle); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Message for this command
+// [{"bn":"1:", "n":"capabilities", "vs":""}]
+func (a *agent) capabilities(ctx context.Context, uuid, _ string) error {
+	b, err := json.Marshal(a.Capabilities())
+	if err != nil {
+		return errors.Wrap(errFailedEncode, err)
+	}
+	return a.processResponse(ctx, uuid, capabilities, string(b))
+}
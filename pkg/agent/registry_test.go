@@ -0,0 +1,55 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package agent_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/mainflux/agent/pkg/agent"
+	"github.com/mainflux/mainflux/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	r := agent.NewRegistry()
+	handled := ""
+	h := func(_ context.Context, uuid, cmdStr string) error {
+		handled = fmt.Sprintf("%s %s", uuid, cmdStr)
+		return nil
+	}
+
+	err := r.Register(agent.HandlerInfo{Name: "ping", Description: "Ping"}, h)
+	assert.Nil(t, err, fmt.Sprintf("unexpected error registering handler: %s", err))
+	err = r.Register(agent.HandlerInfo{Name: "echo", Args: "<text>", Auth: true}, h)
+	assert.Nil(t, err, fmt.Sprintf("unexpected error registering handler: %s", err))
+
+	cases := []struct {
+		desc string
+		info agent.HandlerInfo
+		h    agent.Handler
+	}{
+		{"duplicate handler", agent.HandlerInfo{Name: "ping"}, h},
+		{"handler without name", agent.HandlerInfo{}, h},
+		{"nil handler", agent.HandlerInfo{Name: "nil"}, nil},
+	}
+	for _, tc := range cases {
+		err := r.Register(tc.info, tc.h)
+		assert.NotNil(t, err, fmt.Sprintf("%s: expected error", tc.desc))
+	}
+
+	err = r.Handle(context.Background(), "echo", "1", "hi")
+	assert.Nil(t, err, fmt.Sprintf("unexpected error handling command: %s", err))
+	assert.Equal(t, "1 hi", handled, "handler called with unexpected arguments")
+
+	err = r.Handle(context.Background(), "reboot", "1", "")
+	assert.True(t, errors.Contains(err, agent.ErrUnknownCommand), fmt.Sprintf("expected %s got %s", agent.ErrUnknownCommand, err))
+
+	names := []string{}
+	for _, info := range r.Handlers() {
+		names = append(names, info.Name)
+	}
+	assert.Equal(t, []string{"echo", "ping"}, names, "unexpected handlers")
+}
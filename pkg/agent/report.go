@@ -0,0 +1,115 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/mainflux/agent/pkg/encoder"
+	"github.com/mainflux/mainflux/pkg/errors"
+)
+
+const (
+	checkPass = "pass"
+	checkFail = "fail"
+
+	checkPrefix  = "check/"
+	outputSuffix = "/output"
+	metaPrefix   = "meta/"
+)
+
+var errInvalidReport = errors.New("invalid heartbeat payload")
+
+// Check is the result of a service health check.
+type Check struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Output string `json:"output,omitempty"`
+}
+
+// Report is the heartbeat payload. Service reporting a failing
+// check is degraded.
+type Report struct {
+	Version  string            `json:"version,omitempty"`
+	Build    string            `json:"build,omitempty"`
+	Checks   []Check           `json:"checks,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// ParseReport parses heartbeat payload, which is either JSON object or
+// SenML pack in JSON or CBOR format with version and build string records,
+// check/<name> boolean records with optional check/<name>/output string
+// records and meta/<key> string records. Empty payload is empty report.
+func ParseReport(payload []byte) (Report, error) {
+	p := bytes.TrimLeft(payload, " \t\r\n")
+	if len(p) == 0 {
+		return Report{}, nil
+	}
+	if p[0] == '{' {
+		var r Report
+		if err := json.Unmarshal(payload, &r); err != nil {
+			return Report{}, errors.Wrap(errInvalidReport, err)
+		}
+		for _, c := range r.Checks {
+			if c.Status != checkPass && c.Status != checkFail {
+				return Report{}, errors.Wrap(errInvalidReport, errors.New("check status must be pass or fail"))
+			}
+		}
+		return r, nil
+	}
+
+	codec, err := encoder.Detect(payload)
+	if err != nil {
+		return Report{}, errors.Wrap(errInvalidReport, err)
+	}
+	records, err := codec.Decode(payload)
+	if err != nil {
+		return Report{}, errors.Wrap(errInvalidReport, err)
+	}
+	r := Report{}
+	checks := map[string]int{}
+	check := func(name string) *Check {
+		i, ok := checks[name]
+		if !ok {
+			i = len(r.Checks)
+			checks[name] = i
+			r.Checks = append(r.Checks, Check{Name: name, Status: checkPass})
+		}
+		return &r.Checks[i]
+	}
+	for _, rec := range records {
+		n := rec.BaseName + rec.Name
+		switch {
+		case n == "version" && rec.StringValue != nil:
+			r.Version = *rec.StringValue
+		case n == "build" && rec.StringValue != nil:
+			r.Build = *rec.StringValue
+		case strings.HasPrefix(n, checkPrefix) && strings.HasSuffix(n, outputSuffix) && rec.StringValue != nil:
+			check(strings.TrimSuffix(strings.TrimPrefix(n, checkPrefix), outputSuffix)).Output = *rec.StringValue
+		case strings.HasPrefix(n, checkPrefix) && rec.BoolValue != nil:
+			c := check(strings.TrimPrefix(n, checkPrefix))
+			if !*rec.BoolValue {
+				c.Status = checkFail
+			}
+		case strings.HasPrefix(n, metaPrefix) && rec.StringValue != nil:
+			if r.Metadata == nil {
+				r.Metadata = map[string]string{}
+			}
+			r.Metadata[strings.TrimPrefix(n, metaPrefix)] = *rec.StringValue
+		}
+	}
+	return r, nil
+}
+
+// Healthy returns true if none of the checks fails.
+func (r Report) Healthy() bool {
+	for _, c := range r.Checks {
+		if c.Status == checkFail {
+			return false
+		}
+	}
+	return true
+}
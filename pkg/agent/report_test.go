@@ -0,0 +1,57 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package agent_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/mainflux/agent/pkg/agent"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseReport(t *testing.T) {
+	cases := []struct {
+		desc    string
+		payload string
+		report  agent.Report
+		healthy bool
+		err     bool
+	}{
+		{desc: "empty payload", payload: "", report: agent.Report{}, healthy: true},
+		{
+			desc:    "JSON report",
+			payload: `{"version":"1.2.0","build":"3f2a1c","checks":[{"name":"db","status":"fail","output":"timeout"}],"metadata":{"site":"a"}}`,
+			report: agent.Report{
+				Version:  "1.2.0",
+				Build:    "3f2a1c",
+				Checks:   []agent.Check{{Name: "db", Status: "fail", Output: "timeout"}},
+				Metadata: map[string]string{"site": "a"},
+			},
+		},
+		{
+			desc:    "SenML report",
+			payload: `[{"n":"version","vs":"1.2.0"},{"n":"check/db","vb":true},{"n":"check/db/output","vs":"ok"},{"n":"meta/site","vs":"a"}]`,
+			report: agent.Report{
+				Version:  "1.2.0",
+				Checks:   []agent.Check{{Name: "db", Status: "pass", Output: "ok"}},
+				Metadata: map[string]string{"site": "a"},
+			},
+			healthy: true,
+		},
+		{desc: "invalid check status", payload: `{"checks":[{"name":"db","status":"ok"}]}`, err: true},
+		{desc: "invalid payload", payload: `{"version":`, err: true},
+	}
+
+	for _, tc := range cases {
+		r, err := agent.ParseReport([]byte(tc.payload))
+		if tc.err {
+			assert.NotNil(t, err, fmt.Sprintf("%s: expected error", tc.desc))
+			continue
+		}
+		assert.Nil(t, err, fmt.Sprintf("%s: unexpected error %v", tc.desc, err))
+		assert.Equal(t, tc.report, r, fmt.Sprintf("%s: unexpected report", tc.desc))
+		assert.Equal(t, tc.healthy, r.Healthy(), fmt.Sprintf("%s: unexpected health", tc.desc))
+	}
+}
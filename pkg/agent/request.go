@@ -0,0 +1,122 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"strings"
+
+	"github.com/mainflux/agent/pkg/encoder"
+	"github.com/mainflux/mainflux/pkg/errors"
+)
+
+// requestIDSeparator separates uuid and request ID in the base name.
+const requestIDSeparator = "/"
+
+// ErrInvalidRequestID indicates request ID which can't be carried in the base name.
+var ErrInvalidRequestID = errors.New("invalid request ID")
+
+type requestIDKey struct{}
+
+type encoderKey struct{}
+
+type replyKey struct{}
+
+// Reply holds MQTT v5 request-response properties of the command.
+// Responses are published to the response topic, or to the control
+// channel if it is not set, with the correlation data and user
+// properties of the command.
+type Reply struct {
+	Topic           string
+	CorrelationData []byte
+	UserProperties  map[string]string
+}
+
+// ReplyMessage is the payload of the response published with
+// MQTT v5 properties of the command.
+type ReplyMessage struct {
+	Payload         []byte
+	CorrelationData []byte
+	UserProperties  map[string]string
+}
+
+// WithRequestID returns context carrying the request ID.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID carried by the context.
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// WithEncoder returns context carrying the encoder of the response,
+// which overrides the encoder configured for the channel.
+func WithEncoder(ctx context.Context, e encoder.Encoder) context.Context {
+	return context.WithValue(ctx, encoderKey{}, e)
+}
+
+// ResponseEncoder returns the response encoder carried by the context, if any.
+func ResponseEncoder(ctx context.Context) (encoder.Encoder, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	e, ok := ctx.Value(encoderKey{}).(encoder.Encoder)
+	return e, ok
+}
+
+// WithReply returns context carrying MQTT v5 properties of the command.
+func WithReply(ctx context.Context, r Reply) context.Context {
+	return context.WithValue(ctx, replyKey{}, r)
+}
+
+// ReplyTo returns MQTT v5 properties of the command carried by the context, if any.
+func ReplyTo(ctx context.Context) (Reply, bool) {
+	if ctx == nil {
+		return Reply{}, false
+	}
+	r, ok := ctx.Value(replyKey{}).(Reply)
+	return r, ok
+}
+
+// ParseBaseName splits command base name in the form <uuid>/<request_id>:
+// into uuid and request ID. Trailing colon is optional, and request ID is
+// empty for the plain <uuid>: form. Request ID is recognized only after
+// the explicit separator, since uuid may contain colons, i.e. in the
+// urn:dev:mac:0024befffe804ff1 form.
+func ParseBaseName(bn string) (uuid, requestID string) {
+	bn = strings.TrimSuffix(bn, ":")
+	i := strings.LastIndex(bn, requestIDSeparator)
+	if i < 0 {
+		return bn, ""
+	}
+	return bn[:i], bn[i+len(requestIDSeparator):]
+}
+
+// ValidateRequestID rejects request IDs containing the separator or
+// ending with colon, which ParseBaseName would not give back from the
+// base name built with them.
+func ValidateRequestID(id string) error {
+	if strings.Contains(id, requestIDSeparator) || strings.HasSuffix(id, ":") {
+		return ErrInvalidRequestID
+	}
+	return nil
+}
+
+// BaseName returns response base name for the uuid and request ID.
+// Request ID has to be valid, i.e. received in the base name or
+// checked with ValidateRequestID.
+func BaseName(uuid, requestID string) string {
+	if requestID == "" {
+		return uuid
+	}
+	return uuid + requestIDSeparator + requestID
+}
@@ -0,0 +1,70 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package agent_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/mainflux/agent/pkg/agent"
+	"github.com/mainflux/mainflux/pkg/errors"
+	"github.com/mainflux/senml"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBaseName(t *testing.T) {
+	cases := []struct {
+		desc string
+		bn   string
+		uuid string
+		rid  string
+	}{
+		{desc: "plain uuid", bn: "1:", uuid: "1"},
+		{desc: "uuid without colon", bn: "1", uuid: "1"},
+		{desc: "uuid with request ID", bn: "1/3f9c2a:", uuid: "1", rid: "3f9c2a"},
+		{desc: "request ID without trailing colon", bn: "1/3f9c2a", uuid: "1", rid: "3f9c2a"},
+		{desc: "urn uuid", bn: "urn:dev:mac:0024befffe804ff1:", uuid: "urn:dev:mac:0024befffe804ff1"},
+		{desc: "urn uuid without colon", bn: "urn:dev:mac:0024befffe804ff1", uuid: "urn:dev:mac:0024befffe804ff1"},
+		{desc: "urn uuid with request ID", bn: "urn:dev:mac:0024befffe804ff1/3f9c2a:", uuid: "urn:dev:mac:0024befffe804ff1", rid: "3f9c2a"},
+	}
+
+	for _, tc := range cases {
+		_, err := senml.Decode([]byte(fmt.Sprintf(`[{"bn":%q,"n":"exec","vs":""}]`, tc.bn)), senml.JSON)
+		assert.Nil(t, err, fmt.Sprintf("%s: expected valid SenML base name, got %s", tc.desc, err))
+		uuid, rid := agent.ParseBaseName(tc.bn)
+		assert.Equal(t, tc.uuid, uuid, fmt.Sprintf("%s: unexpected uuid", tc.desc))
+		assert.Equal(t, tc.rid, rid, fmt.Sprintf("%s: unexpected request ID", tc.desc))
+		uuid, rid = agent.ParseBaseName(agent.BaseName(uuid, rid))
+		assert.Equal(t, tc.uuid, uuid, fmt.Sprintf("%s: expected uuid of the response base name", tc.desc))
+		assert.Equal(t, tc.rid, rid, fmt.Sprintf("%s: expected request ID of the response base name", tc.desc))
+	}
+}
+
+func TestRequestIDRoundTrip(t *testing.T) {
+	cases := []struct {
+		desc string
+		uuid string
+		rid  string
+		err  error
+	}{
+		{desc: "request ID", uuid: "1", rid: "3f9c2a"},
+		{desc: "request ID with colon", uuid: "1", rid: "job:3f9c2a"},
+		{desc: "request ID with dots and dashes", uuid: "urn:dev:mac:0024befffe804ff1", rid: "3f9c2a-1.2_3"},
+		{desc: "empty request ID", uuid: "1"},
+		{desc: "request ID with separator", uuid: "1", rid: "a/b", err: agent.ErrInvalidRequestID},
+		{desc: "request ID with trailing separator", uuid: "1", rid: "a/", err: agent.ErrInvalidRequestID},
+		{desc: "request ID with trailing colon", uuid: "1", rid: "a:", err: agent.ErrInvalidRequestID},
+	}
+
+	for _, tc := range cases {
+		err := agent.ValidateRequestID(tc.rid)
+		assert.True(t, errors.Contains(err, tc.err), fmt.Sprintf("%s: expected %v got %v", tc.desc, tc.err, err))
+		if err != nil {
+			continue
+		}
+		uuid, rid := agent.ParseBaseName(agent.BaseName(tc.uuid, tc.rid) + ":")
+		assert.Equal(t, tc.uuid, uuid, fmt.Sprintf("%s: unexpected uuid", tc.desc))
+		assert.Equal(t, tc.rid, rid, fmt.Sprintf("%s: unexpected request ID", tc.desc))
+	}
+}
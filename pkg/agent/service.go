@@ -8,20 +8,21 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"os/exec"
-	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	paho "github.com/eclipse/paho.mqtt.golang"
 	"github.com/mainflux/agent/pkg/edgex"
 	"github.com/mainflux/agent/pkg/encoder"
+	"github.com/mainflux/agent/pkg/queue"
 	"github.com/mainflux/agent/pkg/terminal"
 
 	exp "github.com/mainflux/export/pkg/config"
 	log "github.com/mainflux/mainflux/logger"
 	"github.com/mainflux/mainflux/pkg/errors"
 	"github.com/mainflux/mainflux/pkg/messaging"
+	"github.com/mainflux/senml"
 )
 
 const (
@@ -30,8 +31,9 @@ const (
 	Commands = "commands"
 	config   = "config"
 
-	view = "view"
-	save = "save"
+	view   = "view"
+	save   = "save"
+	remove = "remove"
 
 	char    = "c"
 	open    = "open"
@@ -54,9 +56,6 @@ var (
 	// ErrInvalidQueryParams indicates malformed URL.
 	ErrInvalidQueryParams = errors.New("invalid query params")
 
-	// errUnknownCommand indicates that command is not found.
-	errUnknownCommand = errors.New("Unknown command")
-
 	// errNatsSubscribing indicates problem with sub to topic for heartbeat.
 	errNatsSubscribing = errors.New("failed to subscribe to heartbeat topic")
 
@@ -84,11 +83,23 @@ var (
 
 // Service specifies API for publishing messages and subscribing to topics.
 type Service interface {
-	// Execute command.
-	Execute(string, string) (string, error)
+	// Execute starts command in background and returns its job ID.
+	Execute(context.Context, string, string) (string, error)
+
+	// Job returns job with given ID.
+	Job(string) (Job, error)
+
+	// Jobs returns list of running and recently finished jobs.
+	Jobs() []Job
+
+	// CancelJob stops running job.
+	CancelJob(string) error
+
+	// JobControl handles job status, list and cancel commands.
+	JobControl(context.Context, string, string, string) error
 
 	// Control command.
-	Control(string, string) error
+	Control(context.Context, string, string) error
 
 	// Update configuration file.
 	AddConfig(Config) error
@@ -99,14 +110,36 @@ type Service interface {
 	// Saves config file.
 	ServiceConfig(ctx context.Context, uuid, cmdStr string) error
 
-	// Services returns service list.
-	Services() []Info
+	// Services returns tracked services, each with the list of its instances.
+	Services() []ServiceInfo
+
+	// RemoveService stops tracking all instances of the service and returns them.
+	RemoveService(string) ([]Info, error)
 
 	// Terminal used for terminal control of gateway.
-	Terminal(string, string) error
+	Terminal(context.Context, string, string) error
 
 	// Publish message.
 	Publish(string, string) error
+
+	// Respond publishes the response on the control channel, or to the
+	// response topic of the MQTT v5 command carried by the context.
+	Respond(context.Context, string) error
+
+	// Register adds command handler to the agent command registry.
+	Register(HandlerInfo, Handler) error
+
+	// Handle dispatches command to the handler registered by command name.
+	Handle(ctx context.Context, name, uuid, cmdStr string) error
+
+	// Capabilities returns info of all registered command handlers.
+	Capabilities() []HandlerInfo
+
+	// Audit returns audit log entries matching the filter.
+	Audit(AuditFilter) ([]AuditEntry, error)
+
+	// QueueStats returns outbound queue counters.
+	QueueStats() queue.Stats
 }
 
 var _ Service = (*agent)(nil)
@@ -117,8 +150,29 @@ type agent struct {
 	edgexClient edgex.Client
 	logger      log.Logger
 	broker      messaging.PubSub
-	svcs        map[string]Heartbeat
+	policy      Policy
+	jobs        *jobs
+	registry    Registry
+	verifier    Verifier
+	audit       AuditLog
+	queue       queue.Queue
+	queued      chan struct{}
+	pubMu       sync.Mutex
+	codec       encoder.Codec
+	codecs      map[string]encoder.Codec
+	heartbeat   Heartbeat
+	events      *events
+	host        string
+	termMu      sync.Mutex
 	terminals   map[string]terminal.Session
+	termInput   termInput
+	started     time.Time
+}
+
+// errorRes is published on the control channel when command fails.
+type errorRes struct {
+	Error  string `json:"error"`
+	Reason string `json:"reason,omitempty"`
 }
 
 func (ag *agent) handle(ctx context.Context, pub messaging.Publisher, logger log.Logger, cfg HeartbeatConfig) handleFunc {
@@ -129,18 +183,23 @@ func (ag *agent) handle(ctx context.Context, pub messaging.Publisher, logger log
 			ag.logger.Error(fmt.Sprintf("Failed: Subject has incorrect length %s", sub))
 			return fmt.Errorf("Failed: Subject has incorrect length %s", sub)
 		}
-		svcname := tok[1]
-		svctype := tok[2]
-		// Service name is extracted from the subtopic
-		// if there is multiple instances of the same service
-		// we will have to add another distinction.
-		if _, ok := ag.svcs[svcname]; !ok {
-			svc := NewHeartbeat(svcname, svctype, cfg.Interval)
-			ag.svcs[svcname] = svc
-			ag.logger.Info(fmt.Sprintf("Services '%s-%s' registered", svcname, svctype))
+		// Subject is heartbeat.<name>.<type>[.<instance>[.<host>]].
+		// Host may contain dots, so it takes the rest of the subject.
+		svcname, svctype, instance, host := tok[1], tok[2], "", ag.host
+		if len(tok) > 3 {
+			instance = tok[3]
+		}
+		if len(tok) > 4 {
+			host = strings.Join(tok[4:], ".")
+		}
+		// Payloads of legacy services, which are not reports, are
+		// logged at debug level since they come with every heartbeat.
+		r, err := ParseReport(msg.Payload)
+		if err != nil {
+			ag.logger.Debug(fmt.Sprintf("Service '%s' instance '%s' heartbeat payload is treated as empty report: %s", svcname, instance, err))
+			r = Report{}
 		}
-		serv := ag.svcs[svcname]
-		serv.Update()
+		ag.heartbeat.Update(svcname, svctype, instance, host, r)
 		return nil
 	}
 }
@@ -157,21 +216,93 @@ func (h handleFunc) Cancel() error {
 
 // New returns agent service implementation.
 func New(ctx context.Context, mc paho.Client, cfg *Config, ec edgex.Client, broker messaging.PubSub, logger log.Logger) (Service, error) {
+	policy, err := NewPolicy(cfg.Exec.Policy)
+	if err != nil {
+		return nil, err
+	}
+
+	verifier, err := NewVerifier(cfg.Security)
+	if err != nil {
+		return nil, err
+	}
+
+	audit, err := NewAuditLog(cfg.Audit)
+	if err != nil {
+		return nil, err
+	}
+
+	codec, codecs, err := newCodecs(cfg.Channels)
+	if err != nil {
+		return nil, err
+	}
+
 	ag := &agent{
 		mqttClient:  mc,
 		edgexClient: ec,
 		config:      cfg,
 		broker:      broker,
 		logger:      logger,
-		svcs:        make(map[string]Heartbeat),
+		policy:      policy,
+		jobs:        newJobs(),
+		registry:    NewRegistry(),
+		verifier:    verifier,
+		audit:       audit,
+		codec:       codec,
+		codecs:      codecs,
+		host:        hostname(),
 		terminals:   make(map[string]terminal.Session),
+		started:     time.Now(),
+	}
+
+	if err := ag.registerBuiltins(); err != nil {
+		return nil, err
+	}
+
+	if cfg.Queue.Enabled {
+		if ag.queue, err = queue.New(cfg.Queue.Dir, cfg.Queue.MaxSize, cfg.Queue.MaxAge); err != nil {
+			return nil, err
+		}
+		ag.queued = make(chan struct{}, 1)
+		go ag.forward(ctx)
 	}
 
+	ag.events = newEvents(cfg.Heartbeat, ag.publishEvent(ctx))
+	var listener Listener = ag.events
+	var p *persister
+	if cfg.Heartbeat.File != "" {
+		p = &persister{
+			Listener: ag.events,
+			store:    NewServiceStore(cfg.Heartbeat.File),
+			services: func() []Info { return ag.heartbeat.Services() },
+			onError: func(err error) {
+				ag.logger.Warn(fmt.Sprintf("Failed to save services: %s", err))
+			},
+		}
+		listener = p
+	}
+	ag.heartbeat = NewHeartbeat(cfg.Heartbeat.Interval, cfg.Heartbeat.TTL, listener)
+	if p != nil {
+		infos, err := p.store.Load()
+		if err != nil {
+			ag.logger.Warn(fmt.Sprintf("Failed to load services: %s", err))
+		}
+		ag.heartbeat.Restore(infos)
+	}
+	go func() {
+		if p != nil {
+			p.run(ctx, cfg.Heartbeat.Interval)
+		}
+		<-ctx.Done()
+		ag.heartbeat.Close()
+	}()
+
 	if cfg.Heartbeat.Interval <= 0 {
 		ag.logger.Error(fmt.Sprintf("invalid heartbeat interval %d", cfg.Heartbeat.Interval))
+	} else {
+		go ag.selfHeartbeat(ctx, cfg.Heartbeat.Interval)
 	}
 
-	err := ag.broker.Subscribe(ctx, pubSubID, Hearbeat, ag.handle(ctx, ag.broker, logger, cfg.Heartbeat))
+	err = ag.broker.Subscribe(ctx, pubSubID, Hearbeat, ag.handle(ctx, ag.broker, logger, cfg.Heartbeat))
 
 	if err != nil {
 		return ag, errors.Wrap(errNatsSubscribing, err)
@@ -181,37 +312,206 @@ func New(ctx context.Context, mc paho.Client, cfg *Config, ec edgex.Client, brok
 
 }
 
-func (a *agent) Execute(uuid, cmd string) (string, error) {
-	cmdArr := strings.Split(strings.ReplaceAll(cmd, " ", ""), ",")
-	if len(cmdArr) < 2 {
-		return "", errInvalidCommand
+func (a *agent) Execute(ctx context.Context, uuid, cmdStr string) (string, error) {
+	begin := time.Now()
+	cmd, err := a.verify(ctx, execCmd, uuid, cmdStr)
+	if err != nil {
+		a.record(ctx, execCmd, uuid, cmdStr, begin, err)
+		if perr := a.processError(ctx, uuid, err); perr != nil {
+			a.logger.Warn(fmt.Sprintf("Failed to publish exec error for uuid %s: %s", uuid, perr))
+		}
+		return "", err
+	}
+	id, err := a.run(ctx, uuid, cmd)
+	if err != nil {
+		a.record(ctx, execCmd, uuid, cmd, begin, err)
+	}
+	return id, err
+}
+
+// run executes verified command and publishes the error if it fails.
+func (a *agent) run(ctx context.Context, uuid, cmdStr string) (string, error) {
+	id, err := a.execute(ctx, uuid, cmdStr)
+	if err != nil {
+		if perr := a.processError(ctx, uuid, err); perr != nil {
+			a.logger.Warn(fmt.Sprintf("Failed to publish exec error for uuid %s: %s", uuid, perr))
+		}
+		return "", err
+	}
+	return id, nil
+}
+
+func (a *agent) execute(ctx context.Context, uuid, cmdStr string) (string, error) {
+	cmd, err := ParseCommand(cmdStr)
+	if err != nil {
+		return "", err
+	}
+
+	if err := a.policy.Check(cmd); err != nil {
+		return "", err
 	}
 
-	out, err := exec.Command(cmdArr[0], cmdArr[1:]...).CombinedOutput()
+	id, err := a.startJob(uuid, RequestID(ctx), cmdStr, a.encoder(ctx, jobChannel), cmd)
 	if err != nil {
-		return "", errors.Wrap(errFailedExecute, err)
+		return "", err
+	}
+
+	if err := a.processResponse(ctx, uuid, cmd.Argv[0], id); err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+func (a *agent) Register(info HandlerInfo, h Handler) error {
+	return a.registry.Register(info, h)
+}
+
+func (a *agent) Handle(ctx context.Context, name, uuid, cmdStr string) error {
+	begin := time.Now()
+	cmd, err := a.verify(ctx, name, uuid, cmdStr)
+	if err != nil {
+		a.record(ctx, name, uuid, cmdStr, begin, err)
+		if perr := a.processError(ctx, uuid, err); perr != nil {
+			a.logger.Warn(fmt.Sprintf("Failed to publish %s error for uuid %s: %s", name, uuid, perr))
+		}
+		return err
+	}
+	err = a.registry.Handle(ctx, name, uuid, cmd)
+	switch {
+	case name == termCmd:
+		a.recordTerm(ctx, uuid, cmd, begin, err)
+	// Started jobs are recorded once they finish.
+	case name != execCmd || err != nil:
+		a.record(ctx, name, uuid, cmd, begin, err)
 	}
+	return err
+}
 
-	payload, err := encoder.EncodeSenML(uuid, cmdArr[0], string(out))
+// record saves the operation to the audit log.
+func (a *agent) record(ctx context.Context, typ, uuid, args string, begin time.Time, err error) {
+	e := AuditEntry{
+		Time:      begin,
+		UUID:      uuid,
+		RequestID: RequestID(ctx),
+		Type:      typ,
+		Args:      args,
+		Status:    statusOK,
+		Duration:  time.Since(begin),
+	}
 	if err != nil {
-		return "", errors.Wrap(errFailedEncode, err)
+		e.Status = statusError
+		e.Error = err.Error()
 	}
+	a.saveAudit(e)
+}
 
-	if err := a.Publish(control, string(payload)); err != nil {
-		return "", errors.Wrap(errFailedToPublish, err)
+func (a *agent) saveAudit(e AuditEntry) {
+	if err := a.audit.Save(e); err != nil {
+		a.logger.Error(fmt.Sprintf("Failed to save %s operation to audit log: %s", e.Type, err))
 	}
+}
 
-	return string(payload), nil
+func (a *agent) Audit(f AuditFilter) ([]AuditEntry, error) {
+	return a.audit.Retrieve(f)
 }
 
-func (a *agent) Control(uuid, cmdStr string) error {
-	cmdArgs := strings.Split(strings.ReplaceAll(cmdStr, " ", ""), ",")
-	if len(cmdArgs) < 2 {
-		return errInvalidCommand
+// Message for this command
+// [{"bn":"1:", "n":"audit", "vs":"uuid=1&type=exec&from=2023-07-01T00:00:00Z&limit=10&format=senml"}]
+func (a *agent) auditQuery(ctx context.Context, uuid, query string) error {
+	f, err := ParseAuditFilter(query)
+	if err != nil {
+		return err
 	}
+	entries, err := a.Audit(f)
+	if err != nil {
+		return err
+	}
+	if f.Format == formatSenML {
+		return a.processRecords(ctx, AuditRecords(entries))
+	}
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return errors.Wrap(errFailedEncode, err)
+	}
+	return a.processResponse(ctx, uuid, auditCmd, string(b))
+}
+
+// verify checks command signature if signed commands are enabled.
+// With auth_only set, signature is required only for commands
+// whose handlers require authorization.
+func (a *agent) verify(ctx context.Context, name, uuid, cmdStr string) (string, error) {
+	sec := a.config.Security
+	if !sec.Enabled {
+		return cmdStr, nil
+	}
+	required := true
+	if sec.AuthOnly {
+		info, err := a.registry.Info(name)
+		required = err != nil || info.Auth
+	}
+	return a.verifier.Verify(uuid, RequestID(ctx), name, cmdStr, required)
+}
+
+func (a *agent) Capabilities() []HandlerInfo {
+	return a.registry.Handlers()
+}
+
+func (a *agent) Job(id string) (Job, error) {
+	return a.jobs.get(id)
+}
+
+func (a *agent) Jobs() []Job {
+	return a.jobs.list()
+}
+
+func (a *agent) CancelJob(id string) error {
+	return a.jobs.cancel(id)
+}
+
+// Message for this command
+// [{"bn":"1:", "n":"job-status", "vs":"<job_id>"}]
+// [{"bn":"1:", "n":"job-list", "vs":""}]
+// [{"bn":"1:", "n":"job-cancel", "vs":"<job_id>"}]
+// Job status is reported with id, status, exit, duration, limit and error records,
+// while job list is reported as JSON.
+func (a *agent) JobControl(ctx context.Context, uuid, cmd, id string) error {
+	id = strings.TrimSpace(id)
+	switch cmd {
+	case jobStatus:
+	case jobList:
+		b, err := json.Marshal(a.Jobs())
+		if err != nil {
+			return errors.Wrap(errFailedEncode, err)
+		}
+		return a.processResponse(ctx, uuid, cmd, string(b))
+	case jobCancel:
+		if err := a.CancelJob(id); err != nil {
+			return err
+		}
+	default:
+		return ErrUnknownCommand
+	}
+	j, err := a.Job(id)
+	if err != nil {
+		return err
+	}
+	records := jobRecords(j)
+	bn := BaseName(uuid, RequestID(ctx))
+	for i := range records {
+		records[i].BaseName = bn
+	}
+	return a.processRecords(ctx, records)
+}
+
+func (a *agent) Control(ctx context.Context, uuid, cmdStr string) error {
+	c, err := ParseCommand(cmdStr)
+	if err != nil {
+		return err
+	}
+	cmdArgs := c.Argv
 
 	var resp string
-	var err error
 
 	cmd := cmdArgs[0]
 	switch cmd {
@@ -224,18 +524,18 @@ func (a *agent) Control(uuid, cmdStr string) error {
 	case "edgex-ping":
 		resp, err = a.edgexClient.Ping()
 	default:
-		err = errUnknownCommand
+		err = ErrUnknownCommand
 	}
 
 	if err != nil {
 		return errors.Wrap(errEdgexFailed, err)
 	}
 
-	return a.processResponse(uuid, cmd, resp)
+	return a.processResponse(ctx, uuid, cmd, resp)
 }
 
 // Message for this command
-// [{"bn":"1:", "n":"services", "vs":"view"}]
+// [{"bn":"1:", "n":"config", "vs":"view"}]
 // [{"bn":"1:", "n":"config", "vs":"save, export, filename, filecontent"}]
 // config_file_content is base64 encoded marshaled structure representing service conf
 // Example of creation:
@@ -266,11 +566,42 @@ func (a *agent) ServiceConfig(ctx context.Context, uuid, cmdStr string) error {
 		if err := a.saveConfig(ctx, service, fileName, fileCont); err != nil {
 			return err
 		}
+	default:
+		return errInvalidCommand
 	}
-	return a.processResponse(uuid, cmd, resp)
+	return a.processResponse(ctx, uuid, cmd, resp)
 }
 
-func (a *agent) Terminal(uuid, cmdStr string) error {
+// Message for this command
+// [{"bn":"1:", "n":"service", "vs":"view"}]
+// [{"bn":"1:", "n":"service", "vs":"remove, service_name"}]
+func (a *agent) serviceControl(ctx context.Context, uuid, cmdStr string) error {
+	cmdArgs := strings.Split(strings.ReplaceAll(cmdStr, " ", ""), ",")
+	var infos interface{}
+	cmd := cmdArgs[0]
+	switch cmd {
+	case view:
+		infos = a.Services()
+	case remove:
+		if len(cmdArgs) < 2 {
+			return errInvalidCommand
+		}
+		removed, err := a.RemoveService(cmdArgs[1])
+		if err != nil {
+			return err
+		}
+		infos = removed
+	default:
+		return errInvalidCommand
+	}
+	services, err := json.Marshal(infos)
+	if err != nil {
+		return errors.New(err.Error())
+	}
+	return a.processResponse(ctx, uuid, cmd, string(services))
+}
+
+func (a *agent) Terminal(ctx context.Context, uuid, cmdStr string) error {
 	b, err := base64.StdEncoding.DecodeString(cmdStr)
 	if err != nil {
 		return errors.New(err.Error())
@@ -303,8 +634,10 @@ func (a *agent) Terminal(uuid, cmdStr string) error {
 }
 
 func (a *agent) terminalOpen(uuid string, timeout time.Duration) error {
+	a.termMu.Lock()
+	defer a.termMu.Unlock()
 	if _, ok := a.terminals[uuid]; !ok {
-		term, err := terminal.NewSession(uuid, timeout, a.Publish, a.logger)
+		term, err := terminal.NewSession(uuid, timeout, a.channelCodec(termChannel), a.Publish, a.logger)
 		if err != nil {
 			return errors.Wrap(errors.Wrap(errFailedToCreateTerminalSession, fmt.Errorf(" for %s", uuid)), err)
 		}
@@ -314,7 +647,6 @@ func (a *agent) terminalOpen(uuid string, timeout time.Duration) error {
 				// Terminal is inactive, should be closed.
 				a.logger.Debug((fmt.Sprintf("Closing terminal session %s", uuid)))
 				a.terminalClose(uuid)
-				delete(a.terminals, uuid)
 				return
 			}
 		}()
@@ -324,6 +656,8 @@ func (a *agent) terminalOpen(uuid string, timeout time.Duration) error {
 }
 
 func (a *agent) terminalClose(uuid string) error {
+	a.termMu.Lock()
+	defer a.termMu.Unlock()
 	if _, ok := a.terminals[uuid]; ok {
 		delete(a.terminals, uuid)
 		a.logger.Debug(fmt.Sprintf("Terminal session: %s closed", uuid))
@@ -336,22 +670,72 @@ func (a *agent) terminalWrite(uuid, cmd string) error {
 	if err := a.terminalOpen(uuid, a.config.Terminal.SessionTimeout); err != nil {
 		return err
 	}
-	term := a.terminals[uuid]
+	a.termMu.Lock()
+	term, ok := a.terminals[uuid]
+	a.termMu.Unlock()
+	if !ok {
+		return errors.Wrap(errNoSuchTerminalSession, fmt.Errorf("session :%s", uuid))
+	}
 	p := []byte(cmd)
 	return term.Send(p)
 }
 
-func (a *agent) processResponse(uuid, cmd, resp string) error {
-	payload, err := encoder.EncodeSenML(uuid, cmd, resp)
+func (a *agent) processResponse(ctx context.Context, uuid, cmd, resp string) error {
+	bn := BaseName(uuid, RequestID(ctx))
+	if c := collector(ctx); c != nil {
+		c.Add(senml.Record{BaseName: bn, Name: cmd, StringValue: &resp})
+		return nil
+	}
+	payload, err := a.encoder(ctx, control).Encode(bn, []senml.Record{encoder.String(cmd, resp)})
+	if err != nil {
+		return errors.Wrap(errFailedEncode, err)
+	}
+	if err := a.Respond(ctx, string(payload)); err != nil {
+		return errors.Wrap(errFailedToPublish, err)
+	}
+	return nil
+}
+
+// processRecords publishes records as a single pack on the control channel.
+func (a *agent) processRecords(ctx context.Context, records []senml.Record) error {
+	if c := collector(ctx); c != nil {
+		for _, r := range records {
+			c.Add(r)
+		}
+		return nil
+	}
+	payload, err := a.encoder(ctx, control).Encode("", records)
 	if err != nil {
 		return errors.Wrap(errFailedEncode, err)
 	}
-	if err := a.Publish(control, string(payload)); err != nil {
+	if err := a.Respond(ctx, string(payload)); err != nil {
 		return errors.Wrap(errFailedToPublish, err)
 	}
 	return nil
 }
 
+// encoder returns response encoder carried by the context, or
+// the encoder configured for the channel.
+func (a *agent) encoder(ctx context.Context, channel string) encoder.Encoder {
+	if e, ok := ResponseEncoder(ctx); ok {
+		return e
+	}
+	return a.channelCodec(channel)
+}
+
+// channelCodec returns codec configured for the channel or the default one.
+func (a *agent) channelCodec(channel string) encoder.Codec {
+	if c, ok := a.codecs[channel]; ok {
+		return c
+	}
+	return a.codec
+}
+
+// processError publishes structured error response on the control channel.
+func (a *agent) processError(ctx context.Context, uuid string, err error) error {
+	return a.processResponse(ctx, uuid, errorName, ErrorResponse(err))
+}
+
 func (a *agent) saveConfig(ctx context.Context, service, fileName, fileCont string) error {
 	switch service {
 	case export:
@@ -376,38 +760,81 @@ func (a *agent) saveConfig(ctx context.Context, service, fileName, fileCont stri
 }
 
 func (a *agent) AddConfig(c Config) error {
+	begin := time.Now()
 	err := SaveConfig(c)
-	return errors.New(err.Error())
+	a.record(context.Background(), addConfig, "", c.File, begin, err)
+	return err
 }
 
 func (a *agent) Config() Config {
 	return *a.config
 }
 
-func (a *agent) Services() []Info {
-	svcInfos := []Info{}
-	keys := []string{}
-	for k := range a.svcs {
-		keys = append(keys, k)
-	}
-	sort.Strings(keys)
-	for _, key := range keys {
-		service := a.svcs[key].Info()
-		svcInfos = append(svcInfos, service)
+func (a *agent) Services() []ServiceInfo {
+	svcInfos := []ServiceInfo{}
+	for _, info := range a.heartbeat.Services() {
+		n := len(svcInfos)
+		if n == 0 || svcInfos[n-1].Name != info.Name {
+			svcInfos = append(svcInfos, ServiceInfo{Name: info.Name, Type: info.Type, Status: offline})
+			n++
+		}
+		s := &svcInfos[n-1]
+		s.Instances = append(s.Instances, info)
+		if info.LastSeen.After(s.LastSeen) {
+			s.LastSeen = info.LastSeen
+		}
+		switch info.Status {
+		case online:
+			s.Online++
+			s.Status = online
+		case degraded:
+			s.Degraded++
+			if s.Status == offline {
+				s.Status = degraded
+			}
+		default:
+			s.Offline++
+		}
 	}
 	return svcInfos
 }
 
-func (a *agent) Publish(t, payload string) error {
-	topic := a.getTopic(t)
-	mqtt := a.config.MQTT
-	token := a.mqttClient.Publish(topic, mqtt.QoS, mqtt.Retain, payload)
-	token.Wait()
-	err := token.Error()
+func (a *agent) RemoveService(name string) ([]Info, error) {
+	removed, err := a.heartbeat.Remove(name)
 	if err != nil {
-		return errors.New(err.Error())
+		return nil, err
 	}
-	return nil
+	a.logger.Info(fmt.Sprintf("Service '%s' removed", name))
+	return removed, nil
+}
+
+func (a *agent) Publish(t, payload string) error {
+	return a.send(queue.Message{Topic: a.getTopic(t), Payload: payload})
+}
+
+func (a *agent) Respond(ctx context.Context, payload string) error {
+	r, ok := ReplyTo(ctx)
+	if !ok {
+		return a.Publish(control, payload)
+	}
+	topic := r.Topic
+	if topic == "" {
+		topic = a.getTopic(control)
+	}
+	return a.send(queue.Message{
+		Topic:           topic,
+		Payload:         payload,
+		CorrelationData: r.CorrelationData,
+		UserProperties:  r.UserProperties,
+	})
+}
+
+// send publishes the message, through the queue if it is enabled.
+func (a *agent) send(m queue.Message) error {
+	if a.queue != nil {
+		return a.enqueue(m)
+	}
+	return a.publish(m)
 }
 
 func (a *agent) getTopic(topic string) (t string) {
@@ -0,0 +1,241 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package agent_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mainflux/agent/pkg/agent"
+	"github.com/mainflux/agent/pkg/agent/mocks"
+	"github.com/mainflux/mainflux/logger"
+	"github.com/mainflux/mainflux/pkg/errors"
+	"github.com/mainflux/mainflux/pkg/messaging"
+	"github.com/mainflux/senml"
+	"github.com/stretchr/testify/assert"
+)
+
+func newAgent(t *testing.T, cfg agent.Config) (agent.Service, *mocks.MQTTClient) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	if cfg.Heartbeat.Interval == 0 {
+		cfg.Heartbeat.Interval = time.Minute
+	}
+	mc := mocks.NewMQTTClient()
+	svc, err := agent.New(ctx, mc, &cfg, mocks.NewEdgexClient(), mocks.NewPubSub(), logger.NewMock())
+	if !assert.Nil(t, err, fmt.Sprintf("unexpected error creating agent: %s", err)) {
+		t.FailNow()
+	}
+	return svc, mc
+}
+
+// records returns values of SenML JSON records published to the
+// topic ending with the suffix, one map of values by name per pack.
+// Base name of the pack is kept under bn.
+func records(mc *mocks.MQTTClient, suffix string) []map[string]string {
+	ret := []map[string]string{}
+	for _, m := range mc.Published() {
+		payload, ok := m.Payload.(string)
+		if !ok || !strings.HasSuffix(m.Topic, suffix) {
+			continue
+		}
+		pack, err := senml.Decode([]byte(payload), senml.JSON)
+		if err != nil {
+			continue
+		}
+		values := map[string]string{}
+		for _, r := range pack.Records {
+			if r.BaseName != "" {
+				values["bn"] = r.BaseName
+			}
+			switch {
+			case r.StringValue != nil:
+				values[r.Name] = *r.StringValue
+			case r.BoolValue != nil:
+				values[r.Name] = fmt.Sprintf("%t", *r.BoolValue)
+			case r.Value != nil:
+				values[r.Name] = fmt.Sprintf("%g", *r.Value)
+			}
+		}
+		ret = append(ret, values)
+	}
+	return ret
+}
+
+// jobResult waits for the result pack of the job.
+func jobResult(t *testing.T, mc *mocks.MQTTClient, id string) map[string]string {
+	for end := time.Now().Add(10 * time.Second); time.Now().Before(end); time.Sleep(10 * time.Millisecond) {
+		for _, r := range records(mc, "job/"+id) {
+			if _, ok := r["status"]; ok {
+				return r
+			}
+		}
+	}
+	assert.Fail(t, fmt.Sprintf("no result of job %s", id))
+	return map[string]string{}
+}
+
+func TestHandleAuth(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err, fmt.Sprintf("unexpected error generating key: %s", err))
+	cfg := agent.Config{}
+	cfg.Security = agent.SecurityConfig{
+		Enabled:  true,
+		AuthOnly: true,
+		Keys:     []agent.SignatureKey{{ID: "ops", Key: pemKey(t, pub)}},
+	}
+	svc, _ := newAgent(t, cfg)
+
+	cases := []struct {
+		desc string
+		name string
+		cmd  string
+		err  error
+	}{
+		{desc: "unsigned service view", name: "service", cmd: "view", err: agent.ErrUnauthorized},
+		{desc: "unsigned service remove", name: "service", cmd: "remove,duster", err: agent.ErrUnauthorized},
+		{desc: "unsigned service save", name: "service", cmd: "save,export,export.toml,e30=", err: agent.ErrUnauthorized},
+		{desc: "unsigned config save", name: "config", cmd: "save,export,export.toml,e30=", err: agent.ErrUnauthorized},
+		{desc: "unsigned job list", name: "job-list", cmd: "", err: nil},
+	}
+	for _, tc := range cases {
+		err := svc.Handle(context.Background(), tc.name, "1", tc.cmd)
+		assert.True(t, errors.Contains(err, tc.err), fmt.Sprintf("%s: expected %v got %v", tc.desc, tc.err, err))
+	}
+}
+
+func TestServiceCommand(t *testing.T) {
+	svc, _ := newAgent(t, agent.Config{})
+
+	err := svc.Handle(context.Background(), "service", "1", "view")
+	assert.Nil(t, err, fmt.Sprintf("unexpected error viewing services: %s", err))
+	err = svc.Handle(context.Background(), "service", "1", "save,export,export.toml,e30=")
+	assert.NotNil(t, err, "expected error saving config with service command")
+	err = svc.Handle(context.Background(), "service", "1", "remove,duster")
+	assert.True(t, errors.Contains(err, agent.ErrServiceNotFound), fmt.Sprintf("expected %s got %s", agent.ErrServiceNotFound, err))
+}
+
+func TestExecAudit(t *testing.T) {
+	cfg := agent.Config{}
+	cfg.Audit = agent.AuditConfig{Enabled: true, File: filepath.Join(t.TempDir(), "audit.log")}
+	svc, mc := newAgent(t, cfg)
+
+	id, err := svc.Execute(context.Background(), "1", `{"argv":["/bin/sh","-c","sleep 0.1; exit 3"]}`)
+	assert.Nil(t, err, fmt.Sprintf("unexpected error executing command: %s", err))
+	entries, err := svc.Audit(agent.AuditFilter{Type: "exec"})
+	assert.Nil(t, err, fmt.Sprintf("unexpected error retrieving audit log: %s", err))
+	assert.Empty(t, entries, "expected no audit entry of the running job")
+
+	jobResult(t, mc, id)
+	entries, err = svc.Audit(agent.AuditFilter{Type: "exec"})
+	assert.Nil(t, err, fmt.Sprintf("unexpected error retrieving audit log: %s", err))
+	if assert.Len(t, entries, 1, "expected audit entry of the finished job") {
+		assert.Equal(t, "failed", entries[0].Status, "expected job status in audit entry")
+		assert.Equal(t, "exit status 3", entries[0].Error, "expected job error in audit entry")
+		assert.GreaterOrEqual(t, entries[0].Duration, 100*time.Millisecond, "expected job run time in audit entry")
+	}
+
+	_, err = svc.Execute(context.Background(), "1", `{"argv":[]}`)
+	assert.NotNil(t, err, "expected error executing invalid command")
+	entries, err = svc.Audit(agent.AuditFilter{Type: "exec"})
+	assert.Nil(t, err, fmt.Sprintf("unexpected error retrieving audit log: %s", err))
+	if assert.Len(t, entries, 2, "expected audit entry of the rejected command") {
+		assert.Equal(t, "error", entries[1].Status, "expected error status of the rejected command")
+	}
+}
+
+func TestTerminalAudit(t *testing.T) {
+	cfg := agent.Config{}
+	cfg.Terminal.SessionTimeout = time.Minute
+	cfg.Audit = agent.AuditConfig{
+		Enabled: true,
+		File:    filepath.Join(t.TempDir(), "audit.log"),
+		Redact:  []string{"password=(\\S+)"},
+	}
+	svc, _ := newAgent(t, cfg)
+
+	term := func(cmd string) {
+		err := svc.Handle(context.Background(), "term", "1", base64.StdEncoding.EncodeToString([]byte(cmd)))
+		assert.Nil(t, err, fmt.Sprintf("unexpected error handling terminal command %s: %s", cmd, err))
+	}
+	term("open")
+	for _, c := range "echo password=hunter2\r" {
+		term("c," + string(c))
+	}
+	term("c,ls -l\nexit")
+	term("close")
+
+	entries, err := svc.Audit(agent.AuditFilter{Type: "term"})
+	assert.Nil(t, err, fmt.Sprintf("unexpected error retrieving audit log: %s", err))
+	args := []string{}
+	for _, e := range entries {
+		args = append(args, e.Args)
+	}
+	assert.Equal(t, []string{"open", "input,echo password=***", "input,ls -l", "input,exit", "close"}, args, "unexpected audited terminal operations")
+}
+
+// warnings records warnings and errors logged by the agent.
+type warnings struct {
+	logger.Logger
+	mu       sync.Mutex
+	messages []string
+}
+
+func (w *warnings) Warn(msg string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.messages = append(w.messages, msg)
+}
+
+func (w *warnings) Error(msg string) {
+	w.Warn(msg)
+}
+
+func (w *warnings) logged() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]string{}, w.messages...)
+}
+
+func TestHeartbeatLegacyPayload(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg := agent.Config{}
+	cfg.Heartbeat.Interval = time.Minute
+	ps := mocks.NewPubSub()
+	w := &warnings{Logger: logger.NewMock()}
+	svc, err := agent.New(ctx, mocks.NewMQTTClient(), &cfg, mocks.NewEdgexClient(), ps, w)
+	if !assert.Nil(t, err, fmt.Sprintf("unexpected error creating agent: %s", err)) {
+		return
+	}
+
+	cases := []struct {
+		desc    string
+		payload string
+		status  string
+	}{
+		{desc: "failing report", payload: `{"checks":[{"name":"db","status":"fail"}]}`, status: "degraded"},
+		{desc: "plain text payload", payload: "alive", status: "online"},
+		{desc: "whitespace payload", payload: " \r\n", status: "online"},
+		{desc: "invalid JSON payload", payload: `{"version":`, status: "online"},
+	}
+
+	for _, tc := range cases {
+		err := ps.Publish(ctx, agent.Hearbeat, &messaging.Message{Channel: "heartbeat.adc.test", Payload: []byte(tc.payload)})
+		assert.Nil(t, err, fmt.Sprintf("%s: unexpected error publishing heartbeat: %s", tc.desc, err))
+		infos := svc.Services()
+		if assert.Len(t, infos, 1, fmt.Sprintf("%s: expected single service", tc.desc)) {
+			assert.Equal(t, tc.status, infos[0].Status, fmt.Sprintf("%s: unexpected status", tc.desc))
+		}
+	}
+	assert.Empty(t, w.logged(), "expected no warnings for heartbeat payloads")
+}
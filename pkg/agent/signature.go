@@ -0,0 +1,269 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"container/list"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mainflux/agent/pkg/encoder"
+	"github.com/mainflux/mainflux/pkg/errors"
+)
+
+const (
+	defMaxAge      = 5 * time.Minute
+	defReplayCache = 10000
+)
+
+var (
+	// ErrUnauthorized indicates missing or invalid command signature.
+	ErrUnauthorized = errors.New("unauthorized command")
+
+	// errInvalidKey indicates malformed trusted public key.
+	errInvalidKey = errors.New("invalid public key")
+
+	// errRepeatedNonce indicates replayed command.
+	errRepeatedNonce = errors.New("repeated nonce")
+
+	// errReplayCacheFull indicates that no more nonces can be remembered
+	// until some of them expire.
+	errReplayCacheFull = errors.New("replay cache full")
+)
+
+// Envelope is a signed command. It is sent as a JSON object in place
+// of the command string, either in the string or in the data value:
+//
+//	{"cmd":"ls,-l","ts":1588091188,"nonce":"3f9c2a61","kid":"ops","sig":"<base64 signature>"}
+//
+// Signature is calculated over the message returned by SignedMessage.
+type Envelope struct {
+	Command   string `json:"cmd"`
+	Timestamp int64  `json:"ts"`
+	Nonce     string `json:"nonce"`
+	KeyID     string `json:"kid,omitempty"`
+	Signature string `json:"sig"`
+}
+
+// SignedMessage returns the message signed by the sender of the command,
+// the uuid, request ID, command name, timestamp, nonce and command string,
+// each on its own line.
+func SignedMessage(uuid, requestID, name string, e Envelope) []byte {
+	return []byte(fmt.Sprintf("%s\n%s\n%s\n%d\n%s\n%s", uuid, requestID, name, e.Timestamp, e.Nonce, e.Command))
+}
+
+// Verifier checks signatures of received commands.
+type Verifier interface {
+	// Verify checks signed command envelope and returns the command string.
+	// Unsigned commands are returned as they are unless signature is required.
+	Verify(uuid, requestID, name, cmdStr string, required bool) (string, error)
+}
+
+var _ Verifier = (*verifier)(nil)
+
+type publicKey struct {
+	id  string
+	key crypto.PublicKey
+}
+
+type verifier struct {
+	keys   []publicKey
+	maxAge time.Duration
+	nonces *replayCache
+}
+
+// NewVerifier returns command signature verifier using trusted keys from configuration.
+func NewVerifier(cfg SecurityConfig) (Verifier, error) {
+	v := &verifier{
+		maxAge: cfg.MaxAge,
+	}
+	if v.maxAge <= 0 {
+		v.maxAge = defMaxAge
+	}
+	size := cfg.ReplayCache
+	if size <= 0 {
+		size = defReplayCache
+	}
+	v.nonces = newReplayCache(size)
+	for _, k := range cfg.Keys {
+		key, err := parseKey(k)
+		if err != nil {
+			return nil, err
+		}
+		v.keys = append(v.keys, publicKey{id: k.ID, key: key})
+	}
+	if cfg.Enabled && len(v.keys) == 0 {
+		return nil, errors.Wrap(errInvalidKey, errors.New("no trusted keys"))
+	}
+	return v, nil
+}
+
+func parseKey(k SignatureKey) (crypto.PublicKey, error) {
+	data := []byte(k.Key)
+	if k.File != "" {
+		var err error
+		if data, err = os.ReadFile(k.File); err != nil {
+			return nil, errors.Wrap(errInvalidKey, err)
+		}
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.Wrap(errInvalidKey, fmt.Errorf("key %s is not PEM encoded", k.ID))
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(errInvalidKey, err)
+	}
+	switch key.(type) {
+	case ed25519.PublicKey, *ecdsa.PublicKey:
+		return key, nil
+	default:
+		return nil, errors.Wrap(errInvalidKey, fmt.Errorf("key %s is neither Ed25519 nor ECDSA", k.ID))
+	}
+}
+
+func (v *verifier) Verify(uuid, requestID, name, cmdStr string, required bool) (string, error) {
+	e, ok := parseEnvelope(cmdStr)
+	if !ok {
+		if required {
+			return "", errors.Wrap(ErrUnauthorized, errors.New("missing signature"))
+		}
+		return cmdStr, nil
+	}
+
+	ts := time.Unix(e.Timestamp, 0)
+	if age := time.Since(ts); age > v.maxAge || age < -v.maxAge {
+		return "", errors.Wrap(ErrUnauthorized, errors.New("stale timestamp"))
+	}
+	if e.Nonce == "" || strings.Contains(e.Nonce, "\n") {
+		return "", errors.Wrap(ErrUnauthorized, errors.New("invalid nonce"))
+	}
+	sig, err := encoder.DecodeData(e.Signature)
+	if err != nil {
+		return "", errors.Wrap(ErrUnauthorized, err)
+	}
+	msg := SignedMessage(uuid, requestID, name, e)
+	if !v.verify(e.KeyID, msg, []byte(sig)) {
+		return "", errors.Wrap(ErrUnauthorized, errors.New("invalid signature"))
+	}
+	if err := v.nonces.add(e.Nonce, ts.Add(v.maxAge)); err != nil {
+		return "", errors.Wrap(ErrUnauthorized, err)
+	}
+	return e.Command, nil
+}
+
+// verify checks signature with the key with given ID, or with
+// each trusted key if key ID is not set.
+func (v *verifier) verify(kid string, msg, sig []byte) bool {
+	for _, k := range v.keys {
+		if kid != "" && k.id != kid {
+			continue
+		}
+		if verifySignature(k.key, msg, sig) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifySignature verifies Ed25519 signature of the message or ASN.1
+// encoded ECDSA signature of the message digest. Digest is SHA-256,
+// SHA-384 or SHA-512, depending on the curve size.
+func verifySignature(key crypto.PublicKey, msg, sig []byte) bool {
+	switch k := key.(type) {
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, msg, sig)
+	case *ecdsa.PublicKey:
+		h := crypto.SHA256
+		switch size := k.Curve.Params().BitSize; {
+		case size > 384:
+			h = crypto.SHA512
+		case size > 256:
+			h = crypto.SHA384
+		}
+		d := h.New()
+		d.Write(msg)
+		return ecdsa.VerifyASN1(k, d.Sum(nil), sig)
+	default:
+		return false
+	}
+}
+
+func parseEnvelope(cmdStr string) (Envelope, bool) {
+	s := strings.TrimSpace(cmdStr)
+	if !strings.HasPrefix(s, "{") {
+		return Envelope{}, false
+	}
+	e := Envelope{}
+	if err := json.Unmarshal([]byte(s), &e); err != nil || e.Signature == "" {
+		return Envelope{}, false
+	}
+	return e, true
+}
+
+// replayCache keeps nonces until they expire. When the cache is full
+// of live nonces, new nonces are rejected, since dropping a live nonce
+// would allow the command signed with it to be replayed.
+type replayCache struct {
+	mu     sync.Mutex
+	size   int
+	order  *list.List
+	nonces map[string]*list.Element
+}
+
+type nonce struct {
+	value   string
+	expires time.Time
+}
+
+func newReplayCache(size int) *replayCache {
+	return &replayCache{
+		size:   size,
+		order:  list.New(),
+		nonces: make(map[string]*list.Element),
+	}
+}
+
+// add saves the nonce, returning error if it has already been
+// seen or if the cache is full.
+func (c *replayCache) add(value string, expires time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for e := c.order.Front(); e != nil && !e.Value.(nonce).expires.After(now); e = c.order.Front() {
+		c.remove(e)
+	}
+	if _, ok := c.nonces[value]; ok {
+		return errRepeatedNonce
+	}
+	if c.order.Len() >= c.size {
+		// Nonces expire out of order, since timestamps are set by the signer.
+		for e := c.order.Front(); e != nil; {
+			next := e.Next()
+			if !e.Value.(nonce).expires.After(now) {
+				c.remove(e)
+			}
+			e = next
+		}
+	}
+	if c.order.Len() >= c.size {
+		return errReplayCacheFull
+	}
+	c.nonces[value] = c.order.PushBack(nonce{value: value, expires: expires})
+	return nil
+}
+
+func (c *replayCache) remove(e *list.Element) {
+	c.order.Remove(e)
+	delete(c.nonces, e.Value.(nonce).value)
+}
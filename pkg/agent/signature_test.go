@@ -0,0 +1,148 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package agent_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mainflux/agent/pkg/agent"
+	"github.com/mainflux/mainflux/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func pemKey(t *testing.T, key crypto.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	assert.Nil(t, err, fmt.Sprintf("unexpected error marshaling key: %s", err))
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func signed(t *testing.T, sign func([]byte) []byte, kid, nonce string, ts time.Time, name, cmd string) string {
+	e := agent.Envelope{
+		Command:   cmd,
+		Timestamp: ts.Unix(),
+		Nonce:     nonce,
+		KeyID:     kid,
+	}
+	e.Signature = base64.StdEncoding.EncodeToString(sign(agent.SignedMessage("1", "rid", name, e)))
+	b, err := json.Marshal(e)
+	assert.Nil(t, err, fmt.Sprintf("unexpected error marshaling envelope: %s", err))
+	return string(b)
+}
+
+func TestVerify(t *testing.T) {
+	edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err, fmt.Sprintf("unexpected error generating key: %s", err))
+	ecPriv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err, fmt.Sprintf("unexpected error generating key: %s", err))
+
+	v, err := agent.NewVerifier(agent.SecurityConfig{
+		Enabled: true,
+		MaxAge:  time.Minute,
+		Keys: []agent.SignatureKey{
+			{ID: "ed", Key: pemKey(t, edPub)},
+			{ID: "ec", Key: pemKey(t, &ecPriv.PublicKey)},
+		},
+	})
+	assert.Nil(t, err, fmt.Sprintf("unexpected error creating verifier: %s", err))
+
+	ed := func(msg []byte) []byte {
+		return ed25519.Sign(edPriv, msg)
+	}
+	ec := func(msg []byte) []byte {
+		d := sha256.Sum256(msg)
+		sig, err := ecdsa.SignASN1(rand.Reader, ecPriv, d[:])
+		assert.Nil(t, err, fmt.Sprintf("unexpected error signing: %s", err))
+		return sig
+	}
+	now := time.Now()
+	replayed := signed(t, ed, "ed", "n2", now, "exec", "ls,-l")
+
+	cases := []struct {
+		desc     string
+		name     string
+		cmd      string
+		required bool
+		res      string
+		err      error
+	}{
+		{"ed25519 signed command", "exec", signed(t, ed, "ed", "n1", now, "exec", "ls,-l"), true, "ls,-l", nil},
+		{"ecdsa signed command", "exec", signed(t, ec, "ec", "n3", now, "exec", "uptime,-p"), true, "uptime,-p", nil},
+		{"signed command without key id", "exec", signed(t, ed, "", "n4", now, "exec", "ls,-l"), true, "ls,-l", nil},
+		{"first use of nonce", "exec", replayed, true, "ls,-l", nil},
+		{"repeated nonce", "exec", replayed, true, "", agent.ErrUnauthorized},
+		{"stale timestamp", "exec", signed(t, ed, "ed", "n5", now.Add(-2*time.Minute), "exec", "ls,-l"), true, "", agent.ErrUnauthorized},
+		{"future timestamp", "exec", signed(t, ed, "ed", "n6", now.Add(2*time.Minute), "exec", "ls,-l"), true, "", agent.ErrUnauthorized},
+		{"signature of other command", "control", signed(t, ed, "ed", "n7", now, "exec", "ls,-l"), true, "", agent.ErrUnauthorized},
+		{"wrong key id", "exec", signed(t, ed, "ec", "n8", now, "exec", "ls,-l"), true, "", agent.ErrUnauthorized},
+		{"unsigned command", "exec", "ls,-l", true, "", agent.ErrUnauthorized},
+		{"unsigned command not requiring signature", "job-list", "", false, "", nil},
+	}
+
+	for _, tc := range cases {
+		res, err := v.Verify("1", "rid", tc.name, tc.cmd, tc.required)
+		assert.True(t, errors.Contains(err, tc.err), fmt.Sprintf("%s: expected %s got %s", tc.desc, tc.err, err))
+		assert.Equal(t, tc.res, res, fmt.Sprintf("%s: unexpected command", tc.desc))
+	}
+}
+
+func TestReplayCacheFull(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err, fmt.Sprintf("unexpected error generating key: %s", err))
+	v, err := agent.NewVerifier(agent.SecurityConfig{
+		Enabled:     true,
+		MaxAge:      time.Minute,
+		ReplayCache: 2,
+		Keys:        []agent.SignatureKey{{ID: "ed", Key: pemKey(t, pub)}},
+	})
+	assert.Nil(t, err, fmt.Sprintf("unexpected error creating verifier: %s", err))
+	ed := func(msg []byte) []byte {
+		return ed25519.Sign(priv, msg)
+	}
+
+	now := time.Now()
+	first := signed(t, ed, "ed", "n1", now, "exec", "ls,-l")
+	cases := []struct {
+		desc string
+		cmd  string
+		err  error
+	}{
+		{"first nonce", first, nil},
+		{"second nonce", signed(t, ed, "ed", "n2", now, "exec", "ls,-l"), nil},
+		{"nonce over the cache size", signed(t, ed, "ed", "n3", now, "exec", "ls,-l"), agent.ErrUnauthorized},
+		{"replayed first nonce", first, agent.ErrUnauthorized},
+	}
+
+	for _, tc := range cases {
+		_, err := v.Verify("1", "rid", "exec", tc.cmd, true)
+		assert.True(t, errors.Contains(err, tc.err), fmt.Sprintf("%s: expected %s got %s", tc.desc, tc.err, err))
+	}
+}
+
+func TestNewVerifierInvalid(t *testing.T) {
+	cases := []struct {
+		desc string
+		cfg  agent.SecurityConfig
+	}{
+		{"enabled without keys", agent.SecurityConfig{Enabled: true}},
+		{"key not PEM encoded", agent.SecurityConfig{Keys: []agent.SignatureKey{{ID: "k", Key: "key"}}}},
+		{"missing key file", agent.SecurityConfig{Keys: []agent.SignatureKey{{ID: "k", File: "/nonexistent/key.pem"}}}},
+	}
+
+	for _, tc := range cases {
+		_, err := agent.NewVerifier(tc.cfg)
+		assert.NotNil(t, err, fmt.Sprintf("%s: expected error", tc.desc))
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mainflux/mainflux/pkg/errors"
+)
+
+var errServiceStore = errors.New("service store failure")
+
+// ServiceStore persists tracked service instances across agent restarts.
+type ServiceStore interface {
+	// Save replaces stored instances.
+	Save([]Info) error
+
+	// Load returns stored instances, none if nothing is stored yet.
+	Load() ([]Info, error)
+}
+
+var _ ServiceStore = (*serviceStore)(nil)
+
+type serviceStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewServiceStore returns store keeping service instances in JSON file.
+func NewServiceStore(path string) ServiceStore {
+	return &serviceStore{path: path}
+}
+
+func (s *serviceStore) Save(infos []Info) error {
+	b, err := json.Marshal(infos)
+	if err != nil {
+		return errors.Wrap(errServiceStore, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return errors.Wrap(errServiceStore, err)
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return errors.Wrap(errServiceStore, err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		os.Remove(tmp)
+		return errors.Wrap(errServiceStore, err)
+	}
+	return nil
+}
+
+func (s *serviceStore) Load() ([]Info, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return []Info{}, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(errServiceStore, err)
+	}
+	infos := []Info{}
+	if err := json.Unmarshal(b, &infos); err != nil {
+		return nil, errors.Wrap(errServiceStore, err)
+	}
+	return infos, nil
+}
+
+// persister saves tracked services whenever an instance is registered,
+// changes status or is removed, and passes changes to the listener.
+// Services are also saved periodically, so that the saved last seen
+// times are kept up to date, and on shutdown.
+type persister struct {
+	Listener
+	mu       sync.Mutex
+	store    ServiceStore
+	services func() []Info
+	onError  func(error)
+}
+
+func (p *persister) Changed(info Info) {
+	p.save()
+	p.Listener.Changed(info)
+}
+
+func (p *persister) Removed(info Info) {
+	p.save()
+	p.Listener.Removed(info)
+}
+
+// run saves services every interval until the context is done,
+// when they are saved for the last time.
+func (p *persister) run(ctx context.Context, interval time.Duration) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		tick = t.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			p.save()
+			return
+		case <-tick:
+			p.save()
+		}
+	}
+}
+
+// save takes the snapshot under the lock, so that concurrent
+// saves can't overwrite newer state with the older one.
+func (p *persister) save() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.store.Save(p.services()); err != nil {
+		p.onError(err)
+	}
+}
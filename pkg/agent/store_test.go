@@ -0,0 +1,84 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package agent_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mainflux/agent/pkg/agent"
+	"github.com/mainflux/agent/pkg/agent/mocks"
+	"github.com/mainflux/mainflux/logger"
+	"github.com/mainflux/mainflux/pkg/messaging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceStore(t *testing.T) {
+	dir, err := os.MkdirTemp("", "services")
+	assert.Nil(t, err, fmt.Sprintf("unexpected error: %v", err))
+	defer os.RemoveAll(dir)
+
+	store := agent.NewServiceStore(filepath.Join(dir, "state", "services.json"))
+	infos, err := store.Load()
+	assert.Nil(t, err, fmt.Sprintf("unexpected error loading missing file: %v", err))
+	assert.Empty(t, infos, "expected no services")
+
+	saved := []agent.Info{{
+		Name:     "adc",
+		Instance: "1",
+		Host:     "gateway",
+		Status:   "degraded",
+		Type:     "test",
+		LastSeen: time.Now().Round(0).UTC(),
+		Health:   []agent.Check{{Name: "db", Status: "fail"}},
+		Metadata: map[string]string{"site": "north"},
+	}}
+	err = store.Save(saved)
+	assert.Nil(t, err, fmt.Sprintf("unexpected error saving services: %v", err))
+	infos, err = store.Load()
+	assert.Nil(t, err, fmt.Sprintf("unexpected error loading services: %v", err))
+	assert.Equal(t, saved, infos, "unexpected loaded services")
+}
+
+func TestServicePersistence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "services.json")
+	ctx, cancel := context.WithCancel(context.Background())
+	cfg := agent.Config{}
+	cfg.Heartbeat.Interval = 100 * time.Millisecond
+	cfg.Heartbeat.File = file
+	ps := mocks.NewPubSub()
+	_, err := agent.New(ctx, mocks.NewMQTTClient(), &cfg, mocks.NewEdgexClient(), ps, logger.NewMock())
+	if !assert.Nil(t, err, fmt.Sprintf("unexpected error creating agent: %s", err)) {
+		cancel()
+		return
+	}
+
+	begin := time.Now()
+	for time.Since(begin) < 300*time.Millisecond {
+		err := ps.Publish(ctx, agent.Hearbeat, &messaging.Message{Channel: "heartbeat.adc.test"})
+		assert.Nil(t, err, fmt.Sprintf("unexpected error publishing heartbeat: %s", err))
+		time.Sleep(20 * time.Millisecond)
+	}
+	infos, err := agent.NewServiceStore(file).Load()
+	assert.Nil(t, err, fmt.Sprintf("unexpected error loading services: %s", err))
+	if assert.Len(t, infos, 1, "expected saved service") {
+		assert.Equal(t, "online", infos[0].Status, "unexpected saved status")
+		assert.True(t, infos[0].LastSeen.After(begin.Add(100*time.Millisecond)), "expected last seen time saved periodically")
+	}
+
+	last := time.Now()
+	err = ps.Publish(ctx, agent.Hearbeat, &messaging.Message{Channel: "heartbeat.adc.test"})
+	assert.Nil(t, err, fmt.Sprintf("unexpected error publishing heartbeat: %s", err))
+	cancel()
+	saved := false
+	for end := time.Now().Add(time.Second); !saved && time.Now().Before(end); time.Sleep(10 * time.Millisecond) {
+		infos, err := agent.NewServiceStore(file).Load()
+		saved = err == nil && len(infos) == 1 && !infos[0].LastSeen.Before(last)
+	}
+	assert.True(t, saved, "expected last seen time saved on shutdown")
+}
@@ -0,0 +1,121 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mainflux/agent/pkg/encoder"
+	"github.com/mainflux/mainflux/pkg/errors"
+	"github.com/mainflux/senml"
+)
+
+// heartbeatBaseName is base name of the agent heartbeat records.
+const heartbeatBaseName = "heartbeat/"
+
+var errVitalsUnsupported = errors.New("host vitals are not supported")
+
+// Vitals holds host state reported by the agent heartbeat. Memory
+// and disk usage of the root file system are given in bytes.
+type Vitals struct {
+	Load1        float64
+	Load5        float64
+	Load15       float64
+	MemTotal     uint64
+	MemAvailable uint64
+	DiskTotal    uint64
+	DiskFree     uint64
+	Uptime       time.Duration
+}
+
+// ConnectionChecker is implemented by message brokers that report
+// connection state, which is then included in the agent heartbeat.
+type ConnectionChecker interface {
+	IsConnected() bool
+}
+
+// selfHeartbeat publishes the agent heartbeat every interval
+// while MQTT connection is open.
+func (a *agent) selfHeartbeat(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if !a.mqttClient.IsConnectionOpen() {
+				continue
+			}
+			if err := a.publishHeartbeat(); err != nil {
+				a.logger.Warn(fmt.Sprintf("Failed to publish agent heartbeat: %s", err))
+			}
+		}
+	}
+}
+
+func (a *agent) publishHeartbeat() error {
+	payload, err := a.channelCodec(control).Encode(heartbeatBaseName, a.heartbeatRecords())
+	if err != nil {
+		return errors.Wrap(errFailedEncode, err)
+	}
+	if err := a.Publish(control, string(payload)); err != nil {
+		return errors.Wrap(errFailedToPublish, err)
+	}
+	return nil
+}
+
+// heartbeatRecords returns host vitals, agent uptime, connection state,
+// number of open terminal sessions and states of the tracked services.
+func (a *agent) heartbeatRecords() []senml.Record {
+	records := []senml.Record{
+		encoder.Number("uptime", time.Since(a.started).Seconds(), "s"),
+	}
+
+	v, err := hostVitals()
+	switch err {
+	case nil:
+		records = append(records,
+			encoder.Number("load_1", v.Load1, ""),
+			encoder.Number("load_5", v.Load5, ""),
+			encoder.Number("load_15", v.Load15, ""),
+			encoder.Number("mem_total", float64(v.MemTotal), "B"),
+			encoder.Number("mem_available", float64(v.MemAvailable), "B"),
+			encoder.Number("disk_total", float64(v.DiskTotal), "B"),
+			encoder.Number("disk_free", float64(v.DiskFree), "B"),
+			encoder.Number("host_uptime", v.Uptime.Seconds(), "s"),
+		)
+	case errVitalsUnsupported:
+	default:
+		a.logger.Warn(fmt.Sprintf("Failed to read host vitals: %s", err))
+	}
+
+	records = append(records, encoder.Bool("mqtt_connected", a.mqttClient.IsConnectionOpen()))
+	if c, ok := a.broker.(ConnectionChecker); ok {
+		records = append(records, encoder.Bool("nats_connected", c.IsConnected()))
+	}
+
+	a.termMu.Lock()
+	terms := len(a.terminals)
+	a.termMu.Unlock()
+	records = append(records, encoder.Number("terminals", float64(terms), ""))
+
+	services := a.Services()
+	states := map[string]int{}
+	for _, s := range services {
+		states[s.Status]++
+	}
+	records = append(records,
+		encoder.Number("services", float64(len(services)), ""),
+		encoder.Number("services_online", float64(states[online]), ""),
+		encoder.Number("services_degraded", float64(states[degraded]), ""),
+		encoder.Number("services_offline", float64(states[offline]), ""),
+	)
+	for _, s := range services {
+		records = append(records, encoder.String(fmt.Sprintf("services/%s", s.Name), s.Status))
+	}
+	return records
+}
@@ -0,0 +1,88 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+// +build linux
+
+package agent
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	procDir     = "/proc"
+	loadavgFile = "loadavg"
+	meminfoFile = "meminfo"
+	uptimeFile  = "uptime"
+	rootDir     = "/"
+)
+
+// hostVitals reads vitals of the host the agent runs on.
+func hostVitals() (Vitals, error) {
+	return ReadVitals(procDir, rootDir)
+}
+
+// ReadVitals reads load average, memory and uptime from the proc
+// file system mounted at proc, and disk usage of the file system
+// mounted at root.
+func ReadVitals(proc, root string) (Vitals, error) {
+	v := Vitals{}
+
+	b, err := os.ReadFile(filepath.Join(proc, loadavgFile))
+	if err != nil {
+		return v, err
+	}
+	if _, err := fmt.Sscan(string(b), &v.Load1, &v.Load5, &v.Load15); err != nil {
+		return v, err
+	}
+
+	f, err := os.Open(filepath.Join(proc, meminfoFile))
+	if err != nil {
+		return v, err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "MemTotal:":
+			v.MemTotal = kb * 1024
+		case "MemAvailable:":
+			v.MemAvailable = kb * 1024
+		}
+	}
+
+	b, err = os.ReadFile(filepath.Join(proc, uptimeFile))
+	if err != nil {
+		return v, err
+	}
+	var up float64
+	if _, err := fmt.Sscan(string(b), &up); err != nil {
+		return v, err
+	}
+	v.Uptime = time.Duration(up * float64(time.Second))
+
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(root, &st); err != nil {
+		return v, err
+	}
+	v.DiskTotal = st.Blocks * uint64(st.Bsize)
+	v.DiskFree = st.Bavail * uint64(st.Bsize)
+
+	return v, nil
+}
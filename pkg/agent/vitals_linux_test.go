@@ -0,0 +1,66 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+// +build linux
+
+package agent_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mainflux/agent/pkg/agent"
+	"github.com/stretchr/testify/assert"
+)
+
+const procFixture = "testdata/proc"
+
+func TestReadVitals(t *testing.T) {
+	v, err := agent.ReadVitals(procFixture, t.TempDir())
+	assert.Nil(t, err, fmt.Sprintf("unexpected error reading vitals: %s", err))
+	assert.Equal(t, 0.52, v.Load1, "unexpected 1 minute load")
+	assert.Equal(t, 0.58, v.Load5, "unexpected 5 minute load")
+	assert.Equal(t, 0.59, v.Load15, "unexpected 15 minute load")
+	assert.Equal(t, uint64(8048064*1024), v.MemTotal, "unexpected total memory")
+	assert.Equal(t, uint64(4231824*1024), v.MemAvailable, "unexpected available memory")
+	assert.Equal(t, time.Duration(350735.47*float64(time.Second)), v.Uptime, "unexpected uptime")
+	assert.NotZero(t, v.DiskTotal, "expected disk size")
+}
+
+func TestReadVitalsInvalid(t *testing.T) {
+	cases := []struct {
+		desc  string
+		files map[string]string
+	}{
+		{
+			desc:  "missing files",
+			files: map[string]string{},
+		},
+		{
+			desc:  "malformed load average",
+			files: map[string]string{"loadavg": "load", "meminfo": "MemTotal: 1 kB\n", "uptime": "1.5 2.5\n"},
+		},
+		{
+			desc:  "missing uptime",
+			files: map[string]string{"loadavg": "0.1 0.2 0.3 1/1 1\n", "meminfo": "MemTotal: 1 kB\n"},
+		},
+		{
+			desc:  "malformed uptime",
+			files: map[string]string{"loadavg": "0.1 0.2 0.3 1/1 1\n", "meminfo": "MemTotal: 1 kB\n", "uptime": "up\n"},
+		},
+	}
+
+	for _, tc := range cases {
+		dir := t.TempDir()
+		for name, content := range tc.files {
+			err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600)
+			assert.Nil(t, err, fmt.Sprintf("%s: unexpected error writing fixture: %s", tc.desc, err))
+		}
+		_, err := agent.ReadVitals(dir, dir)
+		assert.NotNil(t, err, fmt.Sprintf("%s: expected error", tc.desc))
+	}
+}
@@ -0,0 +1,12 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux
+// +build !linux
+
+package agent
+
+// hostVitals is not supported, host vitals are reported only on Linux.
+func hostVitals() (Vitals, error) {
+	return Vitals{}, errVitalsUnsupported
+}
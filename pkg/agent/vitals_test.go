@@ -0,0 +1,59 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package agent_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mainflux/agent/pkg/agent"
+	"github.com/mainflux/agent/pkg/agent/mocks"
+	"github.com/mainflux/mainflux/logger"
+	"github.com/mainflux/mainflux/pkg/messaging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeartbeatRecords(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg := agent.Config{}
+	cfg.Heartbeat.Interval = 20 * time.Millisecond
+	mc := mocks.NewMQTTClient()
+	ps := mocks.NewPubSub()
+	_, err := agent.New(ctx, mc, &cfg, mocks.NewEdgexClient(), ps, logger.NewMock())
+	if !assert.Nil(t, err, fmt.Sprintf("unexpected error creating agent: %s", err)) {
+		return
+	}
+
+	err = ps.Publish(ctx, agent.Hearbeat, &messaging.Message{Channel: "heartbeat.adc.test"})
+	assert.Nil(t, err, fmt.Sprintf("unexpected error publishing service heartbeat: %s", err))
+
+	var hb map[string]string
+	for end := time.Now().Add(5 * time.Second); hb == nil && time.Now().Before(end); time.Sleep(10 * time.Millisecond) {
+		for _, r := range records(mc, "") {
+			if _, ok := r["uptime"]; ok && r["services"] == "1" {
+				hb = r
+			}
+		}
+	}
+	if !assert.NotNil(t, hb, "expected agent heartbeat") {
+		return
+	}
+	// Service is marked offline once the heartbeat interval passes.
+	status := hb["services/adc"]
+	assert.Contains(t, []string{"online", "offline"}, status, "unexpected service status")
+	cases := map[string]string{
+		"mqtt_connected":     "true",
+		"nats_connected":     "true",
+		"terminals":          "0",
+		"services":           "1",
+		"services_degraded":  "0",
+		"services_" + status: "1",
+	}
+	for name, value := range cases {
+		assert.Equal(t, value, hb[name], fmt.Sprintf("unexpected %s record", name))
+	}
+}
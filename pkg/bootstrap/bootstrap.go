@@ -106,6 +106,8 @@ func Bootstrap(cfg Config, logger log.Logger, file string) error {
 	cc := agent.ChanConfig{
 		Control: ctrlChan,
 		Data:    dataChan,
+		Format:  dc.SvcsConf.Agent.Channels.Format,
+		Formats: dc.SvcsConf.Agent.Channels.Formats,
 	}
 	ec := dc.SvcsConf.Agent.Edgex
 	lc := dc.SvcsConf.Agent.Log
@@ -119,7 +121,11 @@ func Bootstrap(cfg Config, logger log.Logger, file string) error {
 
 	hc := dc.SvcsConf.Agent.Heartbeat
 	tc := dc.SvcsConf.Agent.Terminal
-	c := agent.NewConfig(sc, cc, ec, lc, mc, hc, tc, file)
+	xc := dc.SvcsConf.Agent.Exec
+	sec := dc.SvcsConf.Agent.Security
+	ac := dc.SvcsConf.Agent.Audit
+	qc := dc.SvcsConf.Agent.Queue
+	c := agent.NewConfig(sc, cc, ec, lc, mc, hc, tc, xc, sec, ac, qc, file)
 
 	dc.SvcsConf.Export = fillExportConfig(dc.SvcsConf.Export, c)
 
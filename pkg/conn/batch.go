@@ -0,0 +1,145 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package conn
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/mainflux/agent/pkg/agent"
+	"github.com/mainflux/agent/pkg/encoder"
+	"github.com/mainflux/senml"
+)
+
+const (
+	batch    = "batch"
+	parallel = "parallel"
+	format   = "format"
+	errName  = "error"
+)
+
+// command is a single command record of the received pack.
+type command struct {
+	index int
+	name  string
+	uuid  string
+	rid   string
+	value string
+	err   error
+}
+
+// directives are options of the pack handling, given in records
+// that are not commands.
+type directives struct {
+	// parallel requests parallel execution of the commands.
+	parallel bool
+	// format is payload format of the response.
+	format string
+}
+
+// parseCommands returns commands from pack records, resolving base
+// names as SenML does. Optional batch record with the value parallel
+// requests parallel execution of the commands, and optional format
+// record sets payload format of the response.
+func parseCommands(records []senml.Record) ([]command, directives) {
+	cmds := []command{}
+	d := directives{}
+	bn := ""
+	for i, r := range records {
+		if r.BaseName != "" {
+			bn = r.BaseName
+		}
+		value, err := recordValue(r)
+		switch r.Name {
+		case batch:
+			d.parallel = err == nil && value == parallel
+			continue
+		case format:
+			d.format = value
+			continue
+		}
+		uuid, rid := agent.ParseBaseName(bn)
+		cmds = append(cmds, command{
+			index: i,
+			name:  r.Name,
+			uuid:  uuid,
+			rid:   rid,
+			value: value,
+			err:   err,
+		})
+	}
+	return cmds, d
+}
+
+// handleBatch handles each command and publishes response pack in
+// which names of the response records are prefixed by the index of
+// the command record, i.e. 0/exec, 1/error.
+func (b *broker) handleBatch(ctx context.Context, cmds []command, par bool) {
+	res := make([][]senml.Record, len(cmds))
+	handle := func(i int) {
+		res[i] = b.collect(ctx, cmds[i])
+	}
+
+	if par {
+		var wg sync.WaitGroup
+		for i := range cmds {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				handle(i)
+			}(i)
+		}
+		wg.Wait()
+	} else {
+		for i := range cmds {
+			handle(i)
+		}
+	}
+
+	records := []senml.Record{}
+	for i, rs := range res {
+		for _, r := range rs {
+			r.Name = fmt.Sprintf("%d/%s", cmds[i].index, r.Name)
+			records = append(records, r)
+		}
+	}
+	b.publish(ctx, records)
+}
+
+// collect handles the command and returns its responses. Handler error
+// is returned as the error record, unless the agent already responded
+// with it, so that every error is answered exactly once.
+func (b *broker) collect(ctx context.Context, cmd command) []senml.Record {
+	c := &agent.Collector{}
+	if err := b.handleCmd(agent.WithCollector(ctx, c), cmd); err != nil && len(c.Records()) == 0 {
+		c.Add(errorRecord(cmd, err))
+	}
+	return c.Records()
+}
+
+func (b *broker) publishError(ctx context.Context, cmd command, err error) {
+	b.publish(ctx, []senml.Record{errorRecord(cmd, err)})
+}
+
+// publish publishes response pack in the format of the request.
+func (b *broker) publish(ctx context.Context, records []senml.Record) {
+	enc, ok := agent.ResponseEncoder(ctx)
+	if !ok {
+		enc, _ = encoder.New(encoder.SenMLJSON)
+	}
+	payload, err := enc.Encode("", records)
+	if err != nil {
+		b.logger.Warn(fmt.Sprintf("Failed to encode response: %s", err))
+		return
+	}
+	if err := b.svc.Respond(ctx, string(payload)); err != nil {
+		b.logger.Warn(fmt.Sprintf("Failed to publish response: %s", err))
+	}
+}
+
+func errorRecord(cmd command, err error) senml.Record {
+	res := agent.ErrorResponse(err)
+	return senml.Record{BaseName: agent.BaseName(cmd.uuid, cmd.rid), Name: errName, StringValue: &res}
+}
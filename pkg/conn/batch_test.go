@@ -0,0 +1,261 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package conn_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/mainflux/agent/pkg/agent"
+	"github.com/mainflux/agent/pkg/agent/mocks"
+	"github.com/mainflux/agent/pkg/conn"
+	"github.com/mainflux/mainflux/logger"
+	"github.com/mainflux/mainflux/pkg/errors"
+	"github.com/mainflux/mainflux/pkg/messaging"
+	"github.com/mainflux/senml"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	ctrl   = "ctrl"
+	probe  = "probe"
+	reqTop = "channels/ctrl/messages/req"
+	resTop = "channels/ctrl/messages/res"
+)
+
+type request struct {
+	mqtt.Message
+	topic   string
+	payload []byte
+}
+
+func (r request) Topic() string {
+	return r.topic
+}
+
+func (r request) Payload() []byte {
+	return r.payload
+}
+
+// probes counts concurrently running probe commands.
+type probes struct {
+	mu      sync.Mutex
+	running int
+	max     int
+}
+
+// handle fails with the command string once other probes had a chance
+// to start, so that the maximum number of concurrent probes is 1 only
+// when they are executed sequentially.
+func (p *probes) handle(ctx context.Context, uuid, cmdStr string) error {
+	p.mu.Lock()
+	p.running++
+	if p.running > p.max {
+		p.max = p.running
+	}
+	p.mu.Unlock()
+
+	for deadline := time.Now().Add(100 * time.Millisecond); time.Now().Before(deadline); {
+		p.mu.Lock()
+		n := p.running
+		p.mu.Unlock()
+		if n > 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	p.mu.Lock()
+	p.running--
+	p.mu.Unlock()
+	return errors.New(cmdStr)
+}
+
+// newBroker returns client connected through the session of the broker
+// subscribed to the control channel, and services if ps is not nil.
+func newBroker(t *testing.T, ps messaging.PubSub) (*client, *mocks.MQTTClient, *probes) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	cfg := agent.Config{}
+	cfg.Channels.Control = ctrl
+	cfg.Heartbeat.Interval = time.Minute
+	mc := mocks.NewMQTTClient()
+	svc, err := agent.New(ctx, mc, &cfg, mocks.NewEdgexClient(), mocks.NewPubSub(), logger.NewMock())
+	if !assert.Nil(t, err, fmt.Sprintf("unexpected error creating agent: %s", err)) {
+		t.FailNow()
+	}
+	p := &probes{}
+	err = svc.Register(agent.HandlerInfo{Name: probe}, p.handle)
+	assert.Nil(t, err, fmt.Sprintf("unexpected error registering handler: %s", err))
+
+	s := conn.NewSession(logger.NewMock())
+	b := conn.NewBroker(svc, mc, s, ctrl, ps, logger.NewMock())
+	err = b.Subscribe(ctx)
+	assert.Nil(t, err, fmt.Sprintf("unexpected error subscribing: %s", err))
+	c := &client{}
+	s.OnConnect(c)
+	return c, mc, p
+}
+
+// responses returns records of the response packs as base name,
+// name and string value triples.
+func responses(t *testing.T, mc *mocks.MQTTClient) [][3]string {
+	ret := [][3]string{}
+	for _, m := range mc.Published() {
+		payload, ok := m.Payload.(string)
+		if !ok || m.Topic != resTop {
+			continue
+		}
+		pack, err := senml.Decode([]byte(payload), senml.JSON)
+		if !assert.Nil(t, err, fmt.Sprintf("unexpected error decoding response: %s", err)) {
+			continue
+		}
+		for _, r := range pack.Records {
+			v := ""
+			if r.StringValue != nil {
+				v = *r.StringValue
+			}
+			ret = append(ret, [3]string{r.BaseName, r.Name, v})
+		}
+	}
+	return ret
+}
+
+func TestBatch(t *testing.T) {
+	cases := []struct {
+		desc      string
+		pack      string
+		responses [][3]string
+		names     []string
+		parallel  bool
+	}{
+		{
+			desc: "sequential batch",
+			pack: `[{"bn":"1:","n":"probe","vs":"a"},{"n":"probe","vs":"b"}]`,
+			responses: [][3]string{
+				{"1", "0/error", `{"error":"a"}`},
+				{"1", "1/error", `{"error":"b"}`},
+			},
+		},
+		{
+			desc: "parallel batch",
+			pack: `[{"n":"batch","vs":"parallel"},{"bn":"1:","n":"probe","vs":"a"},{"n":"probe","vs":"b"}]`,
+			responses: [][3]string{
+				{"1", "1/error", `{"error":"a"}`},
+				{"1", "2/error", `{"error":"b"}`},
+			},
+			parallel: true,
+		},
+		{
+			desc: "batch with request id",
+			pack: `[{"bn":"1/3f9c2a:","n":"probe","vs":"a"},{"bn":"2:","n":"probe","vs":"b"}]`,
+			responses: [][3]string{
+				{"1/3f9c2a", "0/error", `{"error":"a"}`},
+				{"2", "1/error", `{"error":"b"}`},
+			},
+		},
+		{
+			desc: "batch without string value",
+			pack: `[{"bn":"1/3f9c2a:","n":"probe","v":1},{"n":"probe","vs":"b"}]`,
+			responses: [][3]string{
+				{"1/3f9c2a", "0/error", `{"error":"missing string or data value"}`},
+				{"1/3f9c2a", "1/error", `{"error":"b"}`},
+			},
+		},
+		{
+			desc:  "batch with responding command",
+			pack:  `[{"bn":"1:","n":"capabilities","vs":""},{"n":"probe","vs":"b"}]`,
+			names: []string{"0/capabilities", "1/error"},
+		},
+	}
+
+	for _, tc := range cases {
+		c, mc, p := newBroker(t, nil)
+		c.handler(reqTop)(nil, request{topic: reqTop, payload: []byte(tc.pack)})
+
+		res := responses(t, mc)
+		if tc.responses != nil {
+			assert.Equal(t, tc.responses, res, fmt.Sprintf("%s: unexpected responses", tc.desc))
+		}
+		if tc.names != nil {
+			names := []string{}
+			for _, r := range res {
+				names = append(names, r[1])
+			}
+			assert.Equal(t, tc.names, names, fmt.Sprintf("%s: unexpected response names", tc.desc))
+		}
+		assert.Equal(t, tc.parallel, p.max > 1, fmt.Sprintf("%s: expected parallel %t, got %d concurrent commands", tc.desc, tc.parallel, p.max))
+	}
+}
+
+func TestSingleCommand(t *testing.T) {
+	cases := []struct {
+		desc      string
+		pack      string
+		responses [][3]string
+		names     []string
+	}{
+		{
+			desc:      "failed command",
+			pack:      `[{"bn":"1:","n":"probe","vs":"a"}]`,
+			responses: [][3]string{{"1", "error", `{"error":"a"}`}},
+		},
+		{
+			desc:      "failed command with request ID",
+			pack:      `[{"bn":"1/3f9c2a:","n":"probe","vs":"a"}]`,
+			responses: [][3]string{{"1/3f9c2a", "error", `{"error":"a"}`}},
+		},
+		{
+			desc:      "failed builtin command",
+			pack:      `[{"bn":"1:","n":"job-cancel","vs":"unknown"}]`,
+			responses: [][3]string{{"1", "error", `{"error":"job not found"}`}},
+		},
+		{
+			desc:      "invalid builtin command",
+			pack:      `[{"bn":"1:","n":"service","vs":"restart"}]`,
+			responses: [][3]string{{"1", "error", `{"error":"invalid command"}`}},
+		},
+		{
+			desc:  "rejected exec answered once",
+			pack:  `[{"bn":"1:","n":"exec","vs":"{\"argv\":[]}"}]`,
+			names: []string{"error"},
+		},
+		{
+			desc:      "command without string value",
+			pack:      `[{"bn":"1:","n":"probe","v":1}]`,
+			responses: [][3]string{{"1", "error", `{"error":"missing string or data value"}`}},
+		},
+		{
+			desc:  "unknown command",
+			pack:  `[{"bn":"1:","n":"unknown","vs":"a"}]`,
+			names: []string{"error"},
+		},
+		{
+			desc:  "responding command",
+			pack:  `[{"bn":"1:","n":"capabilities","vs":""}]`,
+			names: []string{"capabilities"},
+		},
+	}
+
+	for _, tc := range cases {
+		c, mc, _ := newBroker(t, nil)
+		c.handler(reqTop)(nil, request{topic: reqTop, payload: []byte(tc.pack)})
+
+		res := responses(t, mc)
+		if tc.responses != nil {
+			assert.Equal(t, tc.responses, res, fmt.Sprintf("%s: unexpected responses", tc.desc))
+		}
+		if tc.names != nil {
+			names := []string{}
+			for _, r := range res {
+				names = append(names, r[1])
+			}
+			assert.Equal(t, tc.names, names, fmt.Sprintf("%s: unexpected response names", tc.desc))
+		}
+	}
+}
@@ -10,7 +10,9 @@ import (
 	"strings"
 
 	"github.com/mainflux/agent/pkg/agent"
+	"github.com/mainflux/agent/pkg/encoder"
 	"github.com/mainflux/mainflux/logger"
+	"github.com/mainflux/mainflux/pkg/errors"
 	"github.com/mainflux/mainflux/pkg/messaging"
 	"github.com/mainflux/senml"
 	"robpike.io/filter"
@@ -22,14 +24,17 @@ const (
 	reqTopic  = "req"
 	servTopic = "services"
 	commands  = "commands"
+	responses = "responses"
 
-	control = "control"
-	exec    = "exec"
-	config  = "config"
-	service = "service"
-	term    = "term"
+	// Responses is the subject of service replies. Services publish them with
+	// responses.<service_name>.<subtopic> channel, which is prefixed by the broker.
+	Responses = "channels.responses.>"
+
+	pubSubID = "agent-responses"
 )
 
+var errMissingValue = errors.New("missing string or data value")
+
 var channelPartRegExp = regexp.MustCompile(`^channels/([\w\-]+)/messages/services(/[^?]*)?(\?.*)?$`)
 
 var _ MqttBroker = (*broker)(nil)
@@ -43,18 +48,21 @@ type MqttBroker interface {
 type broker struct {
 	svc           agent.Service
 	client        mqtt.Client
+	session       Session
 	logger        logger.Logger
 	messageBroker messaging.PubSub
 	channel       string
 	ctx           context.Context
 }
 
-// NewBroker returns new MQTT broker instance.
-func NewBroker(svc agent.Service, client mqtt.Client, chann string, messBroker messaging.PubSub, log logger.Logger) MqttBroker {
+// NewBroker returns new MQTT broker instance. Subscriptions are made
+// through the session, which renews them when the client reconnects.
+func NewBroker(svc agent.Service, client mqtt.Client, session Session, chann string, messBroker messaging.PubSub, log logger.Logger) MqttBroker {
 
 	return &broker{
 		svc:           svc,
 		client:        client,
+		session:       session,
 		logger:        log,
 		messageBroker: messBroker,
 		channel:       chann,
@@ -64,16 +72,18 @@ func NewBroker(svc agent.Service, client mqtt.Client, chann string, messBroker m
 
 // Subscribe subscribes to the MQTT message broker.
 func (b *broker) Subscribe(ctx context.Context) error {
+	qos := b.svc.Config().MQTT.QoS
 	topic := fmt.Sprintf("channels/%s/messages/%s", b.channel, reqTopic)
 	b.ctx = ctx
-	s := b.client.Subscribe(topic, 0, b.handleMsg)
-	if err := s.Error(); s.Wait() && err != nil {
+	if err := b.session.Subscribe(topic, qos, b.handleMsg); err != nil {
 		return err
 	}
 	topic = fmt.Sprintf("channels/%s/messages/%s/#", b.channel, servTopic)
 	if b.messageBroker != nil {
-		n := b.client.Subscribe(topic, 0, b.handleNatsMsg)
-		if err := n.Error(); n.Wait() && err != nil {
+		if err := b.session.Subscribe(topic, qos, b.handleNatsMsg); err != nil {
+			return err
+		}
+		if err := b.messageBroker.Subscribe(ctx, pubSubID, Responses, handleFunc(b.handleNatsReply)); err != nil {
 			return err
 		}
 	}
@@ -107,48 +117,122 @@ func extractNatsTopic(topic string) string {
 	return fmt.Sprintf("%s.%s", commands, natsTopic)
 }
 
+// handleNatsReply triggered when service reply is received on the Broker.
+// Reply is published on channels/<control>/messages/res/services/<service_name>/<subtopic>.
+func (b *broker) handleNatsReply(msg *messaging.Message) error {
+	topic := extractMQTTTopic(msg.Channel, msg.Subtopic)
+	if topic == "" {
+		return fmt.Errorf("invalid reply channel %s", msg.Channel)
+	}
+	if err := b.svc.Publish(topic, string(msg.Payload)); err != nil {
+		b.logger.Warn(fmt.Sprintf("error publishing service reply with error: %v", err))
+		return err
+	}
+	return nil
+}
+
+// extractMQTTTopic returns control channel subtopic for the reply
+// published with responses.<service_name>.<subtopic> channel.
+func extractMQTTTopic(channel, subtopic string) string {
+	isEmpty := func(s string) bool {
+		return (len(s) == 0)
+	}
+	parts := strings.Split(fmt.Sprintf("%s.%s", channel, subtopic), ".")
+	filtered := filter.Drop(parts, isEmpty).([]string)
+	if len(filtered) < 2 || filtered[0] != responses {
+		return ""
+	}
+
+	return fmt.Sprintf("%s/%s", servTopic, strings.Join(filtered[1:], "/"))
+}
+
+type handleFunc func(msg *messaging.Message) error
+
+func (h handleFunc) Handle(msg *messaging.Message) error {
+	return h(msg)
+}
+
+func (h handleFunc) Cancel() error {
+	return nil
+}
+
 // handleMsg triggered when new message is received on MQTT broker.
+// Pack with a single command is answered with its responses, while
+// packs with multiple commands are handled as a batch with aggregated
+// response. Failed commands are answered with the error in both cases.
+// Pack is either SenML JSON or SenML CBOR, and responses are encoded
+// in the same format unless the pack sets the response format.
+// Responses to MQTT v5 commands carry their correlation data and
+// user properties and are published to their response topic.
 func (b *broker) handleMsg(mc mqtt.Client, msg mqtt.Message) {
-	sm, err := senml.Decode(msg.Payload(), senml.JSON)
+	codec, err := encoder.Detect(msg.Payload())
+	if err != nil {
+		b.logger.Warn(fmt.Sprintf("SenML format detection failed: %s", err))
+		return
+	}
+	records, err := codec.Decode(msg.Payload())
 	if err != nil {
 		b.logger.Warn(fmt.Sprintf("SenML decode failed: %s", err))
 		return
 	}
 
-	if len(sm.Records) == 0 {
+	if len(records) == 0 {
 		b.logger.Error(fmt.Sprintf("SenML payload empty: `%s`", string(msg.Payload())))
 		return
 	}
-	cmdType := sm.Records[0].Name
-	cmdStr := *sm.Records[0].StringValue
-	uuid := strings.TrimSuffix(sm.Records[0].BaseName, ":")
 
-	switch cmdType {
-	case control:
-		b.logger.Info(fmt.Sprintf("Control command for uuid %s and command string %s", uuid, cmdStr))
-		if err := b.svc.Control(uuid, cmdStr); err != nil {
-			b.logger.Warn(fmt.Sprintf("Control operation failed: %s", err))
-		}
-	case exec:
-		b.logger.Info(fmt.Sprintf("Execute command for uuid %s and command string %s", uuid, cmdStr))
-		if _, err := b.svc.Execute(uuid, cmdStr); err != nil {
-			b.logger.Warn(fmt.Sprintf("Execute operation failed: %s", err))
-		}
-	case config:
-		b.logger.Info(fmt.Sprintf("Config service for uuid %s and command string %s", uuid, cmdStr))
-		if err := b.svc.ServiceConfig(b.ctx, uuid, cmdStr); err != nil {
-			b.logger.Warn(fmt.Sprintf("Execute operation failed: %s", err))
-		}
-	case service:
-		b.logger.Info(fmt.Sprintf("Services view for uuid %s and command string %s", uuid, cmdStr))
-		if err := b.svc.ServiceConfig(b.ctx, uuid, cmdStr); err != nil {
-			b.logger.Warn(fmt.Sprintf("Services view operation failed: %s", err))
+	ctx := agent.WithEncoder(b.ctx, codec)
+	if r, ok := msg.(replier); ok {
+		ctx = agent.WithReply(ctx, r.Reply())
+	}
+	cmds, d := parseCommands(records)
+	if d.format != "" {
+		enc, err := encoder.New(d.format)
+		if err != nil {
+			b.logger.Warn(fmt.Sprintf("Invalid response format %s: %s", d.format, err))
+			for _, cmd := range cmds {
+				b.publishError(ctx, cmd, err)
+			}
+			return
 		}
-	case term:
-		b.logger.Info(fmt.Sprintf("Services view for uuid %s and command string %s", uuid, cmdStr))
-		if err := b.svc.Terminal(uuid, cmdStr); err != nil {
-			b.logger.Warn(fmt.Sprintf("Services view operation failed: %s", err))
+		ctx = agent.WithEncoder(ctx, enc)
+	}
+	switch len(cmds) {
+	case 0:
+		b.logger.Error(fmt.Sprintf("SenML payload without commands: `%s`", string(msg.Payload())))
+	case 1:
+		if records := b.collect(ctx, cmds[0]); len(records) > 0 {
+			b.publish(ctx, records)
 		}
+	default:
+		b.handleBatch(ctx, cmds, d.parallel)
+	}
+}
+
+// handleCmd dispatches command to the handler registered in the agent.
+func (b *broker) handleCmd(ctx context.Context, cmd command) error {
+	if cmd.err != nil {
+		b.logger.Warn(fmt.Sprintf("Invalid command %s for uuid %s: %s", cmd.name, cmd.uuid, cmd.err))
+		return cmd.err
 	}
+	ctx = agent.WithRequestID(ctx, cmd.rid)
+	b.logger.Info(fmt.Sprintf("Command %s for uuid %s and command string %s", cmd.name, cmd.uuid, cmd.value))
+	if err := b.svc.Handle(ctx, cmd.name, cmd.uuid, cmd.value); err != nil {
+		b.logger.Warn(fmt.Sprintf("Command %s failed: %s", cmd.name, err))
+		return err
+	}
+	return nil
+}
 
+// recordValue returns command string from record string value
+// or, for structured commands, from record data value.
+func recordValue(r senml.Record) (string, error) {
+	switch {
+	case r.StringValue != nil:
+		return *r.StringValue, nil
+	case r.DataValue != nil:
+		return encoder.DecodeData(*r.DataValue)
+	default:
+		return "", errMissingValue
+	}
 }
@@ -0,0 +1,89 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package conn_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/mainflux/agent/pkg/agent/mocks"
+	"github.com/mainflux/agent/pkg/conn"
+	"github.com/mainflux/mainflux/pkg/messaging"
+	"github.com/stretchr/testify/assert"
+)
+
+const servTop = "channels/ctrl/messages/services/#"
+
+func TestServiceReply(t *testing.T) {
+	cases := []struct {
+		desc     string
+		channel  string
+		subtopic string
+		topic    string
+	}{
+		{desc: "reply of service", channel: "responses.kv", topic: "channels/ctrl/messages/res/services/kv"},
+		{desc: "reply with subtopic", channel: "responses.kv", subtopic: "get.key", topic: "channels/ctrl/messages/res/services/kv/get/key"},
+		{desc: "reply with service in subtopic", channel: "responses", subtopic: "kv.get", topic: "channels/ctrl/messages/res/services/kv/get"},
+		{desc: "reply with empty parts", channel: "responses..kv.", subtopic: ".get", topic: "channels/ctrl/messages/res/services/kv/get"},
+		{desc: "reply without service", channel: "responses"},
+		{desc: "reply with empty channel", channel: ""},
+		{desc: "reply on other channel", channel: "commands.kv", subtopic: "get"},
+		{desc: "reply with responses in subtopic", channel: "kv", subtopic: "responses"},
+	}
+
+	for _, tc := range cases {
+		ps := mocks.NewPubSub()
+		_, mc, _ := newBroker(t, ps)
+		msg := messaging.Message{Channel: tc.channel, Subtopic: tc.subtopic, Payload: []byte(tc.desc)}
+		err := ps.Publish(context.Background(), conn.Responses, &msg)
+
+		published := mc.Published()
+		if tc.topic == "" {
+			assert.NotNil(t, err, fmt.Sprintf("%s: expected error for malformed subject", tc.desc))
+			assert.Empty(t, published, fmt.Sprintf("%s: expected no reply to be published", tc.desc))
+			continue
+		}
+		assert.Nil(t, err, fmt.Sprintf("%s: unexpected error: %s", tc.desc, err))
+		if assert.Len(t, published, 1, fmt.Sprintf("%s: expected reply to be published", tc.desc)) {
+			assert.Equal(t, tc.topic, published[0].Topic, fmt.Sprintf("%s: unexpected reply topic", tc.desc))
+			assert.Equal(t, tc.desc, published[0].Payload, fmt.Sprintf("%s: unexpected reply payload", tc.desc))
+		}
+	}
+}
+
+func TestServiceCommand(t *testing.T) {
+	cases := []struct {
+		desc    string
+		topic   string
+		subject string
+	}{
+		{desc: "command to service", topic: "channels/ctrl/messages/services/kv", subject: "commands.kv"},
+		{desc: "command with subtopic", topic: "channels/ctrl/messages/services/kv/get/key", subject: "commands.kv.get.key"},
+		{desc: "command with empty parts", topic: "channels/ctrl/messages/services//kv/", subject: "commands.kv"},
+	}
+
+	for _, tc := range cases {
+		ps := mocks.NewPubSub()
+		c, _, _ := newBroker(t, ps)
+		var received []string
+		err := ps.Subscribe(context.Background(), "test", tc.subject, handleFunc(func(msg *messaging.Message) error {
+			received = append(received, string(msg.Payload))
+			return nil
+		}))
+		assert.Nil(t, err, fmt.Sprintf("%s: unexpected error subscribing: %s", tc.desc, err))
+		c.handler(servTop)(nil, request{topic: tc.topic, payload: []byte(tc.desc)})
+		assert.Equal(t, []string{tc.desc}, received, fmt.Sprintf("%s: expected command on %s", tc.desc, tc.subject))
+	}
+}
+
+type handleFunc func(msg *messaging.Message) error
+
+func (h handleFunc) Handle(msg *messaging.Message) error {
+	return h(msg)
+}
+
+func (h handleFunc) Cancel() error {
+	return nil
+}
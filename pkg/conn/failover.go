@@ -0,0 +1,323 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package conn
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/mainflux/agent/pkg/agent"
+	"github.com/mainflux/mainflux/logger"
+	"github.com/mainflux/mainflux/pkg/errors"
+)
+
+const (
+	defCheckInterval    = 5 * time.Second
+	defFailbackInterval = time.Minute
+	connectTimeout      = 30 * time.Second
+	disconnectQuiesce   = 250
+)
+
+var (
+	errNotConnected   = errors.New("not connected to any broker")
+	errConnectTimeout = errors.New("connect timed out")
+	errNoBrokers      = errors.New("no MQTT brokers configured")
+)
+
+// Endpoint is a broker of the failover list with the client options used
+// to connect to it. Connection lost handler of the options is set by the
+// failover client, while connect handler is called after the session
+// subscriptions are renewed. Protocol 5 selects the MQTT v5 client.
+type Endpoint struct {
+	URL      string
+	Protocol uint
+	Options  *mqtt.ClientOptions
+}
+
+// Failover is MQTT client connected to the broker of the highest priority
+// that is available. When connection to the active broker is lost, client
+// connects to the next broker in the order of priority, and it returns to
+// the broker of higher priority as soon as that one is available again.
+// Subscriptions of the session follow the active connection.
+type Failover interface {
+	mqtt.Client
+
+	// Health returns state of connection and subscriptions,
+	// along with the active broker.
+	Health() Health
+}
+
+var _ Failover = (*failover)(nil)
+
+type failover struct {
+	mu           sync.Mutex
+	endpoints    []Endpoint
+	clients      []mqtt.Client
+	active       int
+	failovers    uint64
+	lastFailback time.Time
+	check        time.Duration
+	failback     time.Duration
+	session      Session
+	logger       logger.Logger
+	done         chan struct{}
+	once         sync.Once
+}
+
+// NewFailover returns failover client for the endpoints given in the order of
+// priority. Connection is made and monitored once Connect is called.
+func NewFailover(endpoints []Endpoint, cfg agent.FailoverConfig, session Session, log logger.Logger) (Failover, error) {
+	if len(endpoints) == 0 {
+		return nil, errNoBrokers
+	}
+	f := &failover{
+		endpoints: endpoints,
+		active:    -1,
+		check:     cfg.CheckInterval,
+		failback:  cfg.FailbackInterval,
+		session:   session,
+		logger:    log,
+		done:      make(chan struct{}),
+	}
+	if f.check <= 0 {
+		f.check = defCheckInterval
+	}
+	if f.failback <= 0 {
+		f.failback = defFailbackInterval
+	}
+	for i, e := range endpoints {
+		i := i
+		onConnect := e.Options.OnConnect
+		opts := e.Options.
+			SetAutoReconnect(false).
+			SetConnectRetry(false).
+			SetOnConnectHandler(func(c mqtt.Client) {
+				f.logger.Info(fmt.Sprintf("Connected to MQTT broker %s", f.endpoints[i].URL))
+				session.OnConnect(c)
+				if onConnect != nil {
+					onConnect(c)
+				}
+			}).
+			SetConnectionLostHandler(func(c mqtt.Client, err error) {
+				if f.activeIndex() != i {
+					return
+				}
+				f.logger.Warn(fmt.Sprintf("Connection to MQTT broker %s lost", f.endpoints[i].URL))
+				session.OnConnectionLost(c, err)
+			})
+		f.clients = append(f.clients, newClient(e.Protocol, opts))
+	}
+	return f, nil
+}
+
+func (f *failover) IsConnected() bool {
+	c := f.client()
+	return c != nil && c.IsConnected()
+}
+
+func (f *failover) IsConnectionOpen() bool {
+	c := f.client()
+	return c != nil && c.IsConnectionOpen()
+}
+
+// Connect connects to the first available broker in the order of
+// priority and starts monitoring the connection.
+func (f *failover) Connect() mqtt.Token {
+	err := f.connect(0, len(f.endpoints))
+	f.once.Do(func() {
+		go f.monitor()
+	})
+	if err != nil {
+		return errToken{err: err}
+	}
+	return &mqtt.DummyToken{}
+}
+
+func (f *failover) Disconnect(quiesce uint) {
+	select {
+	case <-f.done:
+	default:
+		close(f.done)
+	}
+	if c := f.client(); c != nil {
+		c.Disconnect(quiesce)
+	}
+}
+
+func (f *failover) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	c := f.client()
+	if c == nil {
+		return errToken{err: errNotConnected}
+	}
+	return c.Publish(topic, qos, retained, payload)
+}
+
+func (f *failover) Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+	c := f.client()
+	if c == nil {
+		return errToken{err: errNotConnected}
+	}
+	return c.Subscribe(topic, qos, callback)
+}
+
+func (f *failover) SubscribeMultiple(filters map[string]byte, callback mqtt.MessageHandler) mqtt.Token {
+	c := f.client()
+	if c == nil {
+		return errToken{err: errNotConnected}
+	}
+	return c.SubscribeMultiple(filters, callback)
+}
+
+func (f *failover) Unsubscribe(topics ...string) mqtt.Token {
+	c := f.client()
+	if c == nil {
+		return errToken{err: errNotConnected}
+	}
+	return c.Unsubscribe(topics...)
+}
+
+func (f *failover) AddRoute(topic string, callback mqtt.MessageHandler) {
+	for _, c := range f.clients {
+		c.AddRoute(topic, callback)
+	}
+}
+
+func (f *failover) OptionsReader() mqtt.ClientOptionsReader {
+	if c := f.client(); c != nil {
+		return c.OptionsReader()
+	}
+	return f.clients[0].OptionsReader()
+}
+
+func (f *failover) Health() Health {
+	h := f.session.Health()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	h.Priority = f.active
+	h.Failovers = f.failovers
+	if f.active >= 0 {
+		h.Broker = f.endpoints[f.active].URL
+	}
+	return h
+}
+
+// monitor reconnects when the active connection is lost and, while
+// connected to a fallback broker, tries brokers of higher priority.
+func (f *failover) monitor() {
+	t := time.NewTicker(f.check)
+	defer t.Stop()
+	for {
+		select {
+		case <-f.done:
+			return
+		case <-t.C:
+			f.checkConnection()
+		}
+	}
+}
+
+func (f *failover) checkConnection() {
+	active := f.activeIndex()
+	if active < 0 || !f.clients[active].IsConnectionOpen() {
+		if err := f.connect(0, len(f.endpoints)); err != nil {
+			f.logger.Warn(fmt.Sprintf("Failed to connect to MQTT broker: %s", err))
+		}
+		return
+	}
+	if active == 0 {
+		return
+	}
+	f.mu.Lock()
+	due := time.Since(f.lastFailback) >= f.failback
+	if due {
+		f.lastFailback = time.Now()
+	}
+	f.mu.Unlock()
+	if due {
+		f.connect(0, active)
+	}
+}
+
+// connect connects to the first available broker from the given
+// range of the list and makes it the active one.
+func (f *failover) connect(from, to int) error {
+	var err error = errNotConnected
+	for i := from; i < to; i++ {
+		if err = f.dial(i); err != nil {
+			f.logger.Warn(fmt.Sprintf("Failed to connect to MQTT broker %s: %s", f.endpoints[i].URL, err))
+			continue
+		}
+		f.activate(i)
+		return nil
+	}
+	return err
+}
+
+func (f *failover) dial(i int) error {
+	c := f.clients[i]
+	if c.IsConnectionOpen() {
+		return nil
+	}
+	token := c.Connect()
+	if !token.WaitTimeout(connectTimeout) {
+		return errConnectTimeout
+	}
+	return token.Error()
+}
+
+func (f *failover) activate(i int) {
+	f.mu.Lock()
+	prev := f.active
+	f.active = i
+	if prev >= 0 && prev != i {
+		f.failovers++
+	}
+	f.mu.Unlock()
+
+	if prev < 0 || prev == i {
+		return
+	}
+	f.logger.Info(fmt.Sprintf("Switched from MQTT broker %s to %s", f.endpoints[prev].URL, f.endpoints[i].URL))
+	if c := f.clients[prev]; c.IsConnectionOpen() {
+		c.Disconnect(disconnectQuiesce)
+	}
+}
+
+func (f *failover) activeIndex() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.active
+}
+
+func (f *failover) client() mqtt.Client {
+	if i := f.activeIndex(); i >= 0 {
+		return f.clients[i]
+	}
+	return nil
+}
+
+// errToken is completed token of the failed operation.
+type errToken struct {
+	err error
+}
+
+func (t errToken) Wait() bool {
+	return true
+}
+
+func (t errToken) WaitTimeout(time.Duration) bool {
+	return true
+}
+
+func (t errToken) Done() <-chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}
+
+func (t errToken) Error() error {
+	return t.err
+}
@@ -0,0 +1,232 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package conn_test
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/mainflux/agent/pkg/agent"
+	"github.com/mainflux/agent/pkg/conn"
+	"github.com/mainflux/mainflux/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+// unavailable returns URL of the broker refusing connections.
+func unavailable(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err, fmt.Sprintf("unexpected error: %v", err))
+	addr := l.Addr().String()
+	l.Close()
+	return fmt.Sprintf("tcp://%s", addr)
+}
+
+func TestFailoverUnavailable(t *testing.T) {
+	endpoints := []conn.Endpoint{}
+	for i := 0; i < 2; i++ {
+		url := unavailable(t)
+		opts := mqtt.NewClientOptions().AddBroker(url).SetConnectTimeout(time.Second)
+		endpoints = append(endpoints, conn.Endpoint{URL: url, Options: opts})
+	}
+
+	_, err := conn.NewFailover(nil, agent.FailoverConfig{}, conn.NewSession(logger.NewMock()), logger.NewMock())
+	assert.NotNil(t, err, "expected error for empty brokers list")
+
+	f, err := conn.NewFailover(endpoints, agent.FailoverConfig{}, conn.NewSession(logger.NewMock()), logger.NewMock())
+	assert.Nil(t, err, fmt.Sprintf("unexpected error: %v", err))
+	defer f.Disconnect(0)
+
+	token := f.Connect()
+	assert.True(t, token.WaitTimeout(5*time.Second), "expected connect to complete")
+	assert.NotNil(t, token.Error(), "expected error connecting to unavailable brokers")
+	assert.False(t, f.IsConnectionOpen(), "expected closed connection")
+
+	h := f.Health()
+	assert.False(t, h.Healthy(), "expected unhealthy client")
+	assert.Equal(t, -1, h.Priority, "expected no active broker")
+	assert.Equal(t, "", h.Broker, "expected no active broker")
+
+	token = f.Publish("res", 0, false, "payload")
+	assert.NotNil(t, token.Error(), "expected error publishing without connection")
+}
+
+// testBroker is minimal MQTT 3.1.1 broker, which accepts connections
+// and subscriptions and passes the subscribed topics to the channel.
+type testBroker struct {
+	mu    sync.Mutex
+	addr  string
+	l     net.Listener
+	conns []net.Conn
+	subs  chan string
+}
+
+func newTestBroker(t *testing.T) *testBroker {
+	b := &testBroker{addr: "127.0.0.1:0", subs: make(chan string, 10)}
+	b.start(t)
+	t.Cleanup(b.stop)
+	return b
+}
+
+func (b *testBroker) url() string {
+	return fmt.Sprintf("tcp://%s", b.addr)
+}
+
+// start listens on the address of the broker, so that
+// the restarted broker is available under the same URL.
+func (b *testBroker) start(t *testing.T) {
+	l, err := net.Listen("tcp", b.addr)
+	if !assert.Nil(t, err, fmt.Sprintf("unexpected error listening: %v", err)) {
+		t.FailNow()
+	}
+	b.mu.Lock()
+	b.l = l
+	b.addr = l.Addr().String()
+	b.mu.Unlock()
+	go b.serve(l)
+}
+
+// stop closes the listener and all the connections.
+func (b *testBroker) stop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.l.Close()
+	for _, c := range b.conns {
+		c.Close()
+	}
+	b.conns = nil
+}
+
+func (b *testBroker) serve(l net.Listener) {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		b.mu.Lock()
+		b.conns = append(b.conns, c)
+		b.mu.Unlock()
+		go b.handle(c)
+	}
+}
+
+func (b *testBroker) handle(c net.Conn) {
+	defer c.Close()
+	for {
+		cp, err := packets.ReadPacket(c)
+		if err != nil {
+			return
+		}
+		switch p := cp.(type) {
+		case *packets.ConnectPacket:
+			packets.NewControlPacket(packets.Connack).Write(c)
+		case *packets.SubscribePacket:
+			sa := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+			sa.MessageID = p.MessageID
+			sa.ReturnCodes = p.Qoss
+			sa.Write(c)
+			for _, topic := range p.Topics {
+				b.subs <- topic
+			}
+		case *packets.PingreqPacket:
+			packets.NewControlPacket(packets.Pingresp).Write(c)
+		case *packets.DisconnectPacket:
+			return
+		}
+	}
+}
+
+// subscribed waits for the subscription to the topic.
+func (b *testBroker) subscribed(t *testing.T, topic, desc string) {
+	select {
+	case s := <-b.subs:
+		assert.Equal(t, topic, s, fmt.Sprintf("%s: unexpected subscription", desc))
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, fmt.Sprintf("%s: expected subscription to %s", desc, topic))
+	}
+}
+
+// metric returns value of the agent MQTT gauge or counter.
+func metric(t *testing.T, r *prometheus.Registry, name string) float64 {
+	mfs, err := r.Gather()
+	assert.Nil(t, err, fmt.Sprintf("unexpected error gathering metrics: %v", err))
+	for _, mf := range mfs {
+		if mf.GetName() != "agent_mqtt_"+name || len(mf.GetMetric()) == 0 {
+			continue
+		}
+		m := mf.GetMetric()[0]
+		if g := m.GetGauge(); g != nil {
+			return g.GetValue()
+		}
+		return m.GetCounter().GetValue()
+	}
+	assert.Fail(t, fmt.Sprintf("expected metric %s", name))
+	return 0
+}
+
+func TestFailover(t *testing.T) {
+	primary := newTestBroker(t)
+	secondary := newTestBroker(t)
+	endpoints := []conn.Endpoint{}
+	for i, b := range []*testBroker{primary, secondary} {
+		opts := mqtt.NewClientOptions().AddBroker(b.url()).SetClientID(fmt.Sprintf("agent-%d", i)).SetConnectTimeout(time.Second)
+		endpoints = append(endpoints, conn.Endpoint{URL: b.url(), Options: opts})
+	}
+
+	s := conn.NewSession(logger.NewMock())
+	err := s.Subscribe("req", 0, func(mqtt.Client, mqtt.Message) {})
+	assert.Nil(t, err, fmt.Sprintf("unexpected error subscribing: %v", err))
+	cfg := agent.FailoverConfig{CheckInterval: 20 * time.Millisecond, FailbackInterval: 50 * time.Millisecond}
+	f, err := conn.NewFailover(endpoints, cfg, s, logger.NewMock())
+	if !assert.Nil(t, err, fmt.Sprintf("unexpected error creating client: %v", err)) {
+		return
+	}
+	r := prometheus.NewRegistry()
+	r.MustRegister(conn.Collectors(f)...)
+
+	token := f.Connect()
+	assert.True(t, token.WaitTimeout(5*time.Second), "expected connect to complete")
+	if !assert.Nil(t, token.Error(), fmt.Sprintf("unexpected error connecting: %v", token.Error())) {
+		return
+	}
+	defer f.Disconnect(0)
+	primary.subscribed(t, "req", "connect")
+
+	cases := []struct {
+		desc      string
+		change    func()
+		broker    *testBroker
+		priority  int
+		failovers uint64
+	}{
+		{desc: "connect to primary", change: func() {}, broker: primary, priority: 0, failovers: 0},
+		{desc: "failover to secondary", change: primary.stop, broker: secondary, priority: 1, failovers: 1},
+		{desc: "failback to primary", change: func() { primary.start(t) }, broker: primary, priority: 0, failovers: 2},
+	}
+
+	for _, tc := range cases {
+		tc.change()
+		var h conn.Health
+		for end := time.Now().Add(5 * time.Second); time.Now().Before(end); time.Sleep(10 * time.Millisecond) {
+			if h = f.Health(); h.Priority == tc.priority && h.Healthy() {
+				break
+			}
+		}
+		if tc.failovers > 0 {
+			// Subscriptions of the session are renewed on the new connection.
+			tc.broker.subscribed(t, "req", tc.desc)
+		}
+		assert.True(t, h.Healthy(), fmt.Sprintf("%s: expected healthy client", tc.desc))
+		assert.Equal(t, tc.priority, h.Priority, fmt.Sprintf("%s: unexpected broker priority", tc.desc))
+		assert.Equal(t, tc.broker.url(), h.Broker, fmt.Sprintf("%s: unexpected broker", tc.desc))
+		assert.Equal(t, tc.failovers, h.Failovers, fmt.Sprintf("%s: unexpected failovers", tc.desc))
+		assert.Equal(t, float64(tc.priority), metric(t, r, "broker_priority"), fmt.Sprintf("%s: unexpected broker_priority metric", tc.desc))
+		assert.Equal(t, float64(tc.failovers), metric(t, r, "failovers_total"), fmt.Sprintf("%s: unexpected failovers_total metric", tc.desc))
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package conn
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collectors returns Prometheus collectors of MQTT connection, active
+// broker and subscriptions state of the client.
+func Collectors(f Failover) []prometheus.Collector {
+	gauge := func(name, help string, value func(Health) float64) prometheus.Collector {
+		return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: "agent",
+			Subsystem: "mqtt",
+			Name:      name,
+			Help:      help,
+		}, func() float64 {
+			return value(f.Health())
+		})
+	}
+	counter := func(name, help string, value func(Health) float64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: "agent",
+			Subsystem: "mqtt",
+			Name:      name,
+			Help:      help,
+		}, func() float64 {
+			return value(f.Health())
+		})
+	}
+
+	return []prometheus.Collector{
+		gauge("connected", "Whether MQTT client is connected.", func(h Health) float64 {
+			if h.Connected {
+				return 1
+			}
+			return 0
+		}),
+		counter("connects_total", "Number of MQTT client connects, including reconnects.", func(h Health) float64 { return float64(h.Connects) }),
+		gauge("subscriptions", "Number of MQTT subscriptions.", func(h Health) float64 { return float64(len(h.Subscriptions)) }),
+		gauge("broker_priority", "Position of the active broker in the brokers list, -1 if not connected.", func(h Health) float64 { return float64(h.Priority) }),
+		counter("failovers_total", "Number of switches between MQTT brokers.", func(h Health) float64 { return float64(h.Failovers) }),
+		gauge("subscriptions_active", "Number of MQTT subscriptions acknowledged by the broker.", func(h Health) float64 {
+			n := 0
+			for _, s := range h.Subscriptions {
+				if s.Subscribed {
+					n++
+				}
+			}
+			return float64(n)
+		}),
+	}
+}
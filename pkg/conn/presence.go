@@ -0,0 +1,114 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package conn
+
+import (
+	"fmt"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/google/uuid"
+	"github.com/mainflux/agent/pkg/encoder"
+	"github.com/mainflux/mainflux"
+	"github.com/mainflux/mainflux/logger"
+	"github.com/mainflux/mainflux/pkg/errors"
+	"github.com/mainflux/senml"
+)
+
+const (
+	online  = "online"
+	offline = "offline"
+
+	statusTopic = "status"
+	statusName  = "status"
+	versionName = "version"
+	uptimeName  = "uptime"
+	bootIDName  = "boot_id"
+
+	// presenceQoS makes sure that status reaches the broker.
+	presenceQoS     = 1
+	presenceTimeout = 5 * time.Second
+)
+
+var errPublishTimeout = errors.New("publish timed out")
+
+// Presence announces agent availability with retained messages on the
+// channels/<control_channel_id>/messages/res/status topic. Each message
+// carries status, agent version, uptime and boot ID, which changes on
+// every agent start.
+type Presence interface {
+	// Will sets the offline message as the last will of the client,
+	// published by the broker when the connection is lost.
+	Will(opts *mqtt.ClientOptions) error
+
+	// Online publishes the online message. It is the client connect handler.
+	Online(c mqtt.Client)
+
+	// Offline publishes the offline message before graceful shutdown.
+	Offline(c mqtt.Client) error
+}
+
+var _ Presence = (*presence)(nil)
+
+type presence struct {
+	topic   string
+	bootID  string
+	started time.Time
+	encoder encoder.Encoder
+	logger  logger.Logger
+}
+
+// NewPresence returns presence of the agent using the control channel.
+func NewPresence(channel string, enc encoder.Encoder, log logger.Logger) Presence {
+	return &presence{
+		topic:   fmt.Sprintf("channels/%s/messages/res/%s", channel, statusTopic),
+		bootID:  uuid.NewString(),
+		started: time.Now(),
+		encoder: enc,
+		logger:  log,
+	}
+}
+
+// Will sets the last will with the uptime at the time of the call,
+// since the will is sent by the broker.
+func (p *presence) Will(opts *mqtt.ClientOptions) error {
+	payload, err := p.payload(offline)
+	if err != nil {
+		return err
+	}
+	opts.SetBinaryWill(p.topic, payload, presenceQoS, true)
+	return nil
+}
+
+func (p *presence) Online(c mqtt.Client) {
+	if err := p.publish(c, online); err != nil {
+		p.logger.Warn(fmt.Sprintf("Failed to publish online status: %s", err))
+	}
+}
+
+func (p *presence) Offline(c mqtt.Client) error {
+	return p.publish(c, offline)
+}
+
+func (p *presence) publish(c mqtt.Client, status string) error {
+	payload, err := p.payload(status)
+	if err != nil {
+		return err
+	}
+	token := c.Publish(p.topic, presenceQoS, true, payload)
+	if !token.WaitTimeout(presenceTimeout) {
+		return errPublishTimeout
+	}
+	return token.Error()
+}
+
+func (p *presence) payload(status string) ([]byte, error) {
+	records := []senml.Record{
+		encoder.String(statusName, status),
+		encoder.String(versionName, mainflux.Version),
+		encoder.Number(uptimeName, time.Since(p.started).Seconds(), "s"),
+		encoder.String(bootIDName, p.bootID),
+	}
+	return p.encoder.Encode("", records)
+}
@@ -0,0 +1,75 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package conn_test
+
+import (
+	"fmt"
+	"testing"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/mainflux/agent/pkg/conn"
+	"github.com/mainflux/agent/pkg/encoder"
+	"github.com/mainflux/mainflux/logger"
+	"github.com/mainflux/senml"
+	"github.com/stretchr/testify/assert"
+)
+
+type message struct {
+	topic    string
+	retained bool
+	payload  []byte
+}
+
+type pubClient struct {
+	mqtt.Client
+	messages []message
+}
+
+func (c *pubClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	c.messages = append(c.messages, message{topic: topic, retained: retained, payload: payload.([]byte)})
+	return &mqtt.DummyToken{}
+}
+
+func status(t *testing.T, payload []byte) map[string]senml.Record {
+	p, err := senml.Decode(payload, senml.JSON)
+	assert.Nil(t, err, fmt.Sprintf("unexpected error decoding status: %v", err))
+	ret := map[string]senml.Record{}
+	for _, r := range p.Records {
+		ret[r.Name] = r
+	}
+	return ret
+}
+
+func TestPresence(t *testing.T) {
+	enc, _ := encoder.New(encoder.SenMLJSON)
+	p := conn.NewPresence("ctrl", enc, logger.NewMock())
+	topic := "channels/ctrl/messages/res/status"
+
+	opts := mqtt.NewClientOptions()
+	err := p.Will(opts)
+	assert.Nil(t, err, fmt.Sprintf("unexpected error setting will: %v", err))
+	assert.True(t, opts.WillEnabled, "expected last will")
+	assert.True(t, opts.WillRetained, "expected retained last will")
+	assert.Equal(t, topic, opts.WillTopic, "unexpected last will topic")
+	will := status(t, opts.WillPayload)
+	assert.Equal(t, "offline", *will["status"].StringValue, "unexpected last will status")
+
+	c := &pubClient{}
+	p.Online(c)
+	err = p.Offline(c)
+	assert.Nil(t, err, fmt.Sprintf("unexpected error publishing offline status: %v", err))
+	if !assert.Len(t, c.messages, 2, "expected online and offline messages") {
+		return
+	}
+	for i, s := range []string{"online", "offline"} {
+		m := c.messages[i]
+		assert.Equal(t, topic, m.topic, "unexpected status topic")
+		assert.True(t, m.retained, "expected retained status")
+		recs := status(t, m.payload)
+		assert.Equal(t, s, *recs["status"].StringValue, "unexpected status")
+		assert.Equal(t, *will["boot_id"].StringValue, *recs["boot_id"].StringValue, "expected the same boot ID")
+		assert.NotNil(t, recs["uptime"].Value, "expected uptime")
+		assert.NotNil(t, recs["version"].StringValue, "expected version")
+	}
+}
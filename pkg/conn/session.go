@@ -0,0 +1,185 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package conn
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/mainflux/mainflux/logger"
+	"github.com/mainflux/mainflux/pkg/errors"
+)
+
+// subscribeTimeout is max time to wait for the broker to acknowledge subscription.
+const subscribeTimeout = 30 * time.Second
+
+var errSubscribeTimeout = errors.New("subscribe timed out")
+
+// SubscriptionState holds state of a single subscription.
+type SubscriptionState struct {
+	Topic      string    `json:"topic"`
+	Subscribed bool      `json:"subscribed"`
+	Error      string    `json:"error,omitempty"`
+	Updated    time.Time `json:"updated"`
+}
+
+// Health holds state of MQTT connection and subscriptions. Broker is
+// URL of the active broker, and Priority its position in the brokers
+// list, or -1 if client is not connected to any.
+type Health struct {
+	Connected     bool                `json:"connected"`
+	Connects      uint64              `json:"connects"`
+	Broker        string              `json:"broker,omitempty"`
+	Priority      int                 `json:"priority"`
+	Failovers     uint64              `json:"failovers"`
+	Subscriptions []SubscriptionState `json:"subscriptions"`
+}
+
+// Healthy returns true if client is connected and subscribed to every topic.
+func (h Health) Healthy() bool {
+	if !h.Connected {
+		return false
+	}
+	for _, s := range h.Subscriptions {
+		if !s.Subscribed {
+			return false
+		}
+	}
+	return true
+}
+
+// Session owns MQTT subscriptions and re-establishes them every time
+// the client connects, since clean sessions lose them on reconnect.
+type Session interface {
+	// Subscribe subscribes to the topic, now if the client is connected
+	// and again on every reconnect.
+	Subscribe(topic string, qos byte, h mqtt.MessageHandler) error
+
+	// OnConnect is the MQTT client connect handler.
+	OnConnect(mqtt.Client)
+
+	// OnConnectionLost is the MQTT client connection lost handler.
+	OnConnectionLost(mqtt.Client, error)
+
+	// Health returns state of connection and subscriptions.
+	Health() Health
+}
+
+var _ Session = (*session)(nil)
+
+type subscription struct {
+	qos     byte
+	handler mqtt.MessageHandler
+	state   SubscriptionState
+}
+
+type session struct {
+	mu        sync.Mutex
+	logger    logger.Logger
+	client    mqtt.Client
+	connected bool
+	connects  uint64
+	subs      map[string]*subscription
+}
+
+// NewSession returns session that should be set as connect and connection
+// lost handler of the MQTT client before it connects.
+func NewSession(log logger.Logger) Session {
+	return &session{
+		logger: log,
+		subs:   make(map[string]*subscription),
+	}
+}
+
+func (s *session) Subscribe(topic string, qos byte, h mqtt.MessageHandler) error {
+	s.mu.Lock()
+	s.subs[topic] = &subscription{
+		qos:     qos,
+		handler: h,
+		state:   SubscriptionState{Topic: topic, Updated: time.Now()},
+	}
+	c, connected := s.client, s.connected
+	s.mu.Unlock()
+
+	// Subscriptions made before the connect handler
+	// is called are made by the handler.
+	if c == nil || !connected {
+		return nil
+	}
+	return s.subscribe(c, topic, qos, h)
+}
+
+func (s *session) OnConnect(c mqtt.Client) {
+	s.mu.Lock()
+	s.client = c
+	s.connected = true
+	s.connects++
+	subs := map[string]subscription{}
+	for topic, sub := range s.subs {
+		subs[topic] = *sub
+	}
+	s.mu.Unlock()
+
+	s.logger.Info(fmt.Sprintf("MQTT client connected, subscribing to %d topics", len(subs)))
+	for topic, sub := range subs {
+		if err := s.subscribe(c, topic, sub.qos, sub.handler); err != nil {
+			s.logger.Error(fmt.Sprintf("Failed to resubscribe to %s: %s", topic, err))
+		}
+	}
+}
+
+func (s *session) OnConnectionLost(_ mqtt.Client, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connected = false
+	now := time.Now()
+	for _, sub := range s.subs {
+		sub.state.Subscribed = false
+		sub.state.Updated = now
+	}
+	s.logger.Warn(fmt.Sprintf("MQTT connection lost: %s", err))
+}
+
+func (s *session) subscribe(c mqtt.Client, topic string, qos byte, h mqtt.MessageHandler) error {
+	token := c.Subscribe(topic, qos, h)
+	var err error
+	switch {
+	case !token.WaitTimeout(subscribeTimeout):
+		err = errSubscribeTimeout
+	case token.Error() != nil:
+		err = token.Error()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sub, ok := s.subs[topic]; ok {
+		sub.state.Subscribed = err == nil
+		sub.state.Error = ""
+		if err != nil {
+			sub.state.Error = err.Error()
+		}
+		sub.state.Updated = time.Now()
+	}
+	return err
+}
+
+func (s *session) Health() Health {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := Health{
+		Connected:     s.connected,
+		Connects:      s.connects,
+		Subscriptions: []SubscriptionState{},
+	}
+	for _, sub := range s.subs {
+		h.Subscriptions = append(h.Subscriptions, sub.state)
+	}
+	sort.Slice(h.Subscriptions, func(i, k int) bool {
+		return h.Subscriptions[i].Topic < h.Subscriptions[k].Topic
+	})
+	return h
+}
@@ -0,0 +1,68 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package conn_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/mainflux/agent/pkg/conn"
+	"github.com/mainflux/mainflux/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+type client struct {
+	mqtt.Client
+	mu       sync.Mutex
+	topics   []string
+	handlers map[string]mqtt.MessageHandler
+}
+
+func (c *client) Subscribe(topic string, qos byte, h mqtt.MessageHandler) mqtt.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.topics = append(c.topics, topic)
+	if c.handlers == nil {
+		c.handlers = map[string]mqtt.MessageHandler{}
+	}
+	c.handlers[topic] = h
+	return &mqtt.DummyToken{}
+}
+
+func (c *client) handler(topic string) mqtt.MessageHandler {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.handlers[topic]
+}
+
+func TestSessionResubscribe(t *testing.T) {
+	s := conn.NewSession(logger.NewMock())
+	c := &client{}
+	h := func(mqtt.Client, mqtt.Message) {}
+
+	err := s.Subscribe("req", 0, h)
+	assert.Nil(t, err, "unexpected error subscribing before connect")
+	assert.False(t, s.Health().Healthy(), "expected unhealthy session before connect")
+
+	s.OnConnect(c)
+	assert.Equal(t, []string{"req"}, c.topics, "expected subscription on connect")
+	err = s.Subscribe("services/#", 0, h)
+	assert.Nil(t, err, "unexpected error subscribing while connected")
+	assert.True(t, s.Health().Healthy(), "expected healthy session")
+
+	s.OnConnectionLost(c, errors.New("broker restarted"))
+	health := s.Health()
+	assert.False(t, health.Connected, "expected disconnected session")
+	for _, sub := range health.Subscriptions {
+		assert.False(t, sub.Subscribed, "expected subscriptions down after connection loss")
+	}
+
+	s.OnConnect(c)
+	health = s.Health()
+	assert.True(t, health.Healthy(), "expected healthy session after reconnect")
+	assert.Equal(t, uint64(2), health.Connects, "unexpected number of connects")
+	assert.ElementsMatch(t, []string{"req", "services/#", "req", "services/#"}, c.topics, "expected resubscription on reconnect")
+}
@@ -0,0 +1,371 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package conn
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eclipse/paho.golang/packets"
+	"github.com/eclipse/paho.golang/paho"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/mainflux/agent/pkg/agent"
+	"github.com/mainflux/mainflux/pkg/errors"
+)
+
+// ProtocolV5 is the version of MQTT v5 protocol.
+const ProtocolV5 = 5
+
+const requestTimeout = 30 * time.Second
+
+var (
+	errPayloadType    = errors.New("unsupported payload type")
+	errBrokerScheme   = errors.New("unsupported broker URL scheme")
+	errDisconnected   = errors.New("disconnected by broker")
+	errV5Subscription = errors.New("subscription rejected by broker")
+)
+
+// replier is implemented by messages carrying MQTT v5 request-response properties.
+type replier interface {
+	Reply() agent.Reply
+}
+
+var _ mqtt.Client = (*v5Client)(nil)
+
+// v5Client is MQTT v5 client with the API of the paho v3 client, so that
+// failover, session and presence are shared by both protocol versions.
+// Operations complete before the token is returned, and reconnecting is
+// left to the failover client.
+type v5Client struct {
+	mu        sync.Mutex
+	opts      *mqtt.ClientOptions
+	router    *paho.StandardRouter
+	client    *paho.Client
+	connected bool
+}
+
+// newClient returns MQTT client of the protocol version.
+func newClient(protocol uint, opts *mqtt.ClientOptions) mqtt.Client {
+	if protocol == ProtocolV5 {
+		return &v5Client{opts: opts, router: paho.NewStandardRouter()}
+	}
+	return mqtt.NewClient(opts)
+}
+
+func (c *v5Client) IsConnected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connected
+}
+
+func (c *v5Client) IsConnectionOpen() bool {
+	return c.IsConnected()
+}
+
+func (c *v5Client) Connect() mqtt.Token {
+	return errToken{err: c.connect()}
+}
+
+func (c *v5Client) connect() error {
+	if len(c.opts.Servers) == 0 {
+		return errNoBrokers
+	}
+	timeout := c.opts.ConnectTimeout
+	if timeout <= 0 {
+		timeout = connectTimeout
+	}
+	nc, err := dial(c.opts.Servers[0], c.opts, timeout)
+	if err != nil {
+		return err
+	}
+
+	client := paho.NewClient(paho.ClientConfig{
+		ClientID: c.opts.ClientID,
+		Conn:     nc,
+		Router:   c.router,
+	})
+	client.OnClientError = func(err error) { c.lost(client, err) }
+	client.OnServerDisconnect = func(d *paho.Disconnect) {
+		c.lost(client, errors.Wrap(errDisconnected, fmt.Errorf("reason code %d", d.ReasonCode)))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if _, err := client.Connect(ctx, c.connectPacket()); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.client = client
+	c.connected = true
+	c.mu.Unlock()
+
+	if c.opts.OnConnect != nil {
+		go c.opts.OnConnect(c)
+	}
+	return nil
+}
+
+// connectPacket returns CONNECT packet of the client options. Session
+// of the persistent client doesn't expire when the connection is closed.
+func (c *v5Client) connectPacket() *paho.Connect {
+	cp := &paho.Connect{
+		ClientID:   c.opts.ClientID,
+		KeepAlive:  uint16(c.opts.KeepAlive),
+		CleanStart: c.opts.CleanSession,
+	}
+	if c.opts.Username != "" {
+		cp.Username = c.opts.Username
+		cp.UsernameFlag = true
+	}
+	if c.opts.Password != "" {
+		cp.Password = []byte(c.opts.Password)
+		cp.PasswordFlag = true
+	}
+	if !c.opts.CleanSession {
+		expiry := uint32(math.MaxUint32)
+		cp.Properties = &paho.ConnectProperties{SessionExpiryInterval: &expiry}
+	}
+	if c.opts.WillEnabled {
+		cp.WillMessage = &paho.WillMessage{
+			Topic:   c.opts.WillTopic,
+			Payload: c.opts.WillPayload,
+			QoS:     c.opts.WillQos,
+			Retain:  c.opts.WillRetained,
+		}
+	}
+	return cp
+}
+
+// lost marks the client disconnected, unless it's already reconnected.
+func (c *v5Client) lost(client *paho.Client, err error) {
+	c.mu.Lock()
+	if c.client != client || !c.connected {
+		c.mu.Unlock()
+		return
+	}
+	c.connected = false
+	c.mu.Unlock()
+
+	if c.opts.OnConnectionLost != nil {
+		c.opts.OnConnectionLost(c, err)
+	}
+}
+
+func (c *v5Client) Disconnect(quiesce uint) {
+	c.mu.Lock()
+	client := c.client
+	c.connected = false
+	c.mu.Unlock()
+	if client != nil {
+		client.Disconnect(&paho.Disconnect{})
+	}
+}
+
+func (c *v5Client) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	p := &paho.Publish{
+		Topic:  topic,
+		QoS:    qos,
+		Retain: retained,
+	}
+	switch v := payload.(type) {
+	case string:
+		p.Payload = []byte(v)
+	case []byte:
+		p.Payload = v
+	case agent.ReplyMessage:
+		p.Payload = v.Payload
+		p.Properties = &paho.PublishProperties{
+			CorrelationData: v.CorrelationData,
+			User:            userProperties(v.UserProperties),
+		}
+	default:
+		return errToken{err: errors.Wrap(errPayloadType, fmt.Errorf("%T", payload))}
+	}
+
+	client, err := c.active()
+	if err != nil {
+		return errToken{err: err}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	_, err = client.Publish(ctx, p)
+	return errToken{err: err}
+}
+
+func (c *v5Client) Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+	return c.SubscribeMultiple(map[string]byte{topic: qos}, callback)
+}
+
+func (c *v5Client) SubscribeMultiple(filters map[string]byte, callback mqtt.MessageHandler) mqtt.Token {
+	client, err := c.active()
+	if err != nil {
+		return errToken{err: err}
+	}
+	s := &paho.Subscribe{Subscriptions: map[string]paho.SubscribeOptions{}}
+	for topic, qos := range filters {
+		s.Subscriptions[topic] = paho.SubscribeOptions{QoS: qos}
+		if callback != nil {
+			c.AddRoute(topic, callback)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	if _, err := client.Subscribe(ctx, s); err != nil {
+		return errToken{err: errors.Wrap(errV5Subscription, err)}
+	}
+	return errToken{}
+}
+
+func (c *v5Client) Unsubscribe(topics ...string) mqtt.Token {
+	client, err := c.active()
+	if err != nil {
+		return errToken{err: err}
+	}
+	for _, topic := range topics {
+		c.router.UnregisterHandler(topic)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	_, err = client.Unsubscribe(ctx, &paho.Unsubscribe{Topics: topics})
+	return errToken{err: err}
+}
+
+func (c *v5Client) AddRoute(topic string, callback mqtt.MessageHandler) {
+	c.router.RegisterHandler(topic, func(p *paho.Publish) {
+		callback(c, v5Message{p: p})
+	})
+}
+
+func (c *v5Client) OptionsReader() mqtt.ClientOptionsReader {
+	// Reader can't be made outside of the paho package,
+	// so it's taken from the v3 client of the same options.
+	return mqtt.NewClient(c.opts).OptionsReader()
+}
+
+func (c *v5Client) active() (*paho.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.connected {
+		return nil, errNotConnected
+	}
+	return c.client, nil
+}
+
+// dial opens network connection to the broker with the
+// scheme of the broker URL, same as the v3 client does.
+func dial(u *url.URL, opts *mqtt.ClientOptions, timeout time.Duration) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	switch u.Scheme {
+	case "mqtt", "tcp":
+		nc, err := dialer.Dial("tcp", u.Host)
+		if err != nil {
+			return nil, err
+		}
+		return packets.NewThreadSafeConn(nc), nil
+	case "ssl", "tls", "mqtts", "mqtt+ssl", "tcps":
+		nc, err := tls.DialWithDialer(dialer, "tcp", u.Host, opts.TLSConfig)
+		if err != nil {
+			return nil, err
+		}
+		return packets.NewThreadSafeConn(nc), nil
+	case "ws", "wss":
+		var cfg *tls.Config
+		if u.Scheme == "wss" {
+			cfg = opts.TLSConfig
+		}
+		nc, err := mqtt.NewWebsocket(u.String(), cfg, timeout, opts.HTTPHeaders, opts.WebsocketOptions)
+		if err != nil {
+			return nil, err
+		}
+		return packets.NewThreadSafeConn(nc), nil
+	default:
+		return nil, errors.Wrap(errBrokerScheme, errors.New(u.Scheme))
+	}
+}
+
+func userProperties(props map[string]string) paho.UserProperties {
+	up := paho.UserProperties{}
+	for k, v := range props {
+		up.Add(k, v)
+	}
+	return up
+}
+
+var _ mqtt.Message = (*v5Message)(nil)
+
+// v5Message is MQTT v5 message with the API of the paho v3 message.
+type v5Message struct {
+	p *paho.Publish
+}
+
+func (m v5Message) Duplicate() bool {
+	return false
+}
+
+func (m v5Message) Qos() byte {
+	return m.p.QoS
+}
+
+func (m v5Message) Retained() bool {
+	return m.p.Retain
+}
+
+func (m v5Message) Topic() string {
+	return m.p.Topic
+}
+
+func (m v5Message) MessageID() uint16 {
+	return m.p.PacketID
+}
+
+func (m v5Message) Payload() []byte {
+	return m.p.Payload
+}
+
+// Ack is no-op, since messages are acknowledged by the client.
+func (m v5Message) Ack() {}
+
+// Reply returns request-response properties of the message. Response
+// topic is accepted only under the messages topic of the channel the
+// request came from, i.e. the control channel, so that responses can't
+// be redirected to other channels. Default response topic is used otherwise.
+func (m v5Message) Reply() agent.Reply {
+	r := agent.Reply{}
+	props := m.p.Properties
+	if props == nil {
+		return r
+	}
+	if prefix := messagesTopic(m.p.Topic); prefix != "" &&
+		len(props.ResponseTopic) > len(prefix) &&
+		strings.HasPrefix(props.ResponseTopic, prefix) &&
+		!strings.ContainsAny(props.ResponseTopic, "+#") {
+		r.Topic = props.ResponseTopic
+	}
+	r.CorrelationData = props.CorrelationData
+	if len(props.User) > 0 {
+		r.UserProperties = make(map[string]string, len(props.User))
+		for _, up := range props.User {
+			r.UserProperties[up.Key] = up.Value
+		}
+	}
+	return r
+}
+
+// messagesTopic returns channels/<channel>/messages/ prefix of the
+// topic, or empty string if the topic is not a channel messages topic.
+func messagesTopic(topic string) string {
+	tok := strings.SplitN(topic, "/", 4)
+	if len(tok) < 4 || tok[0] != "channels" || tok[1] == "" || tok[2] != "messages" {
+		return ""
+	}
+	return strings.Join(tok[:3], "/") + "/"
+}
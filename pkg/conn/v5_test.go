@@ -0,0 +1,121 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package conn_test
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.golang/packets"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/mainflux/agent/pkg/agent"
+	"github.com/mainflux/agent/pkg/conn"
+	"github.com/mainflux/mainflux/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+// serveV5 accepts single MQTT v5 connection, sends the request with
+// response properties once the client subscribes, and passes the
+// messages published by the client to the channel.
+func serveV5(l net.Listener, responseTopic string, published chan<- *packets.Publish) {
+	c, err := l.Accept()
+	if err != nil {
+		return
+	}
+	defer c.Close()
+	for {
+		cp, err := packets.ReadPacket(c)
+		if err != nil {
+			return
+		}
+		switch p := cp.Content.(type) {
+		case *packets.Connect:
+			(&packets.Connack{Properties: &packets.Properties{}}).WriteTo(c)
+		case *packets.Subscribe:
+			(&packets.Suback{PacketID: p.PacketID, Reasons: []byte{0}, Properties: &packets.Properties{}}).WriteTo(c)
+			req := &packets.Publish{
+				Topic:   reqTop,
+				Payload: []byte("ping"),
+				Properties: &packets.Properties{
+					ResponseTopic:   responseTopic,
+					CorrelationData: []byte("4f2a"),
+					User:            []packets.User{{Key: "command-version", Value: "2"}},
+				},
+			}
+			req.WriteTo(c)
+		case *packets.Publish:
+			published <- p
+		}
+	}
+}
+
+func TestV5Reply(t *testing.T) {
+	cases := []struct {
+		desc          string
+		responseTopic string
+		topic         string
+	}{
+		{desc: "reply on control channel", responseTopic: resTop + "/client-1", topic: resTop + "/client-1"},
+		{desc: "reply on other channel", responseTopic: "channels/other/messages/res", topic: resTop},
+		{desc: "reply on other topic", responseTopic: "res/client-1", topic: resTop},
+		{desc: "reply on wildcard topic", responseTopic: resTop + "/#", topic: resTop},
+		{desc: "reply on messages topic prefix", responseTopic: "channels/ctrl/messages/", topic: resTop},
+	}
+
+	for _, tc := range cases {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if !assert.Nil(t, err, fmt.Sprintf("%s: unexpected error listening: %v", tc.desc, err)) {
+			return
+		}
+		published := make(chan *packets.Publish, 1)
+		go serveV5(l, tc.responseTopic, published)
+
+		url := fmt.Sprintf("tcp://%s", l.Addr())
+		opts := mqtt.NewClientOptions().AddBroker(url).SetClientID("agent")
+		s := conn.NewSession(logger.NewMock())
+		f, err := conn.NewFailover([]conn.Endpoint{{URL: url, Protocol: conn.ProtocolV5, Options: opts}}, agent.FailoverConfig{}, s, logger.NewMock())
+		if !assert.Nil(t, err, fmt.Sprintf("%s: unexpected error creating client: %v", tc.desc, err)) {
+			l.Close()
+			return
+		}
+
+		// Agent responds on the default response topic
+		// if the request has no response topic.
+		err = s.Subscribe(reqTop, 0, func(c mqtt.Client, m mqtt.Message) {
+			r := m.(interface{ Reply() agent.Reply }).Reply()
+			topic := r.Topic
+			if topic == "" {
+				topic = resTop
+			}
+			c.Publish(topic, 0, false, agent.ReplyMessage{
+				Payload:         []byte("pong"),
+				CorrelationData: r.CorrelationData,
+				UserProperties:  r.UserProperties,
+			})
+		})
+		assert.Nil(t, err, fmt.Sprintf("%s: unexpected error subscribing: %v", tc.desc, err))
+
+		token := f.Connect()
+		token.Wait()
+		if !assert.Nil(t, token.Error(), fmt.Sprintf("%s: unexpected error connecting: %v", tc.desc, token.Error())) {
+			l.Close()
+			return
+		}
+
+		select {
+		case p := <-published:
+			assert.Equal(t, tc.topic, p.Topic, fmt.Sprintf("%s: unexpected reply topic", tc.desc))
+			assert.Equal(t, "pong", string(p.Payload), fmt.Sprintf("%s: unexpected reply payload", tc.desc))
+			assert.Equal(t, []byte("4f2a"), p.Properties.CorrelationData, fmt.Sprintf("%s: expected correlation data of the request", tc.desc))
+			assert.Equal(t, []packets.User{{Key: "command-version", Value: "2"}}, p.Properties.User, fmt.Sprintf("%s: expected user properties of the request", tc.desc))
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, fmt.Sprintf("%s: expected reply to the request", tc.desc))
+		}
+		assert.True(t, s.Health().Healthy(), fmt.Sprintf("%s: expected healthy session", tc.desc))
+		f.Disconnect(0)
+		l.Close()
+	}
+}
@@ -1,26 +1,143 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+// Package encoder contains encoders and decoders of the agent message payloads.
 package encoder
 
 import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"strings"
 	"time"
 
+	"github.com/mainflux/mainflux/pkg/errors"
 	"github.com/mainflux/senml"
 )
 
-func EncodeSenML(bn, n, sv string) ([]byte, error) {
-	ts := float64(time.Now().UnixNano()) / float64(time.Second)
-	s := senml.Pack{
-		Records: []senml.Record{
-			senml.Record{
-				BaseName:    bn,
-				Name:        n,
-				Time:        ts,
-				StringValue: &sv,
-			},
-		},
+const (
+	// SenMLJSON is name of SenML JSON payload format.
+	SenMLJSON = "senml+json"
+	// SenMLCBOR is name of SenML CBOR payload format.
+	SenMLCBOR = "senml+cbor"
+	// PlainJSON is name of plain JSON payload format.
+	PlainJSON = "plain+json"
+	// Raw is name of raw payload format.
+	Raw = "raw"
+
+	jsonAlias = "json"
+	cborAlias = "cbor"
+
+	// CBOR major type is in the high 3 bits of the first byte.
+	cborTypeMask = 0xe0
+	cborArray    = 0x80
+)
+
+// ErrUnknownFormat indicates unsupported payload format name.
+var ErrUnknownFormat = errors.New("unknown payload format")
+
+// Encoder encodes records into message payload.
+type Encoder interface {
+	// Encode encodes records, setting the base name on records without one.
+	Encode(bn string, records []senml.Record) ([]byte, error)
+}
+
+// Decoder decodes message payload into records.
+type Decoder interface {
+	// Decode decodes records from the payload.
+	Decode(payload []byte) ([]senml.Record, error)
+}
+
+// Codec encodes and decodes payloads of the single format.
+type Codec interface {
+	Encoder
+	Decoder
+}
+
+// New returns codec of the payload format with the given name. Empty name
+// and json stand for SenML JSON, and cbor is accepted as an alias for SenML CBOR.
+func New(name string) (Codec, error) {
+	switch strings.ToLower(name) {
+	case "", SenMLJSON, jsonAlias:
+		return senmlCodec{format: senml.JSON}, nil
+	case SenMLCBOR, cborAlias:
+		return senmlCodec{format: senml.CBOR}, nil
+	case PlainJSON:
+		return jsonCodec{}, nil
+	case Raw:
+		return rawCodec{}, nil
+	default:
+		return nil, errors.Wrap(ErrUnknownFormat, fmt.Errorf("%s", name))
+	}
+}
+
+// Detect returns codec of the SenML payload. SenML pack is an array,
+// so JSON payloads start with '[' after optional whitespace, and CBOR
+// payloads start with the array header. Payloads starting with anything
+// else, JSON objects included, are of unknown format.
+func Detect(payload []byte) (Codec, error) {
+	p := bytes.TrimLeft(payload, " \t\r\n")
+	switch {
+	case len(p) == 0:
+		return nil, errors.Wrap(ErrUnknownFormat, errors.New("empty payload"))
+	case p[0] == '[':
+		return senmlCodec{format: senml.JSON}, nil
+	case p[0] == '{':
+		return nil, errors.Wrap(ErrUnknownFormat, errors.New("JSON object is not SenML pack"))
+	case len(p) == len(payload) && p[0]&cborTypeMask == cborArray:
+		return senmlCodec{format: senml.CBOR}, nil
+	default:
+		return nil, errors.Wrap(ErrUnknownFormat, fmt.Errorf("unexpected first byte 0x%02x", p[0]))
 	}
-	payload, err := senml.Encode(s, senml.JSON)
+}
+
+// String returns record with string value.
+func String(n, v string) senml.Record {
+	return senml.Record{Name: n, StringValue: &v}
+}
+
+// Number returns record with numeric value in the given unit.
+func Number(n string, v float64, unit string) senml.Record {
+	return senml.Record{Name: n, Unit: unit, Value: &v}
+}
+
+// Bool returns record with boolean value.
+func Bool(n string, v bool) senml.Record {
+	return senml.Record{Name: n, BoolValue: &v}
+}
+
+// Data returns record with data value, base64 encoded with URL safe alphabet.
+func Data(n string, v []byte) senml.Record {
+	vd := base64.RawURLEncoding.EncodeToString(v)
+	return senml.Record{Name: n, DataValue: &vd}
+}
+
+// DecodeData decodes SenML data value. Data values are base64 encoded
+// with URL safe alphabet, padding and standard alphabet are tolerated.
+func DecodeData(vd string) (string, error) {
+	vd = strings.TrimRight(vd, "=")
+	vd = strings.NewReplacer("+", "-", "/", "_").Replace(vd)
+	b, err := base64.RawURLEncoding.DecodeString(vd)
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	return string(b), nil
+}
+
+// complete sets base name on every record without one, so that each
+// record can be correlated with the request. Records without time are
+// timestamped with current time.
+func complete(bn string, records []senml.Record) []senml.Record {
+	ts := float64(time.Now().UnixNano()) / float64(time.Second)
+	ret := make([]senml.Record, len(records))
+	for i, r := range records {
+		if r.BaseName == "" {
+			r.BaseName = bn
+		}
+		if r.Time == 0 {
+			r.Time = ts
+		}
+		ret[i] = r
 	}
-	return payload, nil
+	return ret
 }
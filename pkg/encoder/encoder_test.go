@@ -0,0 +1,111 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package encoder_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/mainflux/agent/pkg/encoder"
+	"github.com/mainflux/mainflux/pkg/errors"
+	"github.com/mainflux/senml"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	cases := []struct {
+		desc string
+		name string
+		err  bool
+	}{
+		{desc: "default format", name: ""},
+		{desc: "senml json format", name: encoder.SenMLJSON},
+		{desc: "senml cbor format", name: encoder.SenMLCBOR},
+		{desc: "cbor alias", name: "CBOR"},
+		{desc: "json alias", name: "json"},
+		{desc: "plain json format", name: encoder.PlainJSON},
+		{desc: "raw format", name: encoder.Raw},
+		{desc: "unknown format", name: "xml", err: true},
+	}
+
+	for _, tc := range cases {
+		_, err := encoder.New(tc.name)
+		assert.Equal(t, tc.err, err != nil, fmt.Sprintf("%s: unexpected error %v", tc.desc, err))
+	}
+}
+
+func TestCodec(t *testing.T) {
+	records := []senml.Record{
+		encoder.String("status", "done"),
+		encoder.Number("duration", 1.5, "s"),
+		encoder.Bool("truncated", true),
+		encoder.Data("stdout", []byte("out")),
+	}
+
+	for _, name := range []string{encoder.SenMLJSON, encoder.SenMLCBOR, encoder.PlainJSON} {
+		c, err := encoder.New(name)
+		assert.Nil(t, err, fmt.Sprintf("%s: unexpected error: %v", name, err))
+		payload, err := c.Encode("1:", records)
+		assert.Nil(t, err, fmt.Sprintf("%s: unexpected error: %v", name, err))
+		recs, err := c.Decode(payload)
+		assert.Nil(t, err, fmt.Sprintf("%s: unexpected error: %v", name, err))
+		if !assert.Len(t, recs, len(records), name) {
+			continue
+		}
+		assert.Equal(t, "1:", recs[0].BaseName, name)
+		assert.Equal(t, "done", *recs[0].StringValue, name)
+		assert.Equal(t, 1.5, *recs[1].Value, name)
+		assert.Equal(t, "s", recs[1].Unit, name)
+		assert.True(t, *recs[2].BoolValue, name)
+	}
+
+	c, _ := encoder.New(encoder.Raw)
+	payload, err := c.Encode("1:", records)
+	assert.Nil(t, err, fmt.Sprintf("raw: unexpected error: %v", err))
+	assert.Equal(t, "done\n1.5\ntrue\nout", string(payload))
+
+	c, _ = encoder.New("json")
+	payload, err = c.Encode("1:", records[:1])
+	assert.Nil(t, err, fmt.Sprintf("json: unexpected error: %v", err))
+	assert.Contains(t, string(payload), `"bn":"1:"`, "expected json alias to encode SenML JSON")
+}
+
+func TestDetect(t *testing.T) {
+	for _, name := range []string{encoder.SenMLJSON, encoder.SenMLCBOR} {
+		c, _ := encoder.New(name)
+		payload, err := c.Encode("1:", []senml.Record{encoder.String("exec", "ls")})
+		assert.Nil(t, err, fmt.Sprintf("%s: unexpected error: %v", name, err))
+		c, err = encoder.Detect(payload)
+		if !assert.Nil(t, err, fmt.Sprintf("%s: unexpected error: %v", name, err)) {
+			continue
+		}
+		recs, err := c.Decode(payload)
+		assert.Nil(t, err, fmt.Sprintf("%s: unexpected error: %v", name, err))
+		if assert.Len(t, recs, 1, name) {
+			assert.Equal(t, "ls", *recs[0].StringValue, name)
+		}
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	cases := []struct {
+		desc    string
+		payload string
+		err     error
+	}{
+		{desc: "SenML JSON with leading whitespace", payload: " \n[{\"n\":\"exec\",\"vs\":\"ls\"}]"},
+		{desc: "JSON object", payload: `{"n":"exec","vs":"ls"}`, err: encoder.ErrUnknownFormat},
+		{desc: "JSON object with leading whitespace", payload: "\t {}", err: encoder.ErrUnknownFormat},
+		{desc: "plain text", payload: "ls,-l", err: encoder.ErrUnknownFormat},
+		{desc: "CBOR map", payload: "\xa1\x61n\x64exec", err: encoder.ErrUnknownFormat},
+		{desc: "CBOR array with leading whitespace", payload: " \x81\xa0", err: encoder.ErrUnknownFormat},
+		{desc: "whitespace", payload: " \r\n", err: encoder.ErrUnknownFormat},
+		{desc: "empty payload", payload: "", err: encoder.ErrUnknownFormat},
+	}
+
+	for _, tc := range cases {
+		_, err := encoder.Detect([]byte(tc.payload))
+		assert.True(t, errors.Contains(err, tc.err), fmt.Sprintf("%s: expected %v got %v", tc.desc, tc.err, err))
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package encoder
+
+import (
+	"encoding/json"
+
+	"github.com/mainflux/senml"
+)
+
+var _ Codec = (*jsonCodec)(nil)
+
+// jsonRecord is record in plain JSON format, holding the value of any type.
+type jsonRecord struct {
+	BaseName string          `json:"base_name,omitempty"`
+	Name     string          `json:"name"`
+	Time     float64         `json:"time,omitempty"`
+	Unit     string          `json:"unit,omitempty"`
+	Value    json.RawMessage `json:"value"`
+}
+
+// jsonCodec encodes records as JSON array of objects with native values.
+// Data values are encoded as decoded strings.
+type jsonCodec struct{}
+
+func (jsonCodec) Encode(bn string, records []senml.Record) ([]byte, error) {
+	recs := []jsonRecord{}
+	for _, r := range complete(bn, records) {
+		v, err := json.Marshal(value(r))
+		if err != nil {
+			return nil, err
+		}
+		recs = append(recs, jsonRecord{
+			BaseName: r.BaseName,
+			Name:     r.Name,
+			Time:     r.Time,
+			Unit:     r.Unit,
+			Value:    v,
+		})
+	}
+	return json.Marshal(recs)
+}
+
+// Decode decodes string, numeric and boolean values into matching
+// record values, while objects and arrays are kept as JSON strings.
+func (jsonCodec) Decode(payload []byte) ([]senml.Record, error) {
+	var recs []jsonRecord
+	if err := json.Unmarshal(payload, &recs); err != nil {
+		return nil, err
+	}
+	ret := []senml.Record{}
+	for _, jr := range recs {
+		r := senml.Record{
+			BaseName: jr.BaseName,
+			Name:     jr.Name,
+			Time:     jr.Time,
+			Unit:     jr.Unit,
+		}
+		var v interface{}
+		if err := json.Unmarshal(jr.Value, &v); err != nil {
+			return nil, err
+		}
+		switch v := v.(type) {
+		case string:
+			r.StringValue = &v
+		case float64:
+			r.Value = &v
+		case bool:
+			r.BoolValue = &v
+		case nil:
+		default:
+			s := string(jr.Value)
+			r.StringValue = &s
+		}
+		ret = append(ret, r)
+	}
+	return ret, nil
+}
+
+// value returns the record value of any type, or nil if record has no value.
+func value(r senml.Record) interface{} {
+	switch {
+	case r.StringValue != nil:
+		return *r.StringValue
+	case r.Value != nil:
+		return *r.Value
+	case r.BoolValue != nil:
+		return *r.BoolValue
+	case r.DataValue != nil:
+		if d, err := DecodeData(*r.DataValue); err == nil {
+			return d
+		}
+		return *r.DataValue
+	case r.Sum != nil:
+		return *r.Sum
+	default:
+		return nil
+	}
+}
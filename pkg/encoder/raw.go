@@ -0,0 +1,36 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package encoder
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/mainflux/senml"
+)
+
+var _ Codec = (*rawCodec)(nil)
+
+// rawCodec encodes record values as they are, separated by new line, which
+// suits streams such as terminal output. Names and base names are dropped.
+type rawCodec struct{}
+
+func (rawCodec) Encode(_ string, records []senml.Record) ([]byte, error) {
+	var buf bytes.Buffer
+	for i, r := range records {
+		if i > 0 {
+			buf.WriteByte('\n')
+		}
+		if v := value(r); v != nil {
+			fmt.Fprint(&buf, v)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode returns single record with the payload as string value.
+func (rawCodec) Decode(payload []byte) ([]senml.Record, error) {
+	s := string(payload)
+	return []senml.Record{{StringValue: &s}}, nil
+}
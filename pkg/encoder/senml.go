@@ -0,0 +1,25 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package encoder
+
+import "github.com/mainflux/senml"
+
+var _ Codec = (*senmlCodec)(nil)
+
+// senmlCodec encodes records as SenML pack in JSON or CBOR format.
+type senmlCodec struct {
+	format senml.Format
+}
+
+func (c senmlCodec) Encode(bn string, records []senml.Record) ([]byte, error) {
+	return senml.Encode(senml.Pack{Records: complete(bn, records)}, c.format)
+}
+
+func (c senmlCodec) Decode(payload []byte) ([]senml.Record, error) {
+	p, err := senml.Decode(payload, c.format)
+	if err != nil {
+		return nil, err
+	}
+	return p.Records, nil
+}
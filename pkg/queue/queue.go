@@ -0,0 +1,268 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+// Package queue provides disk-backed FIFO queue of outbound messages.
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mainflux/mainflux/pkg/errors"
+)
+
+const ext = ".msg"
+
+var (
+	// ErrEmpty indicates that there are no queued messages.
+	ErrEmpty = errors.New("queue is empty")
+
+	// ErrTooLarge indicates that message is larger than the queue size limit.
+	ErrTooLarge = errors.New("message larger than queue")
+
+	errQueue = errors.New("queue failure")
+)
+
+// Message is a queued outbound message. Correlation data and user
+// properties are set on the responses to MQTT v5 commands.
+type Message struct {
+	Topic           string            `json:"topic"`
+	Payload         string            `json:"payload"`
+	CorrelationData []byte            `json:"correlation_data,omitempty"`
+	UserProperties  map[string]string `json:"user_properties,omitempty"`
+	Time            time.Time         `json:"time"`
+}
+
+// Stats holds queue counters.
+type Stats struct {
+	// Depth is the number of queued messages.
+	Depth int `json:"depth"`
+
+	// Size is the size of queued messages in bytes.
+	Size int64 `json:"size"`
+
+	// Forwarded is the number of messages removed from the queue after sending.
+	Forwarded uint64 `json:"forwarded"`
+
+	// DroppedFull is the number of messages dropped to keep the queue within size limit.
+	DroppedFull uint64 `json:"dropped_full"`
+
+	// DroppedExpired is the number of messages dropped for being older than age limit.
+	DroppedExpired uint64 `json:"dropped_expired"`
+}
+
+// Queue is a FIFO queue of outbound messages.
+type Queue interface {
+	// Push appends message to the queue, dropping the oldest messages
+	// if the queue would exceed its size limit.
+	Push(Message) error
+
+	// Peek returns the oldest message along with its sequence number,
+	// dropping expired ones.
+	Peek() (uint64, Message, error)
+
+	// Pop removes the message with the sequence number after it is sent.
+	// It does nothing if the message was dropped in the meantime.
+	Pop(seq uint64) error
+
+	// Len returns the number of queued messages.
+	Len() int
+
+	// Stats returns queue counters.
+	Stats() Stats
+}
+
+var _ Queue = (*queue)(nil)
+
+type entry struct {
+	seq  uint64
+	size int64
+	time time.Time
+}
+
+// queue keeps each message in its own file, named by the message
+// sequence number, so that the queue survives agent restarts.
+type queue struct {
+	mu      sync.Mutex
+	dir     string
+	maxSize int64
+	maxAge  time.Duration
+	entries []entry
+	seq     uint64
+	stats   Stats
+}
+
+// New returns queue stored in dir, loading messages left from previous run.
+// Zero max size or max age means no limit.
+func New(dir string, maxSize int64, maxAge time.Duration) (Queue, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(errQueue, err)
+	}
+	q := &queue{
+		dir:     dir,
+		maxSize: maxSize,
+		maxAge:  maxAge,
+	}
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+func (q *queue) load() error {
+	files, err := os.ReadDir(q.dir)
+	if err != nil {
+		return errors.Wrap(errQueue, err)
+	}
+	for _, f := range files {
+		name := f.Name()
+		if strings.HasSuffix(name, ".tmp") {
+			// Leftover of interrupted push.
+			os.Remove(filepath.Join(q.dir, name))
+			continue
+		}
+		if f.IsDir() || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, ext), 10, 64)
+		if err != nil {
+			continue
+		}
+		m, err := q.read(seq)
+		if err != nil {
+			os.Remove(q.path(seq))
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			return errors.Wrap(errQueue, err)
+		}
+		q.entries = append(q.entries, entry{seq: seq, size: info.Size(), time: m.Time})
+		q.stats.Size += info.Size()
+		if seq > q.seq {
+			q.seq = seq
+		}
+	}
+	sort.Slice(q.entries, func(i, k int) bool {
+		return q.entries[i].seq < q.entries[k].seq
+	})
+	q.stats.Depth = len(q.entries)
+	return nil
+}
+
+func (q *queue) Push(m Message) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return errors.Wrap(errQueue, err)
+	}
+	size := int64(len(b))
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.maxSize > 0 && size > q.maxSize {
+		q.stats.DroppedFull++
+		return ErrTooLarge
+	}
+	for q.maxSize > 0 && len(q.entries) > 0 && q.stats.Size+size > q.maxSize {
+		q.remove()
+		q.stats.DroppedFull++
+	}
+
+	seq := q.seq + 1
+	tmp := q.path(seq) + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return errors.Wrap(errQueue, err)
+	}
+	if err := os.Rename(tmp, q.path(seq)); err != nil {
+		os.Remove(tmp)
+		return errors.Wrap(errQueue, err)
+	}
+	q.seq = seq
+	q.entries = append(q.entries, entry{seq: seq, size: size, time: m.Time})
+	q.stats.Size += size
+	q.stats.Depth = len(q.entries)
+	return nil
+}
+
+func (q *queue) Peek() (uint64, Message, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.entries) > 0 {
+		e := q.entries[0]
+		if q.maxAge > 0 && time.Since(e.time) > q.maxAge {
+			q.remove()
+			q.stats.DroppedExpired++
+			continue
+		}
+		m, err := q.read(e.seq)
+		if err != nil {
+			// Unreadable message can't ever be sent, so drop it.
+			q.remove()
+			return 0, Message{}, err
+		}
+		return e.seq, m, nil
+	}
+	return 0, Message{}, ErrEmpty
+}
+
+func (q *queue) Pop(seq uint64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, e := range q.entries {
+		if e.seq == seq {
+			q.removeAt(i)
+			q.stats.Forwarded++
+			return nil
+		}
+	}
+	return nil
+}
+
+func (q *queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.entries)
+}
+
+func (q *queue) Stats() Stats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.stats
+}
+
+// remove deletes the oldest message. Caller must hold the lock.
+func (q *queue) remove() {
+	q.removeAt(0)
+}
+
+// removeAt deletes the message at the index. Caller must hold the lock.
+func (q *queue) removeAt(i int) {
+	e := q.entries[i]
+	os.Remove(q.path(e.seq))
+	q.entries = append(q.entries[:i], q.entries[i+1:]...)
+	q.stats.Size -= e.size
+	q.stats.Depth = len(q.entries)
+}
+
+func (q *queue) read(seq uint64) (Message, error) {
+	b, err := os.ReadFile(q.path(seq))
+	if err != nil {
+		return Message{}, errors.Wrap(errQueue, err)
+	}
+	m := Message{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return Message{}, errors.Wrap(errQueue, err)
+	}
+	return m, nil
+}
+
+func (q *queue) path(seq uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%s", seq, ext))
+}
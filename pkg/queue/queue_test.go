@@ -0,0 +1,103 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package queue_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mainflux/agent/pkg/queue"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueue(t *testing.T) {
+	dir := t.TempDir()
+	q, err := queue.New(dir, 0, 0)
+	assert.Nil(t, err, fmt.Sprintf("unexpected error creating queue: %s", err))
+
+	_, _, err = q.Peek()
+	assert.Equal(t, queue.ErrEmpty, err, fmt.Sprintf("expected %s got %s", queue.ErrEmpty, err))
+
+	for i := 0; i < 3; i++ {
+		err := q.Push(queue.Message{Topic: "res", Payload: fmt.Sprintf("msg%d", i), Time: time.Now()})
+		assert.Nil(t, err, fmt.Sprintf("unexpected error pushing message: %s", err))
+	}
+	seq, m, err := q.Peek()
+	assert.Nil(t, err, fmt.Sprintf("unexpected error peeking message: %s", err))
+	assert.Equal(t, "msg0", m.Payload, "expected the oldest message")
+	err = q.Pop(seq)
+	assert.Nil(t, err, fmt.Sprintf("unexpected error popping message: %s", err))
+
+	// Reopened queue keeps the order of remaining messages.
+	q, err = queue.New(dir, 0, 0)
+	assert.Nil(t, err, fmt.Sprintf("unexpected error reopening queue: %s", err))
+	assert.Equal(t, 2, q.Len(), "unexpected queue length after reopening")
+	err = q.Push(queue.Message{Topic: "res", Payload: "msg3", Time: time.Now()})
+	assert.Nil(t, err, fmt.Sprintf("unexpected error pushing message: %s", err))
+	for _, exp := range []string{"msg1", "msg2", "msg3"} {
+		seq, m, err := q.Peek()
+		assert.Nil(t, err, fmt.Sprintf("unexpected error peeking message: %s", err))
+		assert.Equal(t, exp, m.Payload, "unexpected message order")
+		q.Pop(seq)
+	}
+	assert.Equal(t, queue.Stats{Forwarded: 3}, q.Stats(), "unexpected stats")
+}
+
+func TestQueueLimits(t *testing.T) {
+	q, err := queue.New(t.TempDir(), 400, time.Minute)
+	assert.Nil(t, err, fmt.Sprintf("unexpected error creating queue: %s", err))
+
+	payload := strings.Repeat("x", 100)
+	for i := 0; i < 3; i++ {
+		err := q.Push(queue.Message{Topic: "res", Payload: fmt.Sprintf("%d%s", i, payload), Time: time.Now()})
+		assert.Nil(t, err, fmt.Sprintf("unexpected error pushing message: %s", err))
+	}
+	assert.Equal(t, 2, q.Len(), "expected the oldest message dropped to stay within size")
+	_, m, _ := q.Peek()
+	assert.True(t, strings.HasPrefix(m.Payload, "1"), "expected the oldest message dropped")
+
+	err = q.Push(queue.Message{Topic: "res", Payload: strings.Repeat("x", 500)})
+	assert.Equal(t, queue.ErrTooLarge, err, fmt.Sprintf("expected %s got %s", queue.ErrTooLarge, err))
+
+	q, err = queue.New(t.TempDir(), 0, time.Minute)
+	assert.Nil(t, err, fmt.Sprintf("unexpected error creating queue: %s", err))
+	q.Push(queue.Message{Topic: "res", Payload: "old", Time: time.Now().Add(-2 * time.Minute)})
+	q.Push(queue.Message{Topic: "res", Payload: "new", Time: time.Now()})
+	_, m, err = q.Peek()
+	assert.Nil(t, err, fmt.Sprintf("unexpected error peeking message: %s", err))
+	assert.Equal(t, "new", m.Payload, "expected expired message dropped")
+	assert.Equal(t, uint64(1), q.Stats().DroppedExpired, "unexpected expired drop count")
+}
+
+func TestQueuePopDropped(t *testing.T) {
+	q, err := queue.New(t.TempDir(), 400, 0)
+	assert.Nil(t, err, fmt.Sprintf("unexpected error creating queue: %s", err))
+
+	payload := strings.Repeat("x", 100)
+	for i := 0; i < 2; i++ {
+		err := q.Push(queue.Message{Topic: "res", Payload: fmt.Sprintf("%d%s", i, payload), Time: time.Now()})
+		assert.Nil(t, err, fmt.Sprintf("unexpected error pushing message: %s", err))
+	}
+	// Message being sent is dropped by the push to the full queue.
+	seq, m, err := q.Peek()
+	assert.Nil(t, err, fmt.Sprintf("unexpected error peeking message: %s", err))
+	assert.True(t, strings.HasPrefix(m.Payload, "0"), "expected the oldest message")
+	err = q.Push(queue.Message{Topic: "res", Payload: "2" + payload, Time: time.Now()})
+	assert.Nil(t, err, fmt.Sprintf("unexpected error pushing message: %s", err))
+
+	err = q.Pop(seq)
+	assert.Nil(t, err, fmt.Sprintf("unexpected error popping dropped message: %s", err))
+	assert.Equal(t, 2, q.Len(), "expected pop of the dropped message to keep the queue")
+	for _, exp := range []string{"1", "2"} {
+		seq, m, err := q.Peek()
+		assert.Nil(t, err, fmt.Sprintf("unexpected error peeking message: %s", err))
+		assert.True(t, strings.HasPrefix(m.Payload, exp), fmt.Sprintf("expected message %s, got %s", exp, m.Payload[:1]))
+		q.Pop(seq)
+	}
+	stats := q.Stats()
+	assert.Equal(t, uint64(2), stats.Forwarded, "expected only sent messages counted as forwarded")
+	assert.Equal(t, uint64(1), stats.DroppedFull, "unexpected full drop count")
+}
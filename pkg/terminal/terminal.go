@@ -14,6 +14,7 @@ import (
 	"github.com/mainflux/agent/pkg/encoder"
 	"github.com/mainflux/mainflux/logger"
 	"github.com/mainflux/mainflux/pkg/errors"
+	"github.com/mainflux/senml"
 )
 
 const (
@@ -26,6 +27,7 @@ type term struct {
 	ptmx         *os.File
 	done         chan bool
 	topic        string
+	encoder      encoder.Encoder
 	timeout      time.Duration
 	resetTimeout time.Duration
 	timer        *time.Ticker
@@ -40,11 +42,12 @@ type Session interface {
 	io.Writer
 }
 
-func NewSession(uuid string, timeout time.Duration, publish func(channel, payload string) error, logger logger.Logger) (Session, error) {
+func NewSession(uuid string, timeout time.Duration, enc encoder.Encoder, publish func(channel, payload string) error, logger logger.Logger) (Session, error) {
 	t := &term{
 		logger:       logger,
 		uuid:         uuid,
 		publish:      publish,
+		encoder:      enc,
 		timeout:      timeout,
 		resetTimeout: timeout,
 		topic:        fmt.Sprintf("term/%s", uuid),
@@ -104,7 +107,7 @@ func (t *term) IsDone() chan bool {
 func (t *term) Write(p []byte) (int, error) {
 	t.resetCounter(t.resetTimeout)
 	n := len(p)
-	payload, err := encoder.EncodeSenML(t.uuid, terminal, string(p))
+	payload, err := t.encoder.Encode(t.uuid, []senml.Record{encoder.String(terminal, string(p))})
 	if err != nil {
 		return n, err
 	}